@@ -0,0 +1,43 @@
+// Command rekey re-encrypts files written by the database with a new key.
+//
+// Keys are read from DB_ENCRYPTION_KEYS as comma separated id:base64-key
+// pairs and must include the keys the files are currently encrypted with:
+//
+//	DB_ENCRYPTION_KEYS=old:...,new:... rekey -key-id new FILE...
+//
+// Files must not be in use by a running server.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"example/pkg"
+)
+
+func main() {
+	keyID := flag.String("key-id", os.Getenv("DB_ENCRYPTION_KEY_ID"), "ID of the key to re-encrypt the files with")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: rekey -key-id ID FILE...")
+		os.Exit(2)
+	}
+	keys, err := pkg.ParseKeyring(*keyID, os.Getenv("DB_ENCRYPTION_KEYS"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid keys: %s\n", err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, path := range flag.Args() {
+		if err := pkg.RotateFile(path, keys); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to re-encrypt %s\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("re-encrypted %s with key %s\n", path, keys.ActiveKeyID())
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
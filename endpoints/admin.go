@@ -0,0 +1,167 @@
+package endpoints
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"example/models"
+	"example/pkg"
+	"example/pkg/query"
+	"example/services"
+)
+
+// Admin is the endpoint for administrative tools. Requests must carry the
+// admin token as a bearer token; without a token every request is refused.
+type Admin struct {
+	queryService services.Query
+	planService  services.Plan
+	token        string
+}
+
+type queryRequest struct {
+	Query string `json:"query"`
+}
+
+type createPlanRequest struct {
+	Type models.PlanType `json:"type"`
+	Name string          `json:"name"`
+	// Amounts holds the price in each currency the plan is sold in
+	Amounts    []models.Money `json:"amounts"`
+	PeriodDays int            `json:"period_days"`
+}
+
+// updatePlanRequest changes the fields it sets. New amounts or a new period
+// start a new price version.
+type updatePlanRequest struct {
+	Name       *string        `json:"name"`
+	Amounts    []models.Money `json:"amounts"`
+	PeriodDays *int           `json:"period_days"`
+}
+
+// NewAdmin returns a new admin endpoint
+func NewAdmin(q services.Query, plans services.Plan, token string) *Admin {
+	return &Admin{
+		queryService: q,
+		planService:  plans,
+		token:        token,
+	}
+}
+
+// Register registers the admin endpoint
+func (a *Admin) Register(g *echo.Group) {
+	g.Use(a.authorize)
+	g.POST("/query", a.Query)
+	g.GET("/plans", a.FindPlans)
+	g.POST("/plans", a.CreatePlan)
+	g.PUT("/plans/:type", a.UpdatePlan)
+	g.POST("/plans/:type/archive", a.ArchivePlan)
+	g.GET("/plans/:type/prices", a.FindPlanPrices)
+}
+
+func (a *Admin) authorize(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "admin token required")
+		}
+		return next(c)
+	}
+}
+
+func (a *Admin) Query(c echo.Context) error {
+	var req queryRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+	}
+	if req.Query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "query is required")
+	}
+	res, err := a.queryService.Run(req.Query)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, query.ErrSyntax) || errors.Is(err, query.ErrUnknownColumn) ||
+			errors.Is(err, query.ErrInvalidQuery) || errors.Is(err, pkg.ErrorNoTable) {
+			statusCode = http.StatusBadRequest
+		}
+		return echo.NewHTTPError(statusCode, err.Error())
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// FindPlans lists the plans of the catalog, archived ones included
+func (a *Admin) FindPlans(c echo.Context) error {
+	plans, err := a.planService.Find(true)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, plans)
+}
+
+func (a *Admin) CreatePlan(c echo.Context) error {
+	var req createPlanRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+	}
+	plan, err := a.planService.Create(req.Type, req.Name, req.Amounts, req.PeriodDays)
+	if err != nil {
+		return planError(err)
+	}
+	return c.JSON(http.StatusOK, plan)
+}
+
+// UpdatePlan renames a plan or prices it at a new version
+func (a *Admin) UpdatePlan(c echo.Context) error {
+	var req updatePlanRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+	}
+	plan, err := a.planService.Update(models.PlanType(c.Param("type")), services.PlanUpdate{
+		Name:       req.Name,
+		Amounts:    req.Amounts,
+		PeriodDays: req.PeriodDays,
+	})
+	if err != nil {
+		return planError(err)
+	}
+	return c.JSON(http.StatusOK, plan)
+}
+
+// ArchivePlan closes a plan to new subscribers
+func (a *Admin) ArchivePlan(c echo.Context) error {
+	plan, err := a.planService.Archive(models.PlanType(c.Param("type")))
+	if err != nil {
+		return planError(err)
+	}
+	return c.JSON(http.StatusOK, plan)
+}
+
+// FindPlanPrices lists the price versions of a plan
+func (a *Admin) FindPlanPrices(c echo.Context) error {
+	prices, err := a.planService.GetPrices(models.PlanType(c.Param("type")))
+	if err != nil {
+		return planError(err)
+	}
+	return c.JSON(http.StatusOK, prices)
+}
+
+// planError returns the HTTP error of an error of the plan service.
+func planError(err error) *echo.HTTPError {
+	if he := validationError(err); he != nil {
+		return he
+	}
+	switch {
+	case errors.Is(err, pkg.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrPlanExists), errors.Is(err, services.ErrPlanArchived):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrInvalidPrice), errors.Is(err, services.ErrFreePlanFixed),
+		errors.Is(err, services.ErrPlanNotChanged), errors.Is(err, models.ErrUnknownCurrency):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
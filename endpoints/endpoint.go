@@ -1,7 +1,49 @@
 package endpoints
 
-import "github.com/labstack/echo/v4"
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"example/pkg"
+)
 
 type Endpoint interface {
 	Register(g *echo.Group)
 }
+
+// streamJSON writes every value passed to emit as newline delimited JSON,
+// flushing after each one so rows reach the client as they are read.
+func streamJSON(c echo.Context, each func(emit func(interface{}) bool) error) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	res.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(res)
+	var encodeErr error
+	err := each(func(v interface{}) bool {
+		if encodeErr = enc.Encode(v); encodeErr != nil {
+			return false
+		}
+		res.Flush()
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return encodeErr
+}
+
+// validationError returns a 400 error listing the rejected fields if err is a
+// validation error, and nil otherwise.
+func validationError(err error) *echo.HTTPError {
+	var v *pkg.ValidationError
+	if !errors.As(err, &v) {
+		return nil
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, echo.Map{
+		"message": pkg.ErrValidation.Error(),
+		"fields":  v.Fields,
+	})
+}
@@ -0,0 +1,260 @@
+package endpoints
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"example/pkg"
+	"example/pkg/fault"
+	"example/repo"
+	"example/services"
+)
+
+// testServer is the application wired as main does, over a database faults
+// can be injected into.
+type testServer struct {
+	e  *echo.Echo
+	db *fault.DB
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	db := fault.Wrap(pkg.NewDB())
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	userRepo, err := repo.NewUser(db)
+	must(err)
+	planRepo, err := repo.NewPlan(db)
+	must(err)
+	subscriptionRepo, err := repo.NewSubscription(db)
+	must(err)
+	paymentRepo, err := repo.NewPayment(db)
+	must(err)
+	planChangeRepo, err := repo.NewPlanChange(db)
+	must(err)
+	invoiceRepo, err := repo.NewInvoice(db)
+	must(err)
+	reminderRepo, err := repo.NewReminder(db)
+	must(err)
+	renewalRepo, err := repo.NewRenewal(db)
+	must(err)
+	dunningRepo, err := repo.NewDunning(db)
+	must(err)
+
+	userService := services.NewUser(userRepo)
+	planService := services.NewPlan(planRepo)
+	must(planService.Seed())
+	paymentService := services.NewPayment(paymentRepo, services.NewFakeGateway())
+	invoiceService := services.NewInvoice(invoiceRepo)
+	subscriptionService := services.NewSubscription(subscriptionRepo, planChangeRepo, planService, paymentService, invoiceService)
+	notifier := services.NewLogNotifier(log.New(io.Discard, "", 0))
+	reminderService := services.NewReminder(reminderRepo, subscriptionRepo, notifier, []time.Duration{24 * time.Hour})
+	renewalService := services.NewRenewal(renewalRepo, subscriptionRepo, planService, paymentService, invoiceService, notifier)
+	dunningService := services.NewDunning(dunningRepo, subscriptionRepo, renewalService, invoiceService, notifier, []time.Duration{24 * time.Hour})
+
+	e := echo.New()
+	NewUser(userService).Register(e.Group("/users"))
+	NewPlan(planService).Register(e.Group("/plans"))
+	NewSubscription(subscriptionService, userService, paymentService, reminderService, dunningService, renewalService).Register(e.Group("/subscriptions"))
+	invoiceEndpoint := NewInvoice(invoiceService, userService)
+	invoiceEndpoint.Register(e.Group("/invoices"))
+	invoiceEndpoint.RegisterUser(e.Group("/users"))
+	return &testServer{e: e, db: db}
+}
+
+// do serves a request with a JSON body, empty for none, and returns the
+// response.
+func (s *testServer) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// mustDo serves a request that must succeed.
+func (s *testServer) mustDo(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := s.do(method, path, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s %s = %d %s", method, path, rec.Code, rec.Body)
+	}
+	return rec
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   *fault.Rule
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{
+			name:   "create user",
+			method: http.MethodPost,
+			path:   "/users",
+			body:   `{"username":"bob"}`,
+			want:   http.StatusOK,
+		},
+		{
+			name:   "create user insert fails",
+			rule:   &fault.Rule{Table: "users", Op: pkg.OpNameInsert},
+			method: http.MethodPost,
+			path:   "/users",
+			body:   `{"username":"bob"}`,
+			want:   http.StatusInternalServerError,
+		},
+		{
+			name:   "create user invalid",
+			method: http.MethodPost,
+			path:   "/users",
+			body:   `{"username":" bob"}`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "get user",
+			method: http.MethodGet,
+			path:   "/users/1",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "get missing user",
+			method: http.MethodGet,
+			path:   "/users/9",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "get user fails",
+			rule:   &fault.Rule{Table: "users", Op: pkg.OpNameGet},
+			method: http.MethodGet,
+			path:   "/users/1",
+			want:   http.StatusInternalServerError,
+		},
+		{
+			name:   "list users fails",
+			rule:   &fault.Rule{Table: "users", Op: fault.OpNameTable},
+			method: http.MethodGet,
+			path:   "/users",
+			want:   http.StatusInternalServerError,
+		},
+		{
+			name:   "get subscription",
+			method: http.MethodGet,
+			path:   "/subscriptions/1",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "get missing subscription",
+			method: http.MethodGet,
+			path:   "/subscriptions/9",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "get subscription fails",
+			rule:   &fault.Rule{Table: "subscription", Op: pkg.OpNameGet},
+			method: http.MethodGet,
+			path:   "/subscriptions/1",
+			want:   http.StatusInternalServerError,
+		},
+		{
+			name:   "get subscriptions of user",
+			method: http.MethodGet,
+			path:   "/subscriptions/users/1",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "get active subscription",
+			method: http.MethodGet,
+			path:   "/subscriptions/users/1/active",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "get active subscription of user without one",
+			method: http.MethodGet,
+			path:   "/subscriptions/users/9/active",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "get active subscription fails",
+			rule:   &fault.Rule{Table: "latest_subscription", Op: pkg.OpNameGet},
+			method: http.MethodGet,
+			path:   "/subscriptions/users/1/active",
+			want:   http.StatusInternalServerError,
+		},
+		{
+			name:   "create subscription insert fails",
+			rule:   &fault.Rule{Table: "subscription", Op: pkg.OpNameInsert},
+			method: http.MethodPost,
+			path:   "/subscriptions",
+			body:   `{"user_id":1,"plan_type":"free"}`,
+			want:   http.StatusInternalServerError,
+		},
+		{
+			name:   "create paid subscription",
+			method: http.MethodPost,
+			path:   "/subscriptions",
+			body:   `{"user_id":1,"plan_type":"basic","card_number":"4242424242424242"}`,
+			want:   http.StatusOK,
+		},
+		{
+			name:   "create paid subscription with unknown card",
+			method: http.MethodPost,
+			path:   "/subscriptions",
+			body:   `{"user_id":1,"plan_type":"basic","card_number":"1234"}`,
+			want:   http.StatusPaymentRequired,
+		},
+		{
+			name:   "create paid subscription fails",
+			rule:   &fault.Rule{Table: "subscription", Op: pkg.OpNameInsert},
+			method: http.MethodPost,
+			path:   "/subscriptions",
+			body:   `{"user_id":1,"plan_type":"basic","card_number":"4242424242424242"}`,
+			want:   http.StatusInternalServerError,
+		},
+		{
+			name:   "change plan of free subscription",
+			method: http.MethodPost,
+			path:   "/subscriptions/1/change-plan",
+			body:   `{"plan_type":"premium"}`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "get invoice fails",
+			rule:   &fault.Rule{Table: "invoice", Op: pkg.OpNameGet},
+			method: http.MethodGet,
+			path:   "/invoices/1",
+			want:   http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.mustDo(t, http.MethodPost, "/users", `{"username":"alice"}`)
+			s.mustDo(t, http.MethodPost, "/subscriptions", `{"user_id":1,"plan_type":"free"}`)
+			if test.rule != nil {
+				test.rule.Err = fault.ErrInjected
+				s.db.Inject(*test.rule)
+			}
+			rec := s.do(test.method, test.path, test.body)
+			if rec.Code != test.want {
+				t.Errorf("%s status = %d %s, want %d", test.name, rec.Code, rec.Body, test.want)
+			}
+		})
+	}
+}
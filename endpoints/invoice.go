@@ -0,0 +1,68 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"example/pkg"
+	"example/services"
+)
+
+// Invoice is the endpoint for the invoices of paid periods
+type Invoice struct {
+	invoiceService services.Invoice
+	userService    services.User
+}
+
+// NewInvoice returns a new invoice endpoint
+func NewInvoice(s services.Invoice, userService services.User) *Invoice {
+	return &Invoice{invoiceService: s, userService: userService}
+}
+
+// Register registers the invoice endpoint
+func (i *Invoice) Register(g *echo.Group) {
+	g.GET("/:id", i.GetByID)
+}
+
+// RegisterUser registers the invoices of a user under the user endpoint
+func (i *Invoice) RegisterUser(g *echo.Group) {
+	g.GET("/:id/invoices", i.FindByUser)
+}
+
+func (i *Invoice) GetByID(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid invoice id: %s", err.Error()))
+	}
+	invoice, err := i.invoiceService.GetByID(pkg.PrimaryKey(id))
+	if err != nil {
+		if errors.Is(err, pkg.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, invoice)
+}
+
+// FindByUser lists the invoices of a user
+func (i *Invoice) FindByUser(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid user id: %s", err.Error()))
+	}
+	if _, err = i.userService.GetByID(pkg.PrimaryKey(userID)); err != nil {
+		if errors.Is(err, pkg.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	invoices, err := i.invoiceService.GetByUserID(pkg.PrimaryKey(userID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, invoices)
+}
@@ -0,0 +1,33 @@
+package endpoints
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"example/services"
+)
+
+// Plan is the public endpoint for the plan catalog
+type Plan struct {
+	planService services.Plan
+}
+
+// NewPlan returns a new plan endpoint
+func NewPlan(s services.Plan) *Plan {
+	return &Plan{s}
+}
+
+// Register registers the plan endpoint
+func (p *Plan) Register(g *echo.Group) {
+	g.GET("", p.Find)
+}
+
+// Find lists the plans open to new subscribers at their current price
+func (p *Plan) Find(c echo.Context) error {
+	plans, err := p.planService.Find(false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, plans)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
@@ -16,25 +17,69 @@ import (
 type Subscription struct {
 	subscriptionService services.Subscription
 	userService         services.User
+	paymentService      services.Payment
+	reminderService     services.Reminder
+	dunningService      services.Dunning
+	renewalService      services.Renewal
 }
 type createSubscriptionRequest struct {
 	UserID   int             `json:"user_id"`
 	PlanType models.PlanType `json:"plan_type"`
+	// CardNumber is charged for paid plans
+	CardNumber string `json:"card_number"`
+	// AutoRenew renews paid plans when their period ends, true if unset
+	AutoRenew *bool `json:"auto_renew"`
+	// TrialDays starts paid plans with a trial, charged when it ends
+	TrialDays int `json:"trial_days"`
+	// Currency is the currency paid plans are charged in, USD if unset
+	Currency models.Currency `json:"currency"`
 }
 
-func NewSubscription(s services.Subscription, userService services.User) *Subscription {
+type cancelSubscriptionRequest struct {
+	// AtPeriodEnd keeps the subscription until the end of its paid period
+	AtPeriodEnd bool   `json:"at_period_end"`
+	Reason      string `json:"reason"`
+}
+
+type autoRenewRequest struct {
+	AutoRenew bool `json:"auto_renew"`
+}
+
+type changePlanRequest struct {
+	PlanType models.PlanType `json:"plan_type"`
+}
+
+type changePlanResponse struct {
+	Subscription *models.Subscription `json:"subscription"`
+	Change       *models.PlanChange   `json:"change"`
+}
+
+func NewSubscription(s services.Subscription, userService services.User, paymentService services.Payment, reminderService services.Reminder, dunningService services.Dunning, renewalService services.Renewal) *Subscription {
 	return &Subscription{
 		subscriptionService: s,
 		userService:         userService,
+		paymentService:      paymentService,
+		reminderService:     reminderService,
+		dunningService:      dunningService,
+		renewalService:      renewalService,
 	}
 }
 
 func (s *Subscription) Register(g *echo.Group) {
 	g.POST("", s.Create)
 	g.GET("", s.Find)
+	g.GET("/export", s.Export)
 	g.GET("/:id", s.GetByID)
+	g.POST("/:id/cancel", s.Cancel)
+	g.PUT("/:id/auto-renew", s.SetAutoRenew)
+	g.POST("/:id/change-plan", s.ChangePlan)
+	g.GET("/:id/payments", s.FindPayments)
+	g.GET("/:id/reminders", s.FindReminders)
+	g.GET("/:id/dunning", s.FindDunning)
 	g.GET("/users/:user_id", s.FindByUser)
 	g.GET("/users/:user_id/active", s.FindActive)
+	g.GET("/users/:user_id/renewals", s.FindRenewals)
+	g.GET("/users/:user_id/plan-changes", s.FindPlanChanges)
 }
 
 func (s *Subscription) Create(c echo.Context) error {
@@ -45,16 +90,57 @@ func (s *Subscription) Create(c echo.Context) error {
 	if req.PlanType == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "plan_type is required")
 	}
+	if req.TrialDays < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "trial_days must not be negative")
+	}
 	if _, err := s.userService.GetByID(pkg.PrimaryKey(req.UserID)); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("user not found: %s", err.Error()))
 	}
-	subscription := &models.Subscription{UserID: pkg.PrimaryKey(req.UserID), PlanType: req.PlanType}
-	if err := s.subscriptionService.Create(subscription); err != nil {
+	subscription := &models.Subscription{
+		UserID:    pkg.PrimaryKey(req.UserID),
+		PlanType:  req.PlanType,
+		AutoRenew: req.AutoRenew == nil || *req.AutoRenew,
+		Currency:  req.Currency,
+	}
+	if req.CardNumber != "" && req.PlanType != models.PlanTypeFree {
+		// only the gateway sees the card number
+		token, last4, err := s.paymentService.Tokenize(req.CardNumber)
+		if errors.Is(err, services.ErrInvalidCard) {
+			return echo.NewHTTPError(http.StatusPaymentRequired, err.Error())
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+		}
+		subscription.PaymentSource = token
+		subscription.CardLast4 = last4
+	}
+	if subscription.Currency == "" {
+		subscription.Currency = models.CurrencyUSD
+	}
+	create := s.subscriptionService.Create
+	if req.TrialDays > 0 {
+		create = func(m *models.Subscription) error {
+			return s.subscriptionService.StartTrial(m, time.Duration(req.TrialDays)*24*time.Hour)
+		}
+	}
+	if err := create(subscription); err != nil {
+		if he := validationError(err); he != nil {
+			return he
+		}
 		statusCode := http.StatusInternalServerError
-		if errors.Is(err, services.ErrInvalidPlanType) {
+		switch {
+		case errors.Is(err, services.ErrInvalidPlanType), errors.Is(err, services.ErrPaymentRequired), errors.Is(err, services.ErrTrialFree),
+			errors.Is(err, services.ErrPlanArchived), errors.Is(err, services.ErrCurrencyNotOffered),
+			errors.Is(err, models.ErrUnknownCurrency):
 			statusCode = http.StatusBadRequest
+		case errors.Is(err, services.ErrCardDeclined), errors.Is(err, services.ErrInvalidCard):
+			statusCode = http.StatusPaymentRequired
+		case errors.Is(err, services.ErrGatewayRetry):
+			statusCode = http.StatusBadGateway
+		case errors.Is(err, services.ErrGatewayTimeout):
+			statusCode = http.StatusGatewayTimeout
 		}
-		return echo.NewHTTPError(statusCode, err)
+		return echo.NewHTTPError(statusCode, err.Error())
 	}
 	return c.JSON(http.StatusOK, subscription)
 }
@@ -74,40 +160,301 @@ func (s *Subscription) GetByID(c echo.Context) error {
 	return echo.NewHTTPError(http.StatusInternalServerError, err)
 }
 
+// Cancel cancels a subscription, immediately or at the end of its paid
+// period, and moves its user to the free plan.
+func (s *Subscription) Cancel(c echo.Context) error {
+	subscriptionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid subscription id: %s", err.Error()))
+	}
+	var req cancelSubscriptionRequest
+	if err = c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+	}
+	subscription, err := s.subscriptionService.Cancel(pkg.PrimaryKey(subscriptionID), req.AtPeriodEnd, req.Reason)
+	if err == nil {
+		return c.JSON(http.StatusOK, subscription)
+	}
+	if he := validationError(err); he != nil {
+		return he
+	}
+	switch {
+	case errors.Is(err, pkg.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrAlreadyCanceled):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrCancelFree), errors.Is(err, services.ErrSubscriptionEnded):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// SetAutoRenew turns the renewal of a paid subscription on or off
+func (s *Subscription) SetAutoRenew(c echo.Context) error {
+	subscriptionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid subscription id: %s", err.Error()))
+	}
+	var req autoRenewRequest
+	if err = c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+	}
+	subscription, err := s.subscriptionService.SetAutoRenew(pkg.PrimaryKey(subscriptionID), req.AutoRenew)
+	if err == nil {
+		return c.JSON(http.StatusOK, subscription)
+	}
+	switch {
+	case errors.Is(err, pkg.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrAlreadyCanceled):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrRenewFree), errors.Is(err, services.ErrSubscriptionEnded):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// ChangePlan moves a subscription to another plan, prorating the price
+// difference over the rest of its period
+func (s *Subscription) ChangePlan(c echo.Context) error {
+	subscriptionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid subscription id: %s", err.Error()))
+	}
+	var req changePlanRequest
+	if err = c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+	}
+	if req.PlanType == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "plan_type is required")
+	}
+	subscription, change, err := s.subscriptionService.ChangePlan(pkg.PrimaryKey(subscriptionID), req.PlanType)
+	if err == nil {
+		return c.JSON(http.StatusOK, changePlanResponse{Subscription: subscription, Change: change})
+	}
+	if he := validationError(err); he != nil {
+		return he
+	}
+	switch {
+	case errors.Is(err, pkg.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrAlreadyCanceled), errors.Is(err, services.ErrSamePlan):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrInvalidPlanType), errors.Is(err, services.ErrChangeFree),
+		errors.Is(err, services.ErrChangeToFree), errors.Is(err, services.ErrSubscriptionEnded),
+		errors.Is(err, services.ErrPaymentRequired), errors.Is(err, services.ErrPlanArchived),
+		errors.Is(err, services.ErrCurrencyNotOffered):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrCardDeclined), errors.Is(err, services.ErrInvalidCard):
+		return echo.NewHTTPError(http.StatusPaymentRequired, err.Error())
+	case errors.Is(err, services.ErrGatewayRetry):
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	case errors.Is(err, services.ErrGatewayTimeout):
+		return echo.NewHTTPError(http.StatusGatewayTimeout, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// FindPayments lists the payments made for a subscription
+func (s *Subscription) FindPayments(c echo.Context) error {
+	subscriptionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid subscription id: %s", err.Error()))
+	}
+	if _, err = s.subscriptionService.GetByID(pkg.PrimaryKey(subscriptionID)); err != nil {
+		if errors.Is(err, pkg.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	payments, err := s.paymentService.GetBySubscriptionID(pkg.PrimaryKey(subscriptionID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, payments)
+}
+
+// FindReminders lists the reminders sent about a subscription
+func (s *Subscription) FindReminders(c echo.Context) error {
+	subscriptionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid subscription id: %s", err.Error()))
+	}
+	if _, err = s.subscriptionService.GetByID(pkg.PrimaryKey(subscriptionID)); err != nil {
+		if errors.Is(err, pkg.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	reminders, err := s.reminderService.GetBySubscriptionID(pkg.PrimaryKey(subscriptionID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, reminders)
+}
+
+// FindDunning lists the steps of the collection of an unpaid subscription
+func (s *Subscription) FindDunning(c echo.Context) error {
+	subscriptionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid subscription id: %s", err.Error()))
+	}
+	if _, err = s.subscriptionService.GetByID(pkg.PrimaryKey(subscriptionID)); err != nil {
+		if errors.Is(err, pkg.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	events, err := s.dunningService.GetBySubscriptionID(pkg.PrimaryKey(subscriptionID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// FindByUser lists the subscriptions of the user in the path, in the status
+// of the status query parameter if it is set
 func (s *Subscription) FindByUser(c echo.Context) error {
-	userID, err := strconv.Atoi(c.QueryParam("user_id"))
+	userID, err := strconv.Atoi(c.Param("user_id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid user id: %s", err.Error()))
 	}
+	status, err := statusFilter(c)
+	if err != nil {
+		return err
+	}
 	subscriptions, err := s.subscriptionService.GetByUserID(pkg.PrimaryKey(userID))
 	if err == nil {
-		return c.JSON(http.StatusOK, subscriptions)
+		return c.JSON(http.StatusOK, withStatus(subscriptions, status))
 	}
 	if errors.Is(err, pkg.ErrNotFound) {
-		return echo.NewHTTPError(http.StatusNotFound, err)
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
-	return c.JSON(http.StatusInternalServerError, err)
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 }
 
+// FindRenewals lists the renewal log of a user
+func (s *Subscription) FindRenewals(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid user id: %s", err.Error()))
+	}
+	if _, err = s.userService.GetByID(pkg.PrimaryKey(userID)); err != nil {
+		if errors.Is(err, pkg.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	renewals, err := s.renewalService.GetByUserID(pkg.PrimaryKey(userID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, renewals)
+}
+
+// FindPlanChanges lists the plan changes of a user
+func (s *Subscription) FindPlanChanges(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid user id: %s", err.Error()))
+	}
+	if _, err = s.userService.GetByID(pkg.PrimaryKey(userID)); err != nil {
+		if errors.Is(err, pkg.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	changes, err := s.subscriptionService.GetPlanChangesByUserID(pkg.PrimaryKey(userID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, changes)
+}
+
+// FindActive returns the active subscription of the user in the path, 404
+// if there is none
 func (s *Subscription) FindActive(c echo.Context) error {
-	userID, err := strconv.Atoi(c.QueryParam("user_id"))
+	userID, err := strconv.Atoi(c.Param("user_id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid user id: %s", err.Error()))
 	}
-	subscriptions, err := s.subscriptionService.GetActiveForUser(pkg.PrimaryKey(userID))
+	subscription, err := s.subscriptionService.GetActiveForUser(pkg.PrimaryKey(userID))
 	if err == nil {
-		return c.JSON(http.StatusOK, subscriptions)
+		return c.JSON(http.StatusOK, subscription)
 	}
-	if errors.Is(err, pkg.ErrNotFound) {
-		return echo.NewHTTPError(http.StatusNotFound, err)
+	if errors.Is(err, services.ErrNoActiveSubscription) || errors.Is(err, pkg.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
-	return c.JSON(http.StatusInternalServerError, err)
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 }
 
+// Find lists the subscriptions, with the username of their user when the
+// expand query parameter is user, in the status of the status query
+// parameter if set.
 func (s *Subscription) Find(c echo.Context) error {
-	subscriptions, err := s.subscriptionService.Find()
+	status, err := statusFilter(c)
+	if err != nil {
+		return err
+	}
+	if c.QueryParam("expand") == "user" {
+		subscriptions, err := s.subscriptionService.FindWithUsers()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err)
+		}
+		if status != "" {
+			filtered := []*models.SubscriptionWithUser{}
+			for _, m := range subscriptions {
+				if m.Status == status {
+					filtered = append(filtered, m)
+				}
+			}
+			subscriptions = filtered
+		}
+		return c.JSON(http.StatusOK, subscriptions)
+	}
+	var subscriptions []*models.Subscription
+	if status != "" {
+		subscriptions, err = s.subscriptionService.GetByStatus(status)
+	} else {
+		subscriptions, err = s.subscriptionService.Find()
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, subscriptions)
 }
+
+// statusFilter returns the status of the status query parameter, "" if it
+// is not set.
+func statusFilter(c echo.Context) (models.SubscriptionStatus, error) {
+	status := models.SubscriptionStatus(c.QueryParam("status"))
+	switch status {
+	case "", models.SubscriptionStatusTrialing, models.SubscriptionStatusActive, models.SubscriptionStatusPastDue,
+		models.SubscriptionStatusCanceled, models.SubscriptionStatusExpired:
+		return status, nil
+	}
+	return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid status: %s", status))
+}
+
+// withStatus returns the subscriptions in status, or all of them if status
+// is "".
+func withStatus(subscriptions []*models.Subscription, status models.SubscriptionStatus) []*models.Subscription {
+	if status == "" {
+		return subscriptions
+	}
+	filtered := []*models.Subscription{}
+	for _, m := range subscriptions {
+		if m.Status == status {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+func (s *Subscription) Export(c echo.Context) error {
+	return streamJSON(c, func(emit func(interface{}) bool) error {
+		return s.subscriptionService.Each(func(m *models.Subscription) bool {
+			return emit(m)
+		})
+	})
+}
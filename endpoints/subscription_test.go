@@ -0,0 +1,97 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSubscription_StatusFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want int
+		// wantStatuses are the statuses of the subscriptions listed
+		wantStatuses []string
+	}{
+		{
+			name:         "of user",
+			path:         "/subscriptions/users/1",
+			want:         http.StatusOK,
+			wantStatuses: []string{"canceled", "active"},
+		},
+		{
+			name:         "of user active",
+			path:         "/subscriptions/users/1?status=active",
+			want:         http.StatusOK,
+			wantStatuses: []string{"active"},
+		},
+		{
+			name:         "of user canceled",
+			path:         "/subscriptions/users/1?status=canceled",
+			want:         http.StatusOK,
+			wantStatuses: []string{"canceled"},
+		},
+		{
+			name:         "of user none expired",
+			path:         "/subscriptions/users/1?status=expired",
+			want:         http.StatusOK,
+			wantStatuses: []string{},
+		},
+		{
+			name: "of user unknown status",
+			path: "/subscriptions/users/1?status=paused",
+			want: http.StatusBadRequest,
+		},
+		{
+			name:         "all active",
+			path:         "/subscriptions?status=active",
+			want:         http.StatusOK,
+			wantStatuses: []string{"active", "active"},
+		},
+		{
+			name:         "all canceled with users",
+			path:         "/subscriptions?status=canceled&expand=user",
+			want:         http.StatusOK,
+			wantStatuses: []string{"canceled"},
+		},
+		{
+			name: "all unknown status",
+			path: "/subscriptions?status=paused",
+			want: http.StatusBadRequest,
+		},
+	}
+
+	s := newTestServer(t)
+	s.mustDo(t, http.MethodPost, "/users", `{"username":"alice"}`)
+	s.mustDo(t, http.MethodPost, "/users", `{"username":"bob"}`)
+	s.mustDo(t, http.MethodPost, "/subscriptions", `{"user_id":1,"plan_type":"free"}`)
+	s.mustDo(t, http.MethodPost, "/subscriptions", `{"user_id":1,"plan_type":"basic","card_number":"4242424242424242"}`)
+	s.mustDo(t, http.MethodPost, "/subscriptions", `{"user_id":2,"plan_type":"free"}`)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := s.do(http.MethodGet, test.path, "")
+			if rec.Code != test.want {
+				t.Fatalf("GET %s = %d %s, want %d", test.path, rec.Code, rec.Body, test.want)
+			}
+			if test.want != http.StatusOK {
+				return
+			}
+			var subscriptions []struct {
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &subscriptions); err != nil {
+				t.Fatal(err)
+			}
+			if len(subscriptions) != len(test.wantStatuses) {
+				t.Fatalf("GET %s listed %d subscriptions, want %d", test.path, len(subscriptions), len(test.wantStatuses))
+			}
+			for i, m := range subscriptions {
+				if m.Status != test.wantStatuses[i] {
+					t.Errorf("subscription %d status = %s, want %s", i, m.Status, test.wantStatuses[i])
+				}
+			}
+		})
+	}
+}
@@ -29,9 +29,11 @@ func NewUser(s services.User) *User {
 
 // Register registers the user endpoint
 func (u *User) Register(g *echo.Group) {
+	g.GET("/export", u.Export)
 	g.GET("/:id", u.GetByID)
 	g.GET("", u.Find)
 	g.POST("", u.Create)
+	g.POST("/import", u.Import)
 }
 
 func (u *User) Create(c echo.Context) error {
@@ -44,11 +46,36 @@ func (u *User) Create(c echo.Context) error {
 	}
 	user := &models.User{Username: req.Username}
 	if err := u.userService.Create(user); err != nil {
+		if he := validationError(err); he != nil {
+			return he
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 	return c.JSON(http.StatusOK, user)
 }
 
+func (u *User) Import(c echo.Context) error {
+	var req []createUserRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid request: %s", err.Error()))
+	}
+	users := make([]*models.User, len(req))
+	for i, r := range req {
+		if r.Username == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("row %d: username is required", i))
+		}
+		users[i] = &models.User{Username: r.Username}
+	}
+	if err := u.userService.CreateMany(users); err != nil {
+		var batchErr *pkg.BatchError
+		if errors.As(err, &batchErr) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
 func (u *User) GetByID(c echo.Context) error {
 	userID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -78,3 +105,11 @@ func (u *User) Find(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, users)
 }
+
+func (u *User) Export(c echo.Context) error {
+	return streamJSON(c, func(emit func(interface{}) bool) error {
+		return u.userService.Each(func(m *models.User) bool {
+			return emit(m)
+		})
+	})
+}
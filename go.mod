@@ -2,8 +2,9 @@ module example
 
 go 1.18
 
+require github.com/labstack/echo/v4 v4.10.2
+
 require (
-	github.com/labstack/echo/v4 v4.10.2 // indirect
 	github.com/labstack/gommon v0.4.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
 	"github.com/labstack/echo/v4"
 
 	"example/endpoints"
@@ -9,11 +15,63 @@ import (
 	"example/services"
 )
 
+var (
+	addr              = flag.String("addr", ":8080", "address the HTTP server listens on")
+	replicationListen = flag.String("replication-listen", "", "address followers connect to, empty to not accept followers")
+	follow            = flag.String("follow", "", "address of a leader to replicate, making this server read-only")
+	maxRows           = flag.Int("max-rows", 0, "rows kept in memory before spilling to disk, 0 for no limit")
+	maxBytes          = flag.Int64("max-bytes", 0, "estimated bytes kept in memory before spilling to disk, 0 for no limit")
+	shards            = flag.Int("shards", 1, "number of shards each table is partitioned across")
+	spillDir          = flag.String("spill-dir", "", "directory for overflow files, defaults to the system temp directory")
+	reminderWindows   = flag.String("reminder-windows", "7d,1d", "how long before a paid period ends users are reminded, comma separated")
+	dunningSchedule   = flag.String("dunning-schedule", "1d,3d,7d", "how long after an unpaid period ends its renewal is retried, comma separated, empty to downgrade at once")
+	jobInterval       = flag.Duration("job-interval", time.Minute, "how often background jobs such as renewals and reminders run")
+)
+
 func main() {
+	flag.Parse()
 	e := echo.New()
 
-	db := pkg.NewDB()
-	userRepo, err := repo.NewUser(db)
+	metrics := pkg.NewPrometheus()
+	e.GET("/metrics", echo.WrapHandler(metrics))
+
+	opts := []pkg.Option{pkg.WithMetrics(metrics)}
+	if *shards > 1 {
+		opts = append(opts, pkg.WithShards(*shards))
+	}
+	if *maxRows > 0 || *maxBytes > 0 {
+		opts = append(opts, pkg.WithMemoryBudget(pkg.MemoryBudget{
+			MaxRows:  *maxRows,
+			MaxBytes: *maxBytes,
+			Dir:      *spillDir,
+		}))
+	}
+	if spec := os.Getenv("DB_ENCRYPTION_KEYS"); spec != "" {
+		keys, err := pkg.ParseKeyring(os.Getenv("DB_ENCRYPTION_KEY_ID"), spec)
+		if err != nil {
+			e.Logger.Fatalf("invalid encryption keys: %s", err.Error())
+		}
+		opts = append(opts, pkg.WithEncryption(keys))
+	}
+	db := pkg.NewDB(opts...)
+	appDB := db
+	if *replicationListen != "" {
+		leader, err := pkg.NewLeader(db, *replicationListen)
+		if err != nil {
+			e.Logger.Fatalf("failed to start replication: %s", err.Error())
+		}
+		defer leader.Close()
+		go func() {
+			if err := leader.Serve(); err != nil {
+				e.Logger.Errorf("replication stopped: %s", err.Error())
+			}
+		}()
+	}
+	if *follow != "" {
+		appDB = pkg.ReadOnly(db)
+	}
+
+	userRepo, err := repo.NewUser(appDB)
 	if err != nil {
 		e.Logger.Fatalf("failed to create user repo: %s", err.Error())
 	}
@@ -21,13 +79,116 @@ func main() {
 	userEndpoint := endpoints.NewUser(userService)
 	userEndpoint.Register(e.Group("/users"))
 
-	subscriptionRepo, err := repo.NewSubscription(db)
+	planRepo, err := repo.NewPlan(appDB)
+	if err != nil {
+		e.Logger.Fatalf("failed to create plan repo: %s", err.Error())
+	}
+	planService := services.NewPlan(planRepo)
+	if *follow == "" {
+		// followers get the catalog from their leader
+		if err = planService.Seed(); err != nil {
+			e.Logger.Fatalf("failed to seed plans: %s", err.Error())
+		}
+	}
+	planEndpoint := endpoints.NewPlan(planService)
+	planEndpoint.Register(e.Group("/plans"))
+
+	subscriptionRepo, err := repo.NewSubscription(appDB)
 	if err != nil {
 		e.Logger.Fatalf("failed to create subscription repo: %s", err.Error())
 	}
-	subscriptionService := services.NewSubscription(subscriptionRepo)
-	subscriptionEndpoint := endpoints.NewSubscription(subscriptionService, userService)
+	paymentRepo, err := repo.NewPayment(appDB)
+	if err != nil {
+		e.Logger.Fatalf("failed to create payment repo: %s", err.Error())
+	}
+	paymentService := services.NewPayment(paymentRepo, services.NewFakeGateway())
+	planChangeRepo, err := repo.NewPlanChange(appDB)
+	if err != nil {
+		e.Logger.Fatalf("failed to create plan change repo: %s", err.Error())
+	}
+	invoiceRepo, err := repo.NewInvoice(appDB)
+	if err != nil {
+		e.Logger.Fatalf("failed to create invoice repo: %s", err.Error())
+	}
+	invoiceService := services.NewInvoice(invoiceRepo)
+	subscriptionService := services.NewSubscription(subscriptionRepo, planChangeRepo, planService, paymentService, invoiceService)
+
+	reminderRepo, err := repo.NewReminder(appDB)
+	if err != nil {
+		e.Logger.Fatalf("failed to create reminder repo: %s", err.Error())
+	}
+	windows, err := services.ParseWindows(*reminderWindows)
+	if err != nil {
+		e.Logger.Fatalf("invalid reminder windows: %s", err.Error())
+	}
+	notifier := services.NewLogNotifier(log.New(os.Stdout, "notify: ", log.LstdFlags))
+	reminderService := services.NewReminder(reminderRepo, subscriptionRepo, notifier, windows)
+
+	renewalRepo, err := repo.NewRenewal(appDB)
+	if err != nil {
+		e.Logger.Fatalf("failed to create renewal repo: %s", err.Error())
+	}
+	renewalService := services.NewRenewal(renewalRepo, subscriptionRepo, planService, paymentService, invoiceService, notifier)
+
+	dunningRepo, err := repo.NewDunning(appDB)
+	if err != nil {
+		e.Logger.Fatalf("failed to create dunning repo: %s", err.Error())
+	}
+	schedule, err := services.ParseWindows(*dunningSchedule)
+	if err != nil {
+		e.Logger.Fatalf("invalid dunning schedule: %s", err.Error())
+	}
+	dunningService := services.NewDunning(dunningRepo, subscriptionRepo, renewalService, invoiceService, notifier, schedule)
+
+	subscriptionEndpoint := endpoints.NewSubscription(subscriptionService, userService, paymentService, reminderService, dunningService, renewalService)
 	subscriptionEndpoint.Register(e.Group("/subscriptions"))
+	invoiceEndpoint := endpoints.NewInvoice(invoiceService, userService)
+	invoiceEndpoint.Register(e.Group("/invoices"))
+	invoiceEndpoint.RegisterUser(e.Group("/users"))
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		e.Logger.Warn("ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+	adminEndpoint := endpoints.NewAdmin(services.NewQuery(appDB), planService, adminToken)
+	adminEndpoint.Register(e.Group("/admin"))
 
-	e.Logger.Fatal(e.Start(":8080"))
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if *follow == "" {
+		// followers are read-only, their leader sends the reminders
+		go services.RunJob(jobs, *jobInterval, func(now time.Time) {
+			if _, err := reminderService.SendExpiryReminders(now); err != nil {
+				e.Logger.Errorf("failed to send expiry reminders: %s", err.Error())
+			}
+			// renewals go first, dunning retries the ones that failed
+			if _, err := renewalService.RenewDue(now); err != nil {
+				e.Logger.Errorf("failed to renew subscriptions: %s", err.Error())
+			}
+			if _, err := subscriptionService.EndDue(now); err != nil {
+				e.Logger.Errorf("failed to end subscriptions: %s", err.Error())
+			}
+			if err := dunningService.Run(now); err != nil {
+				e.Logger.Errorf("failed to collect unpaid subscriptions: %s", err.Error())
+			}
+		})
+	}
+
+	if *follow != "" {
+		// the repositories have added their tables, so the leader's rows
+		// can be copied into them
+		follower, err := pkg.NewFollower(db, *follow)
+		if err != nil {
+			e.Logger.Fatalf("failed to start replication: %s", err.Error())
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go follower.Run(ctx)
+	}
+
+	err = e.Start(*addr)
+	if closeErr := db.Close(); closeErr != nil {
+		e.Logger.Errorf("failed to close database: %s", closeErr.Error())
+	}
+	e.Logger.Fatal(err)
 }
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"example/pkg"
+)
+
+type DunningEventKind string
+
+const (
+	// DunningEventPastDue is recorded when a paid period ends unpaid
+	DunningEventPastDue DunningEventKind = "past_due"
+	// DunningEventRetryFailed is a failed retry of the payment
+	DunningEventRetryFailed DunningEventKind = "retry_failed"
+	// DunningEventRetrySucceeded is a retry that paid for a new period
+	DunningEventRetrySucceeded DunningEventKind = "retry_succeeded"
+	// DunningEventDowngraded is recorded when the user is moved to the free
+	// plan after the last retry failed
+	DunningEventDowngraded DunningEventKind = "downgraded"
+)
+
+// DunningEvent is a step of the collection of an unpaid subscription
+type DunningEvent struct {
+	ID             pkg.PrimaryKey   `json:"id"`
+	SubscriptionID pkg.PrimaryKey   `json:"subscription_id"`
+	UserID         pkg.PrimaryKey   `json:"user_id"`
+	Kind           DunningEventKind `json:"kind"`
+	// Attempt numbers the retries from 1
+	Attempt   int            `json:"attempt,omitempty"`
+	PaymentID pkg.PrimaryKey `json:"payment_id,omitempty"`
+	// NewSubscriptionID is the subscription the user was moved to
+	NewSubscriptionID pkg.PrimaryKey `json:"new_subscription_id,omitempty"`
+	Error             string         `json:"error,omitempty"`
+	At                time.Time      `json:"at"`
+}
+
+func (e *DunningEvent) GetID() pkg.PrimaryKey {
+	return e.ID
+}
+func (e *DunningEvent) SetID(id pkg.PrimaryKey) {
+	e.ID = id
+}
+
+var dunningEventSchema = pkg.Schema{
+	pkg.Field("SubscriptionID", pkg.Required()),
+	pkg.Field("UserID", pkg.Required()),
+	pkg.Field("Kind", pkg.Required(), pkg.OneOf(
+		string(DunningEventPastDue),
+		string(DunningEventRetryFailed),
+		string(DunningEventRetrySucceeded),
+		string(DunningEventDowngraded),
+	)),
+}
+
+func (e *DunningEvent) Schema() pkg.Schema {
+	return dunningEventSchema
+}
+
+var _ pkg.Constrained = (*DunningEvent)(nil)
+
+func init() {
+	pkg.RegisterModel(&DunningEvent{})
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"example/pkg"
+)
+
+type InvoiceStatus string
+
+const (
+	InvoiceStatusDraft InvoiceStatus = "draft"
+	InvoiceStatusOpen  InvoiceStatus = "open"
+	InvoiceStatusPaid  InvoiceStatus = "paid"
+	InvoiceStatusVoid  InvoiceStatus = "void"
+)
+
+// InvoiceLine is a charge, or a deduction if negative, on an invoice
+type InvoiceLine struct {
+	Description string    `json:"description"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Amount      Money     `json:"amount"`
+}
+
+// Invoice is what a user was billed for a paid period of a subscription
+type Invoice struct {
+	ID pkg.PrimaryKey `json:"id"`
+	// Number is given in sequence when the invoice is issued, so drafts
+	// have none and issued invoices leave no gaps
+	Number         int            `json:"number,omitempty"`
+	UserID         pkg.PrimaryKey `json:"user_id"`
+	SubscriptionID pkg.PrimaryKey `json:"subscription_id"`
+	Status         InvoiceStatus  `json:"status"`
+	Currency       Currency       `json:"currency"`
+	// PeriodStart and PeriodEnd bound the period billed
+	PeriodStart time.Time     `json:"period_start"`
+	PeriodEnd   time.Time     `json:"period_end"`
+	Lines       []InvoiceLine `json:"lines"`
+	// Total is the sum of the lines, due from the user
+	Total Money `json:"total"`
+	// PaymentID is the charge that paid the invoice, 0 if nothing was due
+	PaymentID pkg.PrimaryKey `json:"payment_id,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	IssuedAt  *time.Time     `json:"issued_at,omitempty"`
+	PaidAt    *time.Time     `json:"paid_at,omitempty"`
+	VoidedAt  *time.Time     `json:"voided_at,omitempty"`
+}
+
+func (i *Invoice) GetID() pkg.PrimaryKey {
+	return i.ID
+}
+func (i *Invoice) SetID(id pkg.PrimaryKey) {
+	i.ID = id
+}
+
+var invoiceSchema = pkg.Schema{
+	pkg.Field("UserID", pkg.Required()),
+	pkg.Field("SubscriptionID", pkg.Required()),
+	pkg.Field("Status", pkg.Required(), pkg.OneOf(string(InvoiceStatusDraft), string(InvoiceStatusOpen), string(InvoiceStatusPaid), string(InvoiceStatusVoid))),
+	pkg.Field("Currency", pkg.Required()),
+}
+
+func (i *Invoice) Schema() pkg.Schema {
+	return invoiceSchema
+}
+
+var _ pkg.Constrained = (*Invoice)(nil)
+
+func init() {
+	pkg.RegisterModel(&Invoice{})
+}
@@ -0,0 +1,241 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidMoney     = errors.New("invalid amount of money")
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currencies do not match")
+	ErrMoneyOverflow    = errors.New("amount of money out of range")
+)
+
+// Currency is an ISO 4217 currency code
+type Currency string
+
+const (
+	CurrencyUSD Currency = "USD"
+	CurrencyEUR Currency = "EUR"
+	CurrencyGBP Currency = "GBP"
+	CurrencyJPY Currency = "JPY"
+)
+
+// minorUnits holds the number of decimals of the minor unit of each
+// supported currency.
+var minorUnits = map[Currency]int{
+	CurrencyUSD: 2,
+	CurrencyEUR: 2,
+	CurrencyGBP: 2,
+	CurrencyJPY: 0,
+}
+
+// Valid reports whether the currency is supported
+func (c Currency) Valid() bool {
+	_, ok := minorUnits[c]
+	return ok
+}
+
+// Rounding is how an amount falling between two minor units is rounded
+type Rounding int
+
+const (
+	// RoundHalfEven rounds halves to the even minor unit, so that rounding
+	// many amounts does not drift one way
+	RoundHalfEven Rounding = iota
+	// RoundHalfUp rounds halves away from zero
+	RoundHalfUp
+)
+
+// Money is an exact amount in the minor unit of its currency, such as cents.
+// The zero value has no currency, adds to amounts of any currency as 0 and is
+// written as 0.00.
+type Money struct {
+	// Minor is the amount in minor units
+	Minor    int64
+	Currency Currency
+}
+
+// NewMoney returns an amount of minor units of a currency
+func NewMoney(minor int64, currency Currency) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// ParseMoney parses a decimal amount such as "9.99" in a currency. Amounts
+// with more decimals than the minor unit of the currency are rejected
+// rather than rounded.
+func ParseMoney(s string, currency Currency) (Money, error) {
+	decimals, ok := minorUnits[currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	neg := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" || len(frac) > decimals || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrInvalidMoney, s, currency)
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q in %s", ErrInvalidMoney, s, currency)
+	}
+	if neg {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// Decimal formats the amount in major units, such as 9.99
+func (m Money) Decimal() string {
+	decimals := minorUnits[m.Currency]
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	if decimals == 0 {
+		return sign + strconv.FormatInt(minor, 10)
+	}
+	s := fmt.Sprintf("%0*d", decimals+1, minor)
+	return sign + s[:len(s)-decimals] + "." + s[len(s)-decimals:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + string(m.Currency)
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Minor < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+// Neg returns the opposite amount
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.Currency}
+}
+
+// Add returns the sum of two amounts of the same currency
+func (m Money) Add(o Money) (Money, error) {
+	switch {
+	case o.Currency == "":
+		return m, nil
+	case m.Currency == "":
+		return o, nil
+	case m.Currency != o.Currency:
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Minor: m.Minor + o.Minor, Currency: m.Currency}, nil
+}
+
+// Sub returns m less o, of the same currency
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Mul returns m times num/den, rounded to the minor unit, or
+// ErrMoneyOverflow if the result does not fit in an int64.
+func (m Money) Mul(num, den int64, rounding Rounding) (Money, error) {
+	n := new(big.Int).Mul(big.NewInt(m.Minor), big.NewInt(num))
+	d := big.NewInt(den)
+	if d.Sign() < 0 {
+		n.Neg(n)
+		d.Neg(d)
+	}
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	// twice the remainder against the divisor tells below, at or above half
+	half := new(big.Int).Abs(r)
+	half.Lsh(half, 1)
+	switch c := half.Cmp(d); {
+	case c > 0, c == 0 && rounding == RoundHalfUp, c == 0 && q.Bit(0) == 1:
+		q.Add(q, big.NewInt(int64(n.Sign())))
+	}
+	if !q.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s times %d/%d", ErrMoneyOverflow, m, num, den)
+	}
+	return Money{Minor: q.Int64(), Currency: m.Currency}, nil
+}
+
+// Prorate returns the share of m for part of a whole period, rounded half to
+// even
+func (m Money) Prorate(part, whole time.Duration) (Money, error) {
+	return m.Mul(int64(part), int64(whole), RoundHalfEven)
+}
+
+// Tax returns the tax on m at a rate in basis points, hundredths of a
+// percent, rounded half up
+func (m Money) Tax(basisPoints int64) (Money, error) {
+	return m.Mul(basisPoints, 10000, RoundHalfUp)
+}
+
+// moneyJSON is Money in JSON, with its amount as a decimal string so that it
+// is never read as a float.
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency Currency        `json:"currency,omitempty"`
+}
+
+// zeroJSON is the zero value in JSON, which has no currency.
+const zeroJSON = `{"amount":"0.00"}`
+
+// MarshalJSON encodes the amount as a decimal string such as "9.99".
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.Currency == "" {
+		return []byte(zeroJSON), nil
+	}
+	amount, err := json.Marshal(m.Decimal())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(moneyJSON{Amount: amount, Currency: m.Currency})
+}
+
+// UnmarshalJSON decodes an amount given as a decimal string or number,
+// exactly.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*m = Money{}
+		return nil
+	}
+	var v moneyJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	amount := string(v.Amount)
+	if strings.HasPrefix(amount, `"`) {
+		if err := json.Unmarshal(v.Amount, &amount); err != nil {
+			return err
+		}
+	}
+	if v.Currency == "" {
+		// only the zero value has no currency
+		if strings.Trim(amount, "0.") != "" {
+			return fmt.Errorf("%w: %q", ErrUnknownCurrency, v.Currency)
+		}
+		*m = Money{}
+		return nil
+	}
+	parsed, err := ParseMoney(amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+var (
+	_ json.Marshaler   = Money{}
+	_ json.Unmarshaler = (*Money)(nil)
+)
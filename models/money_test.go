@@ -0,0 +1,219 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		s        string
+		currency Currency
+		want     Money
+		wantErr  error
+	}{
+		{s: "9.99", currency: CurrencyUSD, want: NewMoney(999, CurrencyUSD)},
+		{s: "9.9", currency: CurrencyUSD, want: NewMoney(990, CurrencyUSD)},
+		{s: "9", currency: CurrencyUSD, want: NewMoney(900, CurrencyUSD)},
+		{s: "-0.05", currency: CurrencyEUR, want: NewMoney(-5, CurrencyEUR)},
+		{s: "1500", currency: CurrencyJPY, want: NewMoney(1500, CurrencyJPY)},
+		{s: "0", currency: CurrencyUSD, want: NewMoney(0, CurrencyUSD)},
+		{s: "9.999", currency: CurrencyUSD, wantErr: ErrInvalidMoney},
+		{s: "1.5", currency: CurrencyJPY, wantErr: ErrInvalidMoney},
+		{s: "", currency: CurrencyUSD, wantErr: ErrInvalidMoney},
+		{s: ".50", currency: CurrencyUSD, wantErr: ErrInvalidMoney},
+		{s: "--1", currency: CurrencyUSD, wantErr: ErrInvalidMoney},
+		{s: "1.-5", currency: CurrencyUSD, wantErr: ErrInvalidMoney},
+		{s: "1e3", currency: CurrencyUSD, wantErr: ErrInvalidMoney},
+		{s: "99999999999999999999", currency: CurrencyUSD, wantErr: ErrInvalidMoney},
+		{s: "9.99", currency: "XXX", wantErr: ErrUnknownCurrency},
+	}
+
+	for _, test := range tests {
+		got, err := ParseMoney(test.s, test.currency)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("ParseMoney(%q, %s) error = %v, wantErr %v", test.s, test.currency, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseMoney(%q, %s) = %v, want %v", test.s, test.currency, got, test.want)
+		}
+	}
+}
+
+func TestMoney_Mul(t *testing.T) {
+	tests := []struct {
+		name     string
+		minor    int64
+		num, den int64
+		rounding Rounding
+		want     int64
+	}{
+		{name: "exact", minor: 1000, num: 1, den: 4, rounding: RoundHalfEven, want: 250},
+		{name: "below half", minor: 999, num: 1, den: 3, rounding: RoundHalfEven, want: 333},
+		{name: "above half", minor: 1999, num: 1, den: 3, rounding: RoundHalfEven, want: 666},
+		{name: "half to even down", minor: 5, num: 1, den: 2, rounding: RoundHalfEven, want: 2},
+		{name: "half to even up", minor: 7, num: 1, den: 2, rounding: RoundHalfEven, want: 4},
+		{name: "half up", minor: 5, num: 1, den: 2, rounding: RoundHalfUp, want: 3},
+		{name: "negative half to even", minor: -5, num: 1, den: 2, rounding: RoundHalfEven, want: -2},
+		{name: "negative half to even away", minor: -7, num: 1, den: 2, rounding: RoundHalfEven, want: -4},
+		{name: "negative half up", minor: -5, num: 1, den: 2, rounding: RoundHalfUp, want: -3},
+		{name: "negative above half", minor: -1999, num: 1, den: 3, rounding: RoundHalfUp, want: -666},
+		{name: "negative divisor", minor: 5, num: 1, den: -2, rounding: RoundHalfUp, want: -3},
+		{name: "large operands", minor: math.MaxInt64, num: 3, den: 3, rounding: RoundHalfEven, want: math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewMoney(test.minor, CurrencyUSD).Mul(test.num, test.den, test.rounding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != NewMoney(test.want, CurrencyUSD) {
+				t.Errorf("%d times %d/%d = %v, want %d", test.minor, test.num, test.den, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMoney_Mul_Overflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		minor    int64
+		num, den int64
+	}{
+		{name: "above max", minor: math.MaxInt64, num: 2, den: 1},
+		{name: "below min", minor: math.MinInt64, num: 3, den: 2},
+		{name: "negated min", minor: math.MinInt64, num: -1, den: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewMoney(test.minor, CurrencyUSD).Mul(test.num, test.den, RoundHalfEven)
+			if !errors.Is(err, ErrMoneyOverflow) {
+				t.Errorf("%d times %d/%d error = %v, want %v", test.minor, test.num, test.den, err, ErrMoneyOverflow)
+			}
+		})
+	}
+}
+
+func TestMoney_Prorate(t *testing.T) {
+	month := 30 * 24 * time.Hour
+	tests := []struct {
+		name  string
+		minor int64
+		part  time.Duration
+		want  int64
+	}{
+		{name: "whole period", minor: 999, part: month, want: 999},
+		{name: "nothing", minor: 999, part: 0, want: 0},
+		{name: "a third", minor: 999, part: month / 3, want: 333},
+		{name: "half to even", minor: 999, part: month / 2, want: 500},
+		{name: "half to even down", minor: 1001, part: month / 2, want: 500},
+		{name: "a day", minor: 1999, part: 24 * time.Hour, want: 67},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewMoney(test.minor, CurrencyUSD).Prorate(test.part, month)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != NewMoney(test.want, CurrencyUSD) {
+				t.Errorf("Prorate(%v) of %d = %v, want %d", test.part, test.minor, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMoney_Tax(t *testing.T) {
+	tests := []struct {
+		name        string
+		minor       int64
+		basisPoints int64
+		want        int64
+	}{
+		{name: "exact", minor: 1000, basisPoints: 2000, want: 200},
+		{name: "below half", minor: 999, basisPoints: 825, want: 82},
+		{name: "half up", minor: 1000, basisPoints: 825, want: 83},
+		{name: "half up from even", minor: 200, basisPoints: 125, want: 3},
+		{name: "refund half up", minor: -200, basisPoints: 125, want: -3},
+		{name: "no tax", minor: 999, basisPoints: 0, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewMoney(test.minor, CurrencyUSD).Tax(test.basisPoints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != NewMoney(test.want, CurrencyUSD) {
+				t.Errorf("Tax(%d) of %d = %v, want %d", test.basisPoints, test.minor, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		money Money
+		json  string
+	}{
+		{name: "cents", money: NewMoney(999, CurrencyUSD), json: `{"amount":"9.99","currency":"USD"}`},
+		{name: "negative", money: NewMoney(-5, CurrencyEUR), json: `{"amount":"-0.05","currency":"EUR"}`},
+		{name: "no minor unit", money: NewMoney(1500, CurrencyJPY), json: `{"amount":"1500","currency":"JPY"}`},
+		{name: "zero", money: NewMoney(0, CurrencyUSD), json: `{"amount":"0.00","currency":"USD"}`},
+		{name: "zero value", money: Money{}, json: `{"amount":"0.00"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := json.Marshal(test.money)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != test.json {
+				t.Errorf("Marshal(%v) = %s, want %s", test.money, b, test.json)
+			}
+			var got Money
+			if err = json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != test.money {
+				t.Errorf("Unmarshal(%s) = %v, want %v", b, got, test.money)
+			}
+		})
+	}
+}
+
+func TestMoney_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    Money
+		wantErr error
+	}{
+		{json: `{"amount":9.99,"currency":"USD"}`, want: NewMoney(999, CurrencyUSD)},
+		{json: `{"amount":"12","currency":"EUR"}`, want: NewMoney(1200, CurrencyEUR)},
+		{json: `null`, want: Money{}},
+		{json: `{"amount":0}`, want: Money{}},
+		{json: `{"amount":"1.00"}`, wantErr: ErrUnknownCurrency},
+		{json: `{"amount":"0.001","currency":"USD"}`, wantErr: ErrInvalidMoney},
+		{json: `{"amount":"1","currency":"XXX"}`, wantErr: ErrUnknownCurrency},
+	}
+
+	for _, test := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(test.json), &got)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("Unmarshal(%s) error = %v, wantErr %v", test.json, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Unmarshal(%s) = %v, want %v", test.json, got, test.want)
+		}
+	}
+}
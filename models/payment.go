@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"example/pkg"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentStatusSucceeded PaymentStatus = "succeeded"
+	PaymentStatusFailed    PaymentStatus = "failed"
+	// PaymentStatusRefunded is a successful charge given back to the user
+	PaymentStatusRefunded PaymentStatus = "refunded"
+)
+
+// Payment is a charge of a user's card, successful or not
+type Payment struct {
+	ID pkg.PrimaryKey `json:"id"`
+	// SubscriptionID is the subscription paid for, 0 if the charge failed
+	// before it was created
+	SubscriptionID pkg.PrimaryKey `json:"subscription_id"`
+	UserID         pkg.PrimaryKey `json:"user_id"`
+	Amount         Money          `json:"amount"`
+	Status         PaymentStatus  `json:"status"`
+	// ChargeID is the reference of a successful charge at the gateway
+	ChargeID string `json:"charge_id,omitempty"`
+	// Error is why the charge failed
+	Error string `json:"error,omitempty"`
+	// Attempts is the number of times the gateway was asked to charge
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	// RefundedAt is when the charge was refunded, nil if it was not
+	RefundedAt *time.Time `json:"refunded_at,omitempty"`
+}
+
+func (p *Payment) GetID() pkg.PrimaryKey {
+	return p.ID
+}
+func (p *Payment) SetID(id pkg.PrimaryKey) {
+	p.ID = id
+}
+
+var paymentSchema = pkg.Schema{
+	pkg.Field("UserID", pkg.Required()),
+	pkg.Field("Status", pkg.Required(), pkg.OneOf(string(PaymentStatusSucceeded), string(PaymentStatusFailed), string(PaymentStatusRefunded))),
+}
+
+func (p *Payment) Schema() pkg.Schema {
+	return paymentSchema
+}
+
+var _ pkg.Constrained = (*Payment)(nil)
+
+func init() {
+	pkg.RegisterModel(&Payment{})
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"time"
+
+	"example/pkg"
+)
+
+// Plan is a plan of the catalog, sold at its current price version
+type Plan struct {
+	ID   pkg.PrimaryKey `json:"id"`
+	Type PlanType       `json:"type"`
+	Name string         `json:"name"`
+	// PriceID is the price version new subscribers pay
+	PriceID pkg.PrimaryKey `json:"price_id"`
+	// ArchivedAt is when the plan was closed to new subscribers, nil while it
+	// is open
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (p *Plan) GetID() pkg.PrimaryKey {
+	return p.ID
+}
+func (p *Plan) SetID(id pkg.PrimaryKey) {
+	p.ID = id
+}
+
+var planSchema = pkg.Schema{
+	pkg.Field("Type", pkg.Required(), pkg.Length(1, 32), pkg.Pattern(`^[a-z][a-z0-9_-]*$`)),
+	pkg.Field("Name", pkg.Required(), pkg.Length(1, 64)),
+}
+
+func (p *Plan) Schema() pkg.Schema {
+	return planSchema
+}
+
+// PlanPrice is a version of the price of a plan. Subscribers keep being
+// charged at the version they subscribed at.
+type PlanPrice struct {
+	ID       pkg.PrimaryKey `json:"id"`
+	PlanType PlanType       `json:"plan_type"`
+	// Version numbers the prices of a plan from 1
+	Version int `json:"version"`
+	// Amounts holds the price in each currency the plan is sold in, none on
+	// the free plan. It is replaced rather than changed in place, as rows
+	// read share it with the stored one.
+	Amounts []Money `json:"amounts"`
+	// PeriodDays is the length of a period paid for, 0 on the free plan
+	PeriodDays int       `json:"period_days"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (p *PlanPrice) GetID() pkg.PrimaryKey {
+	return p.ID
+}
+func (p *PlanPrice) SetID(id pkg.PrimaryKey) {
+	p.ID = id
+}
+
+// In returns the price in a currency, and whether the plan is sold in it
+func (p *PlanPrice) In(currency Currency) (Money, bool) {
+	for _, amount := range p.Amounts {
+		if amount.Currency == currency {
+			return amount, true
+		}
+	}
+	return Money{}, false
+}
+
+// Period returns the length of a period paid for at the price
+func (p *PlanPrice) Period() time.Duration {
+	return time.Duration(p.PeriodDays) * 24 * time.Hour
+}
+
+var planPriceSchema = pkg.Schema{
+	pkg.Field("PlanType", pkg.Required()),
+	pkg.Field("Version", pkg.Required()),
+	pkg.Field("PeriodDays", pkg.Range(0, 3660)),
+}
+
+func (p *PlanPrice) Schema() pkg.Schema {
+	return planPriceSchema
+}
+
+// PlanWithPrice is a plan along with its current price
+type PlanWithPrice struct {
+	*Plan
+	Price *PlanPrice `json:"price"`
+}
+
+var (
+	_ pkg.Constrained = (*Plan)(nil)
+	_ pkg.Constrained = (*PlanPrice)(nil)
+)
+
+func init() {
+	pkg.RegisterModel(&Plan{})
+	pkg.RegisterModel(&PlanPrice{})
+}
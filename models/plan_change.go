@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"example/pkg"
+)
+
+// PlanChange is a move of a subscription to another plan, prorated over
+// the rest of the current period
+type PlanChange struct {
+	ID     pkg.PrimaryKey `json:"id"`
+	UserID pkg.PrimaryKey `json:"user_id"`
+	// FromSubscriptionID is the subscription replaced by the one of the new
+	// plan, ToSubscriptionID
+	FromSubscriptionID pkg.PrimaryKey `json:"from_subscription_id"`
+	ToSubscriptionID   pkg.PrimaryKey `json:"to_subscription_id"`
+	FromPlan           PlanType       `json:"from_plan"`
+	ToPlan             PlanType       `json:"to_plan"`
+	// UnusedCredit is the price of the old plan for the rest of the period,
+	// and RemainingCost the price of the new plan for it
+	UnusedCredit  Money `json:"unused_credit"`
+	RemainingCost Money `json:"remaining_cost"`
+	// Amount is RemainingCost less UnusedCredit, charged if it is positive
+	// and credited to the subscription otherwise
+	Amount Money `json:"amount"`
+	// Charged is the part of Amount charged after taking off the credit the
+	// subscription had
+	Charged   Money          `json:"charged"`
+	PaymentID pkg.PrimaryKey `json:"payment_id,omitempty"`
+	// InvoiceID is the invoice of the rest of the period, 0 for trials
+	InvoiceID pkg.PrimaryKey `json:"invoice_id,omitempty"`
+	At        time.Time      `json:"at"`
+}
+
+func (c *PlanChange) GetID() pkg.PrimaryKey {
+	return c.ID
+}
+func (c *PlanChange) SetID(id pkg.PrimaryKey) {
+	c.ID = id
+}
+
+var planChangeSchema = pkg.Schema{
+	pkg.Field("UserID", pkg.Required()),
+	pkg.Field("FromSubscriptionID", pkg.Required()),
+	pkg.Field("ToSubscriptionID", pkg.Required()),
+	pkg.Field("FromPlan", pkg.Required()),
+	pkg.Field("ToPlan", pkg.Required()),
+}
+
+func (c *PlanChange) Schema() pkg.Schema {
+	return planChangeSchema
+}
+
+var _ pkg.Constrained = (*PlanChange)(nil)
+
+func init() {
+	pkg.RegisterModel(&PlanChange{})
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"example/pkg"
+)
+
+type ReminderKind string
+
+const (
+	// ReminderKindExpiry warns that a paid period is about to end, or to be
+	// renewed
+	ReminderKindExpiry ReminderKind = "expiry"
+)
+
+type ReminderStatus string
+
+const (
+	// ReminderStatusPending is a reminder recorded before it is sent. It
+	// stays pending if the server stops while sending it, and is not sent
+	// again, since it may have been delivered.
+	ReminderStatusPending ReminderStatus = "pending"
+	ReminderStatusSent    ReminderStatus = "sent"
+	// ReminderStatusFailed is a reminder the notifier failed to deliver. It
+	// is sent again by the next run.
+	ReminderStatusFailed ReminderStatus = "failed"
+)
+
+// Reminder is a notification sent to a user about a subscription
+type Reminder struct {
+	ID             pkg.PrimaryKey `json:"id"`
+	SubscriptionID pkg.PrimaryKey `json:"subscription_id"`
+	UserID         pkg.PrimaryKey `json:"user_id"`
+	Kind           ReminderKind   `json:"kind"`
+	// Window is how long before the event the reminder was due, such as 7d
+	Window string `json:"window"`
+	// PeriodEnd is when the period the reminder is about ends
+	PeriodEnd time.Time      `json:"period_end"`
+	Status    ReminderStatus `json:"status"`
+	// Error is why the notifier failed to deliver the reminder
+	Error     string     `json:"error,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+}
+
+func (r *Reminder) GetID() pkg.PrimaryKey {
+	return r.ID
+}
+func (r *Reminder) SetID(id pkg.PrimaryKey) {
+	r.ID = id
+}
+
+var reminderSchema = pkg.Schema{
+	pkg.Field("SubscriptionID", pkg.Required()),
+	pkg.Field("UserID", pkg.Required()),
+	pkg.Field("Kind", pkg.Required(), pkg.OneOf(string(ReminderKindExpiry))),
+	pkg.Field("Window", pkg.Required()),
+	pkg.Field("Status", pkg.Required(), pkg.OneOf(
+		string(ReminderStatusPending),
+		string(ReminderStatusSent),
+		string(ReminderStatusFailed),
+	)),
+}
+
+func (r *Reminder) Schema() pkg.Schema {
+	return reminderSchema
+}
+
+var _ pkg.Constrained = (*Reminder)(nil)
+
+func init() {
+	pkg.RegisterModel(&Reminder{})
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"example/pkg"
+)
+
+type RenewalStatus string
+
+const (
+	RenewalStatusSucceeded RenewalStatus = "succeeded"
+	RenewalStatusFailed    RenewalStatus = "failed"
+)
+
+// Renewal is an attempt to charge a subscription for its next period
+type Renewal struct {
+	ID             pkg.PrimaryKey `json:"id"`
+	SubscriptionID pkg.PrimaryKey `json:"subscription_id"`
+	UserID         pkg.PrimaryKey `json:"user_id"`
+	PlanType       PlanType       `json:"plan_type"`
+	// PeriodStart and PeriodEnd bound the period paid for
+	PeriodStart time.Time     `json:"period_start"`
+	PeriodEnd   time.Time     `json:"period_end"`
+	Status      RenewalStatus `json:"status"`
+	// PaymentID is the charge of the attempt, 0 if none was made
+	PaymentID pkg.PrimaryKey `json:"payment_id,omitempty"`
+	// CreditApplied is the credit of the subscription taken off the charge
+	CreditApplied Money `json:"credit_applied,omitempty"`
+	// InvoiceID is the invoice of the period, open until a charge pays it
+	InvoiceID pkg.PrimaryKey `json:"invoice_id,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	At        time.Time      `json:"at"`
+}
+
+func (r *Renewal) GetID() pkg.PrimaryKey {
+	return r.ID
+}
+func (r *Renewal) SetID(id pkg.PrimaryKey) {
+	r.ID = id
+}
+
+var renewalSchema = pkg.Schema{
+	pkg.Field("SubscriptionID", pkg.Required()),
+	pkg.Field("UserID", pkg.Required()),
+	pkg.Field("PlanType", pkg.Required()),
+	pkg.Field("Status", pkg.Required(), pkg.OneOf(string(RenewalStatusSucceeded), string(RenewalStatusFailed))),
+}
+
+func (r *Renewal) Schema() pkg.Schema {
+	return renewalSchema
+}
+
+var _ pkg.Constrained = (*Renewal)(nil)
+
+func init() {
+	pkg.RegisterModel(&Renewal{})
+}
@@ -6,6 +6,8 @@ import (
 	"example/pkg"
 )
 
+// PlanType is the code of a plan. The free plan always exists; the others
+// are in the plan catalog.
 type PlanType string
 
 const (
@@ -14,17 +16,65 @@ const (
 	PlanTypePremium PlanType = "premium"
 )
 
-type Plan struct {
-	Type     PlanType
-	Price    float32
-	Duration time.Duration
-}
+type SubscriptionStatus string
+
+const (
+	// SubscriptionStatusTrialing is a paid plan not charged until its trial
+	// period ends
+	SubscriptionStatusTrialing SubscriptionStatus = "trialing"
+	SubscriptionStatusActive   SubscriptionStatus = "active"
+	// SubscriptionStatusPastDue is a paid plan whose period ended without a
+	// payment for the next one
+	SubscriptionStatusPastDue SubscriptionStatus = "past_due"
+	// SubscriptionStatusCanceled is a subscription ended by its user, or
+	// replaced by another one
+	SubscriptionStatusCanceled SubscriptionStatus = "canceled"
+	// SubscriptionStatusExpired is a paid plan that ended without being
+	// renewed
+	SubscriptionStatusExpired SubscriptionStatus = "expired"
+)
 
 type Subscription struct {
-	ID        pkg.PrimaryKey `json:"id"`
-	UserID    pkg.PrimaryKey `json:"user_id"`
-	PlanType  PlanType       `json:"plan_type"`
-	CreatedAt time.Time      `json:"created_at"`
+	ID        pkg.PrimaryKey     `json:"id"`
+	UserID    pkg.PrimaryKey     `json:"user_id"`
+	PlanType  PlanType           `json:"plan_type"`
+	CreatedAt time.Time          `json:"created_at"`
+	Status    SubscriptionStatus `json:"status"`
+	// CurrentPeriodStart and CurrentPeriodEnd bound the period paid for, or
+	// the trial. Free plans have no end.
+	CurrentPeriodStart time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time `json:"current_period_end"`
+	// PaymentSource is the gateway token of the card paid subscriptions are
+	// charged to. The card number is never stored, only its last digits, in
+	// CardLast4.
+	PaymentSource string `json:"-"`
+	CardLast4     string `json:"card_last4,omitempty"`
+	// PriceID is the price version of the plan the subscription is charged
+	// at, 0 on the free plan
+	PriceID pkg.PrimaryKey `json:"price_id,omitempty"`
+	// CanceledAt is when the subscription was canceled, nil if it was not
+	CanceledAt   *time.Time `json:"canceled_at,omitempty"`
+	CancelReason string     `json:"cancel_reason,omitempty"`
+	// CancelAtPeriodEnd keeps a canceled subscription until the end of the
+	// period it was paid for
+	CancelAtPeriodEnd bool `json:"cancel_at_period_end,omitempty"`
+	// AutoRenew charges a paid subscription for a new period when its
+	// period ends
+	AutoRenew bool `json:"auto_renew"`
+	// PastDueSince is when the paid period ended without a payment for the
+	// next one, nil while the subscription is paid for
+	PastDueSince *time.Time `json:"past_due_since,omitempty"`
+	// PreviousID is the subscription this one replaced when its user changed
+	// plans
+	PreviousID pkg.PrimaryKey `json:"previous_id,omitempty"`
+	// Currency is the currency the subscription is charged in
+	Currency Currency `json:"currency"`
+	// Credit is owed to the user for unused time of a dearer plan, and taken
+	// off the next charges
+	Credit Money `json:"credit"`
+	// EndedAt is when the subscription was canceled or expired, nil while it
+	// runs
+	EndedAt *time.Time `json:"ended_at,omitempty"`
 }
 
 func (s *Subscription) GetID() pkg.PrimaryKey {
@@ -34,4 +84,48 @@ func (s *Subscription) SetID(id pkg.PrimaryKey) {
 	s.ID = id
 }
 
-var _ pkg.Model = (*Subscription)(nil)
+// SubscriptionWithUser is a subscription along with the username of its user
+type SubscriptionWithUser struct {
+	*Subscription
+	Username string `json:"username"`
+}
+
+var subscriptionSchema = pkg.Schema{
+	pkg.Field("UserID", pkg.Required()),
+	pkg.Field("PlanType", pkg.Required()),
+	pkg.Field("Status", pkg.Required(), pkg.OneOf(
+		string(SubscriptionStatusTrialing),
+		string(SubscriptionStatusActive),
+		string(SubscriptionStatusPastDue),
+		string(SubscriptionStatusCanceled),
+		string(SubscriptionStatusExpired),
+	)),
+	pkg.Field("CancelReason", pkg.Length(0, 500)),
+}
+
+func (s *Subscription) Schema() pkg.Schema {
+	return subscriptionSchema
+}
+
+// LatestSubscription is the latest subscription of a user, stored under the
+// ID of the user
+type LatestSubscription struct {
+	UserID       pkg.PrimaryKey
+	Subscription Subscription
+}
+
+func (s *LatestSubscription) GetID() pkg.PrimaryKey {
+	return s.UserID
+}
+func (s *LatestSubscription) SetID(id pkg.PrimaryKey) {
+	s.UserID = id
+}
+
+var (
+	_ pkg.Constrained = (*Subscription)(nil)
+	_ pkg.Model       = (*LatestSubscription)(nil)
+)
+
+func init() {
+	pkg.RegisterModel(&Subscription{})
+}
@@ -14,4 +14,17 @@ func (u *User) SetID(id pkg.PrimaryKey) {
 	u.ID = id
 }
 
-var _ pkg.Model = (*User)(nil)
+var userSchema = pkg.Schema{
+	// usernames have no leading or trailing spaces
+	pkg.Field("Username", pkg.Required(), pkg.Length(1, 64), pkg.Pattern(`^\S(.*\S)?$`)),
+}
+
+func (u *User) Schema() pkg.Schema {
+	return userSchema
+}
+
+var _ pkg.Constrained = (*User)(nil)
+
+func init() {
+	pkg.RegisterModel(&User{})
+}
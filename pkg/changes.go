@@ -0,0 +1,256 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Op is the kind of write recorded in a Change
+type Op int
+
+const (
+	// OpPut stores Model under Key, inserting or replacing it
+	OpPut Op = iota + 1
+	// OpDelete removes the row stored under Key
+	OpDelete
+)
+
+// Change is a single write to a table. Changes are numbered by Seq in the
+// order they were committed across the whole database.
+type Change struct {
+	Seq   uint64
+	Table string
+	Op    Op
+	Key   PrimaryKey
+	Model Model
+}
+
+// defaultBacklog is the number of recent changes kept to let a watcher that
+// fell behind catch up without a full copy of the database.
+const defaultBacklog = 4096
+
+// changeLog numbers the changes of a database and hands them to watchers
+// and views.
+type changeLog struct {
+	mu sync.Mutex
+	// epoch identifies the history numbered by seq, which starts over with
+	// every database
+	epoch uint64
+	seq   uint64
+	// backlog is a ring holding the last len(backlog) changes, the oldest
+	// at index head
+	backlog  []Change
+	head     int
+	size     int
+	watchers map[*watcher]struct{}
+	// snapshots counts the open snapshots by the sequence number they read at
+	snapshots map[uint64]int
+	// views holds the views computed from each table
+	views map[string][]*view
+}
+
+// watcher receives every batch of changes committed after it was created.
+// Its channel is closed if it falls too far behind.
+type watcher struct {
+	c chan []Change
+}
+
+func newChangeLog(capacity int) *changeLog {
+	return &changeLog{
+		epoch:     newEpoch(),
+		backlog:   make([]Change, capacity),
+		watchers:  make(map[*watcher]struct{}),
+		snapshots: make(map[uint64]int),
+		views:     make(map[string][]*view),
+	}
+}
+
+// newEpoch returns a random, non-zero epoch.
+func newEpoch() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// the clock still tells restarts apart
+		return uint64(time.Now().UnixNano()) | 1
+	}
+	return binary.BigEndian.Uint64(b[:]) | 1
+}
+
+// append numbers changes, applies them to the views computed from their
+// tables and hands them to the watchers as one batch. It is
+// called with the lock of the table the changes belong to held, so changes
+// to a row are numbered in the order they were applied. It returns the
+// sequence number of the oldest open snapshot, if any, so the table can
+// keep the versions that snapshot still reads.
+func (l *changeLog) append(changes []Change) (oldest uint64, open bool) {
+	if l == nil || len(changes) == 0 {
+		return 0, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range changes {
+		l.seq++
+		changes[i].Seq = l.seq
+		if len(l.backlog) == 0 {
+			continue
+		}
+		if l.size < len(l.backlog) {
+			l.backlog[(l.head+l.size)%len(l.backlog)] = changes[i]
+			l.size++
+		} else {
+			l.backlog[l.head] = changes[i]
+			l.head = (l.head + 1) % len(l.backlog)
+		}
+	}
+	for _, c := range changes {
+		for _, v := range l.views[c.Table] {
+			v.apply(c)
+		}
+	}
+	for w := range l.watchers {
+		select {
+		case w.c <- changes:
+		default:
+			close(w.c)
+			delete(l.watchers, w)
+		}
+	}
+	return l.oldestSnapshotLocked()
+}
+
+// watch returns a watcher for the changes committed from now on, the
+// sequence number of the last committed change and, if they are all still
+// in the backlog, the changes committed after since.
+func (l *changeLog) watch(since uint64, buffer int) (w *watcher, seq uint64, missed []Change, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w = &watcher{c: make(chan []Change, buffer)}
+	l.watchers[w] = struct{}{}
+	if since > l.seq {
+		return w, l.seq, nil, false
+	}
+	if since == l.seq {
+		return w, l.seq, nil, true
+	}
+	if uint64(l.size) < l.seq-since {
+		return w, l.seq, nil, false
+	}
+	missed = make([]Change, 0, l.seq-since)
+	for i := l.size - int(l.seq-since); i < l.size; i++ {
+		missed = append(missed, l.backlog[(l.head+i)%len(l.backlog)])
+	}
+	return w, l.seq, missed, true
+}
+
+func (l *changeLog) unwatch(w *watcher) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.watchers[w]; ok {
+		delete(l.watchers, w)
+		close(w.c)
+	}
+}
+
+// addView has the changes to the sources of v applied to it from now on.
+func (l *changeLog) addView(v *view) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, source := range v.def.Sources {
+		l.views[source] = append(l.views[source], v)
+	}
+}
+
+// refresh reduces the groups of the views computed from table that writes
+// left stale. Writers call it once they released the locks of the tables
+// they wrote, as reducing a group reads every part of its sources. A group
+// that fails to be reduced stays stale until the next write refreshes it.
+func (l *changeLog) refresh(table string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	views := append([]*view(nil), l.views[table]...)
+	l.mu.Unlock()
+	for _, v := range views {
+		_ = v.refresh()
+	}
+}
+
+// allViews returns every view, once.
+func (l *changeLog) allViews() []*view {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seen := make(map[*view]struct{})
+	var views []*view
+	for _, vs := range l.views {
+		for _, v := range vs {
+			if _, ok := seen[v]; !ok {
+				seen[v] = struct{}{}
+				views = append(views, v)
+			}
+		}
+	}
+	return views
+}
+
+// replaceViews has the changes to the sources of views applied to them
+// instead of the views added so far.
+func (l *changeLog) replaceViews(views []*view) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.views = make(map[string][]*view)
+	for _, v := range views {
+		for _, source := range v.def.Sources {
+			l.views[source] = append(l.views[source], v)
+		}
+	}
+}
+
+// clear removes the rows of a table that was emptied without recording
+// changes from the views computed from it.
+func (l *changeLog) clear(table string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, v := range l.views[table] {
+		v.clear(table)
+	}
+}
+
+func (l *changeLog) lastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// openSnapshot registers a snapshot reading every change committed so far
+// and returns the sequence number it reads at.
+func (l *changeLog) openSnapshot() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.snapshots[l.seq]++
+	return l.seq
+}
+
+// releaseSnapshot unregisters a snapshot and returns the sequence number of
+// the oldest snapshot still open, if any.
+func (l *changeLog) releaseSnapshot(seq uint64) (oldest uint64, open bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.snapshots[seq]--; l.snapshots[seq] <= 0 {
+		delete(l.snapshots, seq)
+	}
+	return l.oldestSnapshotLocked()
+}
+
+func (l *changeLog) oldestSnapshotLocked() (oldest uint64, open bool) {
+	for seq := range l.snapshots {
+		if !open || seq < oldest {
+			oldest, open = seq, true
+		}
+	}
+	return oldest, open
+}
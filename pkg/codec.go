@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// RegisterModel records the concrete type of a model so that it can be
+// written to and read back from disk. Every model stored in a database that
+// persists rows, for example one with a memory budget, must be registered.
+func RegisterModel(m Model) {
+	gob.Register(m)
+}
+
+// record wraps a model so that gob keeps its concrete type.
+type record struct {
+	Model Model
+}
+
+func encodeModel(m Model) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&record{Model: m}); err != nil {
+		return nil, fmt.Errorf("error encoding model: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeModel(b []byte) (Model, error) {
+	var r record
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error decoding model: %w", err)
+	}
+	return r.Model, nil
+}
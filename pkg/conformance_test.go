@@ -0,0 +1,29 @@
+package pkg_test
+
+import (
+	"testing"
+
+	"example/pkg"
+	"example/pkg/pkgtest"
+)
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(t *testing.T) []pkg.Option
+	}{
+		{"memory", func(*testing.T) []pkg.Option { return nil }},
+		{"sharded", func(*testing.T) []pkg.Option { return []pkg.Option{pkg.WithShards(4)} }},
+		{"spilled", func(t *testing.T) []pkg.Option {
+			return []pkg.Option{pkg.WithMemoryBudget(pkg.MemoryBudget{MaxRows: 2, Dir: t.TempDir()})}
+		}},
+		{"metered", func(*testing.T) []pkg.Option { return []pkg.Option{pkg.WithMetrics(pkg.NewPrometheus())} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkgtest.RunDB(t, func(t *testing.T) pkg.DB {
+				return pkg.NewDB(tt.opts(t)...)
+			})
+		})
+	}
+}
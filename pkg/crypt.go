@@ -0,0 +1,295 @@
+package pkg
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUnknownKey  = fmt.Errorf("unknown encryption key")
+	ErrInvalidKey  = fmt.Errorf("invalid encryption key")
+	ErrInvalidFile = fmt.Errorf("not a database file")
+)
+
+// Every file the database persists starts with a header made of fileMagic,
+// the format version and the ID of the key its records are encrypted with.
+// An empty key ID means the records are stored in the clear. The header is
+// followed by records, each prefixed with the big endian uint32 length of its
+// sealed bytes and the big endian uint64 key of the row it holds, which it is
+// sealed with so that it can be opened, and re-encrypted, offline.
+var fileMagic = [4]byte{'E', 'X', 'D', 'B'}
+
+const fileVersion = 2
+
+// recordPrefix is the size of the prefix of a record.
+const recordPrefix = 12
+
+// Keyring holds the AES-256 keys used to encrypt the files the database
+// persists. New files are encrypted with the active key; the other keys are
+// kept to read files written before a rotation, which RotateFile
+// re-encrypts with the active key.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring whose active key is keys[active]. Keys must be
+// 32 bytes long.
+func NewKeyring(active string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %q", ErrUnknownKey, active)
+	}
+	k := &Keyring{
+		active: active,
+		keys:   make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("%w: key ID %q", ErrInvalidKey, id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%w: key %q must be 32 bytes", ErrInvalidKey, id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidKey, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidKey, err)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring parses keys written as comma separated id:base64-key pairs,
+// the format used to pass keys through configuration.
+func ParseKeyring(active, spec string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: expected id:key, got %q", ErrInvalidKey, pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q: %s", ErrInvalidKey, id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(active, keys)
+}
+
+// ActiveKeyID returns the ID of the key new files are encrypted with
+func (k *Keyring) ActiveKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.active
+}
+
+// sealer returns the sealer for the records of a file encrypted with keyID.
+func (k *Keyring) sealer(keyID string) (*sealer, error) {
+	if keyID == "" {
+		return &sealer{}, nil
+	}
+	if k == nil || k.keys[keyID] == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	return &sealer{keyID: keyID, aead: k.keys[keyID]}, nil
+}
+
+// sealer encrypts and authenticates the records of one file. The zero value
+// stores records in the clear.
+type sealer struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+// additionalData returns what a record is bound to besides its contents:
+// the key it is sealed with, the row it holds and where it is stored, so
+// that a record moved or swapped for another fails to open.
+func (s *sealer) additionalData(key PrimaryKey, offset int64) []byte {
+	ad := make([]byte, len(s.keyID)+16)
+	n := copy(ad, s.keyID)
+	binary.BigEndian.PutUint64(ad[n:], uint64(key))
+	binary.BigEndian.PutUint64(ad[n+8:], uint64(offset))
+	return ad
+}
+
+func (s *sealer) seal(b []byte, key PrimaryKey, offset int64) ([]byte, error) {
+	if s.aead == nil {
+		return b, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(b)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return s.aead.Seal(nonce, nonce, b, s.additionalData(key, offset)), nil
+}
+
+func (s *sealer) open(b []byte, key PrimaryKey, offset int64) ([]byte, error) {
+	if s.aead == nil {
+		return b, nil
+	}
+	if len(b) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: truncated record", ErrInvalidFile)
+	}
+	nonce, ciphertext := b[:s.aead.NonceSize()], b[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, s.additionalData(key, offset))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting record: %w", err)
+	}
+	return plain, nil
+}
+
+func writeHeader(w io.Writer, keyID string) (int, error) {
+	header := make([]byte, 0, len(fileMagic)+2+len(keyID))
+	header = append(header, fileMagic[:]...)
+	header = append(header, fileVersion, byte(len(keyID)))
+	header = append(header, keyID...)
+	return w.Write(header)
+}
+
+// readHeader reads the header of a file and returns the ID of the key its
+// records are encrypted with and the size of the header.
+func readHeader(r io.Reader) (string, int, error) {
+	fixed := make([]byte, len(fileMagic)+2)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return "", 0, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+	if string(fixed[:len(fileMagic)]) != string(fileMagic[:]) || fixed[len(fileMagic)] != fileVersion {
+		return "", 0, ErrInvalidFile
+	}
+	keyID := make([]byte, fixed[len(fileMagic)+1])
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return "", 0, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+	return string(keyID), len(fixed) + len(keyID), nil
+}
+
+// frameRecord returns b, the row of key, sealed and prefixed for a record
+// stored at offset.
+func frameRecord(s *sealer, b []byte, key PrimaryKey, offset int64) ([]byte, error) {
+	sealed, err := s.seal(b, key, offset+recordPrefix)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, recordPrefix+len(sealed))
+	binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+	binary.BigEndian.PutUint64(frame[4:], uint64(key))
+	copy(frame[recordPrefix:], sealed)
+	return frame, nil
+}
+
+// readRecord reads and opens the record of r stored at offset, and returns
+// the row it holds with its key and the size of the record. It returns
+// io.EOF when there are no more records.
+func readRecord(r io.Reader, s *sealer, offset int64) (PrimaryKey, []byte, int, error) {
+	var prefix [recordPrefix]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil, 0, io.EOF
+		}
+		return 0, nil, 0, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+	key := PrimaryKey(binary.BigEndian.Uint64(prefix[4:]))
+	sealed := make([]byte, binary.BigEndian.Uint32(prefix[:4]))
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return 0, nil, 0, fmt.Errorf("%w: %s", ErrInvalidFile, err)
+	}
+	b, err := s.open(sealed, key, offset+recordPrefix)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return key, b, recordPrefix + len(sealed), nil
+}
+
+// RotateFile re-encrypts a file written by the database with the active key
+// of the keyring. The key the file was written with must be in the keyring.
+// Records are bound to where they are stored, so each is opened at its
+// offset in the file and sealed again at its offset in the new one, which
+// differs when the key IDs differ in length. The file is replaced only once
+// it has been completely rewritten; it must not be in use by a running
+// database.
+func RotateFile(path string, keys *Keyring) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	keyID, n, err := readHeader(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	from, err := keys.sealer(keyID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	to, err := keys.sealer(keys.ActiveKeyID())
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), ".rekey-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	written, err := writeHeader(w, to.keyID)
+	if err != nil {
+		return err
+	}
+	offset, newOffset := int64(n), int64(written)
+	for {
+		key, b, n, err := readRecord(r, from, offset)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: record at %d: %w", path, offset, err)
+		}
+		offset += int64(n)
+		frame, err := frameRecord(to, b, key, newOffset)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(frame); err != nil {
+			return err
+		}
+		newOffset += int64(len(frame))
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if info, err := in.Stat(); err == nil {
+		if err = os.Chmod(out.Name(), info.Mode()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(out.Name(), path)
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testKey(1))
+	tests := []struct {
+		name    string
+		active  string
+		spec    string
+		wantErr error
+	}{
+		{
+			name:   "valid",
+			active: "k2",
+			spec:   "k1:" + key + ", k2:" + key,
+		},
+		{
+			name:    "missing active key",
+			active:  "k3",
+			spec:    "k1:" + key,
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name:    "malformed pair",
+			active:  "k1",
+			spec:    "k1" + key,
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "short key",
+			active:  "k1",
+			spec:    "k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+			wantErr: ErrInvalidKey,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := ParseKeyring(test.active, test.spec)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s error = %v, wantErr %v", test.name, err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if keys.ActiveKeyID() != test.active {
+				t.Errorf("%s active = %v, want %v", test.name, keys.ActiveKeyID(), test.active)
+			}
+		})
+	}
+}
+
+func TestSealer(t *testing.T) {
+	keys, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, _ := keys.sealer("k1")
+	s2, _ := keys.sealer("k2")
+	sealed, err := s1.seal([]byte("alice"), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("alice")) {
+		t.Errorf("sealed record contains the plaintext")
+	}
+	got, err := s1.open(sealed, 1, 10)
+	if err != nil || string(got) != "alice" {
+		t.Errorf("open = %q, %v, want %q", got, err, "alice")
+	}
+
+	tests := []struct {
+		name   string
+		s      *sealer
+		key    PrimaryKey
+		offset int64
+	}{
+		{name: "wrong encryption key", s: s2, key: 1, offset: 10},
+		{name: "other row", s: s1, key: 2, offset: 10},
+		{name: "other offset", s: s1, key: 1, offset: 20},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.s.open(sealed, test.key, test.offset); err == nil {
+				t.Errorf("%s opened the record", test.name)
+			}
+		})
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err = s1.open(sealed, 1, 10); err == nil {
+		t.Errorf("opened a tampered record")
+	}
+	if _, err = keys.sealer("k3"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("error = %v, wantErr %v", err, ErrUnknownKey)
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	old, err := NewKeyring("old", map[string][]byte{"old": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the new key ID is longer, so every record moves in the file
+	path := writeTestFile(t, t.TempDir(), old, "alice", "bob")
+
+	both, err := NewKeyring("newer", map[string][]byte{"old": testKey(1), "newer": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = RotateFile(path, both); err != nil {
+		t.Fatal(err)
+	}
+	current, err := NewKeyring("newer", map[string][]byte{"newer": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, rows := readTestFile(t, path, current)
+	if keyID != "newer" {
+		t.Errorf("key ID = %v, want %v", keyID, "newer")
+	}
+	if got := fmt.Sprint(rows); got != "[1:alice 2:bob]" {
+		t.Errorf("rows = %v, want [1:alice 2:bob]", got)
+	}
+	if err = RotateFile(path, old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("error = %v, wantErr %v", err, ErrUnknownKey)
+	}
+}
+
+func TestSpill_Encrypted(t *testing.T) {
+	keys, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	d := NewDB(WithMemoryBudget(MemoryBudget{MaxRows: 1, Dir: dir}), WithEncryption(keys))
+	defer d.Close()
+	if err = d.AddTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	table, _ := d.Table("users")
+	for _, data := range []string{"secret-alice", "secret-bob"} {
+		if err = table.Insert(&testModel{Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, file := range files {
+		b, _ := os.ReadFile(file)
+		if bytes.Contains(b, []byte("secret-")) {
+			t.Errorf("%s contains plaintext rows", file)
+		}
+	}
+	got, err := table.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.(*testModel).Data != "secret-alice" {
+		t.Errorf("data = %v, want %v", got.(*testModel).Data, "secret-alice")
+	}
+}
+
+// writeTestFile writes rows holding data to an overflow file encrypted with
+// the active key of keys, keyed from 1, and returns its path.
+func writeTestFile(t *testing.T, dir string, keys *Keyring, data ...string) string {
+	t.Helper()
+	o, err := newOverflow(dir, "users", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.file.Close()
+	for i, d := range data {
+		key := PrimaryKey(i + 1)
+		if _, err = o.write(key, &testModel{ID: key, Data: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return o.file.Name()
+}
+
+// readTestFile reads the rows of a file as key:data.
+func readTestFile(t *testing.T, path string, keys *Keyring) (string, []string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	keyID, n, err := readHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := keys.sealer(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for offset := int64(n); ; {
+		key, b, n, err := readRecord(r, s, offset)
+		if errors.Is(err, io.EOF) {
+			return keyID, rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset += int64(n)
+		m, err := decodeModel(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, fmt.Sprintf("%d:%s", key, m.(*testModel).Data))
+	}
+}
@@ -3,6 +3,7 @@ package pkg
 import (
 	"fmt"
 	"sort"
+	"sync"
 )
 
 var (
@@ -28,27 +29,116 @@ type DB interface {
 	Table(string) (Table, error)
 	// AddTable adds a new table to the database
 	AddTable(string) error
+	// AddView adds a read-only table derived from other tables
+	AddView(string, View) error
+	// Snapshot returns a consistent read-only view of the database as it is now
+	Snapshot() Snapshot
+	// SpillStats returns how the database uses its overflow files
+	SpillStats() SpillStats
+	// Close releases the resources held by the database, such as overflow files
+	Close() error
 }
 
 type db struct {
-	tables map[string]Table
+	mu      sync.RWMutex
+	tables  map[string]Table
+	budget  *budget
+	keys    *Keyring
+	log     *changeLog
+	shards  int
+	metrics MetricsSink
+}
+
+// partitioned is implemented by tables made of one or more plain tables.
+type partitioned interface {
+	// parts returns the plain tables
+	parts() []*table
+	// route returns the plain table holding key
+	route(PrimaryKey) *table
+}
+
+// partsOf returns the plain tables holding the rows of t.
+func partsOf(t Table) []*table {
+	if p, ok := t.(partitioned); ok {
+		return p.parts()
+	}
+	return nil
 }
 
 func (d *db) AddTable(s string) error {
 	if s == "" {
 		return ErrorNoTableName
 	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if _, ok := d.tables[s]; ok {
 		return ErrTableExists
 	}
-	d.tables[s] = &table{
-		name: s,
-		data: make(map[PrimaryKey]Model),
+	t, err := d.newTable(s, d.log)
+	if err != nil {
+		return err
 	}
+	d.tables[s] = t
 	return nil
 }
 
+// newTable returns an empty table named s, not yet part of the database,
+// whose writes are recorded in log. A nil log records nothing.
+func (d *db) newTable(s string, log *changeLog) (Table, error) {
+	var t Table
+	var parts []*table
+	if d.shards > 1 {
+		sharded := newShardedTable(s, d.shards)
+		t, parts = sharded, sharded.parts()
+	} else {
+		plain := &table{
+			name: s,
+			data: make(map[PrimaryKey]Model),
+		}
+		t, parts = plain, plain.parts()
+	}
+	for i, part := range parts {
+		part.log = log
+		if d.budget == nil {
+			continue
+		}
+		name := s
+		if len(parts) > 1 {
+			name = shardName(s, i)
+		}
+		overflow, err := newOverflow(d.budget.limits.Dir, name, d.keys)
+		if err != nil {
+			for _, created := range parts[:i] {
+				created.overflow.close()
+			}
+			return nil, err
+		}
+		part.budget = d.budget
+		part.overflow = overflow
+	}
+	return t, nil
+}
+
+// discard releases the memory budget and the overflow files of a table
+// removed from the database. Reads of its spilled rows fail from then on.
+func discard(t Table) error {
+	var firstErr error
+	for _, part := range partsOf(t) {
+		part.mu.Lock()
+		for key := range part.data {
+			part.budget.forget(part, key)
+		}
+		if err := part.overflow.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		part.mu.Unlock()
+	}
+	return firstErr
+}
+
 func (d *db) Tables() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	var tables []string
 	for name := range d.tables {
 		tables = append(tables, name)
@@ -58,20 +148,72 @@ func (d *db) Tables() []string {
 }
 
 func (d *db) Table(s string) (Table, error) {
+	t, err := d.table(s)
+	if err != nil {
+		return nil, err
+	}
+	if d.metrics != nil {
+		return &meteredTable{Table: t, sink: d.metrics}, nil
+	}
+	return t, nil
+}
+
+// table returns a table as stored, without the wrappers added for callers.
+func (d *db) table(s string) (Table, error) {
 	if s == "" {
 		return nil, ErrorNoTableName
 	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	if table, ok := d.tables[s]; ok {
 		return table, nil
 	}
 	return nil, ErrorNoTable
 }
 
+func (d *db) SpillStats() SpillStats {
+	if d.budget == nil {
+		return SpillStats{}
+	}
+	stats := d.budget.statsSnapshot()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, t := range d.tables {
+		for _, part := range partsOf(t) {
+			part.mu.RLock()
+			stats.OverflowRows += part.overflow.len()
+			part.mu.RUnlock()
+		}
+	}
+	return stats
+}
+
+func (d *db) Close() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var firstErr error
+	for _, t := range d.tables {
+		for _, part := range partsOf(t) {
+			part.mu.Lock()
+			if err := part.overflow.close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			part.mu.Unlock()
+		}
+	}
+	return firstErr
+}
+
 // NewDB returns a new database
-func NewDB() DB {
-	return &db{
+func NewDB(opts ...Option) DB {
+	d := &db{
 		tables: make(map[string]Table),
+		log:    newChangeLog(defaultBacklog),
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	return d
 }
 
 var _ DB = &db{}
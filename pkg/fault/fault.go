@@ -0,0 +1,259 @@
+// Package fault wraps a pkg.DB so that tests can make chosen operations fail
+// or slow down, and so exercise the error handling of the code using it.
+//
+//	db := fault.Wrap(pkg.NewDB())
+//	db.Inject(fault.Rule{Table: "subscription", Op: pkg.OpNameInsert, Err: fault.ErrInjected})
+package fault
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"example/pkg"
+)
+
+// ErrInjected is an error for rules that do not need a specific one
+var ErrInjected = fmt.Errorf("injected fault")
+
+// Operation names of the database itself, in addition to the table
+// operation names of pkg such as pkg.OpNameInsert
+const (
+	// OpNameTable is DB.Table and Snapshot.Table
+	OpNameTable = "table"
+	// OpNameAddTable is DB.AddTable
+	OpNameAddTable = "add_table"
+)
+
+// Rule describes the fault injected into the calls it matches.
+type Rule struct {
+	// Table is the table the rule applies to, or every table if empty
+	Table string
+	// Op is the operation the rule applies to, or every operation if empty
+	Op string
+	// Nth applies the rule to the nth matching call only, counting from 1,
+	// or to every matching call if 0
+	Nth int
+	// Delay is how long matching calls wait before running
+	Delay time.Duration
+	// Err, if not nil, is returned by matching calls instead of running them
+	Err error
+}
+
+type rule struct {
+	Rule
+	calls int
+}
+
+// DB is a pkg.DB whose operations fail or wait as its rules say. Operations
+// no rule matches are passed to the wrapped database.
+type DB struct {
+	pkg.DB
+	mu    sync.Mutex
+	rules []*rule
+}
+
+// Wrap returns d with no rules
+func Wrap(d pkg.DB) *DB {
+	return &DB{DB: d}
+}
+
+// Inject adds a rule. Calls made before it are not counted for Nth.
+func (d *DB) Inject(r Rule) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rules = append(d.rules, &rule{Rule: r})
+}
+
+// Reset removes every rule
+func (d *DB) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rules = nil
+}
+
+// fault waits for the delays of the rules matching a call and returns the
+// error of the first of them that has one.
+func (d *DB) fault(table, op string) error {
+	var delay time.Duration
+	var err error
+	d.mu.Lock()
+	for _, r := range d.rules {
+		if (r.Table != "" && r.Table != table) || (r.Op != "" && r.Op != op) {
+			continue
+		}
+		r.calls++
+		if r.Nth != 0 && r.calls != r.Nth {
+			continue
+		}
+		delay += r.Delay
+		if err == nil {
+			err = r.Err
+		}
+	}
+	d.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return err
+}
+
+func (d *DB) AddTable(s string) error {
+	if err := d.fault(s, OpNameAddTable); err != nil {
+		return err
+	}
+	return d.DB.AddTable(s)
+}
+
+func (d *DB) Table(s string) (pkg.Table, error) {
+	if err := d.fault(s, OpNameTable); err != nil {
+		return nil, err
+	}
+	t, err := d.DB.Table(s)
+	if err != nil {
+		return nil, err
+	}
+	return &table{Table: t, db: d}, nil
+}
+
+func (d *DB) Snapshot() pkg.Snapshot {
+	return &snapshot{Snapshot: d.DB.Snapshot(), db: d}
+}
+
+type snapshot struct {
+	pkg.Snapshot
+	db *DB
+}
+
+func (s *snapshot) Table(name string) (pkg.Reader, error) {
+	if err := s.db.fault(name, OpNameTable); err != nil {
+		return nil, err
+	}
+	r, err := s.Snapshot.Table(name)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{Reader: r, db: s.db}, nil
+}
+
+type reader struct {
+	pkg.Reader
+	db *DB
+}
+
+func (r *reader) Get(key pkg.PrimaryKey) (pkg.Model, error) {
+	if err := r.db.fault(r.Name(), pkg.OpNameGet); err != nil {
+		return nil, err
+	}
+	return r.Reader.Get(key)
+}
+
+func (r *reader) Find(f func(pkg.Model) bool) ([]pkg.Model, error) {
+	if err := r.db.fault(r.Name(), pkg.OpNameFind); err != nil {
+		return nil, err
+	}
+	return r.Reader.Find(f)
+}
+
+func (r *reader) Scan(f func(pkg.Model) bool) error {
+	if err := r.db.fault(r.Name(), pkg.OpNameScan); err != nil {
+		return err
+	}
+	return r.Reader.Scan(f)
+}
+
+type table struct {
+	pkg.Table
+	db *DB
+}
+
+func (t *table) Get(key pkg.PrimaryKey) (pkg.Model, error) {
+	if err := t.db.fault(t.Name(), pkg.OpNameGet); err != nil {
+		return nil, err
+	}
+	return t.Table.Get(key)
+}
+
+func (t *table) Find(f func(pkg.Model) bool) ([]pkg.Model, error) {
+	if err := t.db.fault(t.Name(), pkg.OpNameFind); err != nil {
+		return nil, err
+	}
+	return t.Table.Find(f)
+}
+
+func (t *table) Scan(f func(pkg.Model) bool) error {
+	if err := t.db.fault(t.Name(), pkg.OpNameScan); err != nil {
+		return err
+	}
+	return t.Table.Scan(f)
+}
+
+func (t *table) Insert(m pkg.Model) error {
+	if err := t.db.fault(t.Name(), pkg.OpNameInsert); err != nil {
+		return err
+	}
+	return t.Table.Insert(m)
+}
+
+func (t *table) Update(m pkg.Model) error {
+	if err := t.db.fault(t.Name(), pkg.OpNameUpdate); err != nil {
+		return err
+	}
+	return t.Table.Update(m)
+}
+
+func (t *table) Delete(key pkg.PrimaryKey) error {
+	if err := t.db.fault(t.Name(), pkg.OpNameDelete); err != nil {
+		return err
+	}
+	return t.Table.Delete(key)
+}
+
+func (t *table) InsertMany(ms []pkg.Model) error {
+	if err := t.db.fault(t.Name(), pkg.OpNameInsertMany); err != nil {
+		return err
+	}
+	return t.Table.InsertMany(ms)
+}
+
+func (t *table) UpdateMany(ms []pkg.Model) error {
+	if err := t.db.fault(t.Name(), pkg.OpNameUpdateMany); err != nil {
+		return err
+	}
+	return t.Table.UpdateMany(ms)
+}
+
+func (t *table) UpdateWhere(match func(pkg.Model) bool, update func(pkg.Model) error) (int, error) {
+	if err := t.db.fault(t.Name(), pkg.OpNameUpdateWhere); err != nil {
+		return 0, err
+	}
+	return t.Table.UpdateWhere(match, update)
+}
+
+func (t *table) DeleteWhere(f func(pkg.Model) bool) (int, error) {
+	if err := t.db.fault(t.Name(), pkg.OpNameDeleteWhere); err != nil {
+		return 0, err
+	}
+	return t.Table.DeleteWhere(f)
+}
+
+func (t *table) Upsert(m pkg.Model) (bool, error) {
+	if err := t.db.fault(t.Name(), pkg.OpNameUpsert); err != nil {
+		return false, err
+	}
+	return t.Table.Upsert(m)
+}
+
+func (t *table) CompareAndSwap(key pkg.PrimaryKey, expected, new pkg.Model) (bool, error) {
+	if err := t.db.fault(t.Name(), pkg.OpNameCompareAndSwap); err != nil {
+		return false, err
+	}
+	return t.Table.CompareAndSwap(key, expected, new)
+}
+
+var (
+	_ pkg.DB       = &DB{}
+	_ pkg.Snapshot = &snapshot{}
+	_ pkg.Reader   = &reader{}
+	_ pkg.Table    = &table{}
+)
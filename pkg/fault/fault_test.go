@@ -0,0 +1,178 @@
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"example/pkg"
+	"example/pkg/pkgtest"
+)
+
+func newTestDB(t *testing.T, rules ...Rule) *DB {
+	t.Helper()
+	d := Wrap(pkg.NewDB())
+	for _, name := range []string{"a", "b"} {
+		if err := d.AddTable(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range rules {
+		d.Inject(r)
+	}
+	return d
+}
+
+func TestDB(t *testing.T) {
+	other := fmt.Errorf("other")
+	tests := []struct {
+		name  string
+		rules []Rule
+		// calls are the operations done in order, as table and op
+		calls [][2]string
+		// want are the errors the calls return
+		want []error
+	}{
+		{
+			name:  "no rules",
+			calls: [][2]string{{"a", pkg.OpNameInsert}, {"a", pkg.OpNameGet}},
+			want:  []error{nil, nil},
+		},
+		{
+			name:  "table and op",
+			rules: []Rule{{Table: "a", Op: pkg.OpNameInsert, Err: ErrInjected}},
+			calls: [][2]string{{"a", pkg.OpNameInsert}, {"b", pkg.OpNameInsert}, {"a", pkg.OpNameFind}, {"a", pkg.OpNameInsert}},
+			want:  []error{ErrInjected, nil, nil, ErrInjected},
+		},
+		{
+			name:  "any table",
+			rules: []Rule{{Op: pkg.OpNameFind, Err: ErrInjected}},
+			calls: [][2]string{{"a", pkg.OpNameFind}, {"b", pkg.OpNameFind}, {"b", pkg.OpNameInsert}},
+			want:  []error{ErrInjected, ErrInjected, nil},
+		},
+		{
+			name:  "any op",
+			rules: []Rule{{Table: "b", Err: ErrInjected}},
+			calls: [][2]string{{"a", pkg.OpNameInsert}, {"b", pkg.OpNameInsert}, {"b", OpNameTable}},
+			want:  []error{nil, ErrInjected, ErrInjected},
+		},
+		{
+			name:  "nth call",
+			rules: []Rule{{Table: "a", Op: pkg.OpNameInsert, Nth: 2, Err: ErrInjected}},
+			calls: [][2]string{{"a", pkg.OpNameInsert}, {"b", pkg.OpNameInsert}, {"a", pkg.OpNameInsert}, {"a", pkg.OpNameInsert}},
+			want:  []error{nil, nil, ErrInjected, nil},
+		},
+		{
+			name:  "first error wins",
+			rules: []Rule{{Table: "a", Err: other}, {Err: ErrInjected}},
+			calls: [][2]string{{"a", pkg.OpNameGet}, {"b", pkg.OpNameGet}},
+			want:  []error{other, ErrInjected},
+		},
+		{
+			name:  "add table",
+			rules: []Rule{{Op: OpNameAddTable, Err: ErrInjected}},
+			calls: [][2]string{{"c", OpNameAddTable}, {"a", OpNameTable}},
+			want:  []error{ErrInjected, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDB(t, tt.rules...)
+			for i, call := range tt.calls {
+				if err := do(d, call[0], call[1]); !errors.Is(err, tt.want[i]) {
+					t.Errorf("call %d %v error = %v, wantErr %v", i, call, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// do runs op on the table named name of d.
+func do(d *DB, name, op string) error {
+	switch op {
+	case OpNameAddTable:
+		return d.AddTable(name)
+	case OpNameTable:
+		_, err := d.Table(name)
+		return err
+	}
+	t, err := d.Table(name)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case pkg.OpNameInsert:
+		return t.Insert(&pkgtest.Item{})
+	case pkg.OpNameGet:
+		_, err = t.Get(1)
+		if errors.Is(err, pkg.ErrNotFound) {
+			return nil
+		}
+		return err
+	case pkg.OpNameFind:
+		_, err = t.Find(func(pkg.Model) bool { return true })
+		return err
+	}
+	return fmt.Errorf("unknown op %s", op)
+}
+
+func TestDB_FailedWritesChangeNothing(t *testing.T) {
+	d := newTestDB(t, Rule{Table: "a", Op: pkg.OpNameInsert, Err: ErrInjected})
+	table, err := d.Table("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := &pkgtest.Item{Name: "x"}
+	if err = table.Insert(item); !errors.Is(err, ErrInjected) {
+		t.Fatalf("Insert error = %v, wantErr %v", err, ErrInjected)
+	}
+	if item.ID != 0 {
+		t.Errorf("ID = %v, want 0", item.ID)
+	}
+	d.Reset()
+	if err = table.Insert(item); err != nil || item.ID != 1 {
+		t.Errorf("Insert after Reset = %v, ID %v, want ID 1", err, item.ID)
+	}
+}
+
+func TestDB_Snapshot(t *testing.T) {
+	d := newTestDB(t, Rule{Table: "a", Op: pkg.OpNameScan, Err: ErrInjected})
+	s := d.Snapshot()
+	defer s.Release()
+	r, err := s.Table("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Scan(func(pkg.Model) bool { return true }); !errors.Is(err, ErrInjected) {
+		t.Errorf("Scan error = %v, wantErr %v", err, ErrInjected)
+	}
+	d.Inject(Rule{Op: OpNameTable, Err: ErrInjected})
+	if _, err = s.Table("b"); !errors.Is(err, ErrInjected) {
+		t.Errorf("Table error = %v, wantErr %v", err, ErrInjected)
+	}
+}
+
+func TestDB_Delay(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	d := newTestDB(t, Rule{Table: "a", Op: pkg.OpNameGet, Delay: delay})
+	table, err := d.Table("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err = table.Get(1); !errors.Is(err, pkg.ErrNotFound) {
+		t.Errorf("Get error = %v, wantErr %v", err, pkg.ErrNotFound)
+	}
+	if took := time.Since(start); took < delay {
+		t.Errorf("Get took %v, want at least %v", took, delay)
+	}
+}
+
+// TestConformance checks that a database without rules behaves like the one
+// it wraps.
+func TestConformance(t *testing.T) {
+	pkgtest.RunDB(t, func(t *testing.T) pkg.DB {
+		return Wrap(pkg.NewDB())
+	})
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidJoinKey = fmt.Errorf("join key is not a primary key")
+
+// JoinKind is how Join treats rows of the left table without a match
+type JoinKind int
+
+const (
+	// InnerJoin skips the rows of the left table without a match
+	InnerJoin JoinKind = iota + 1
+	// LeftJoin passes the rows of the left table without a match along with
+	// a nil right row
+	LeftJoin
+)
+
+// JoinOn describes the keys two tables are joined on. Keys must be
+// comparable; a nil key matches nothing.
+type JoinOn struct {
+	// Left returns the key of a row of the left table
+	Left func(Model) interface{}
+	// Right returns the key of a row of the right table. When nil, rows are
+	// joined on the primary key of the right table: Left must return a
+	// PrimaryKey, which is looked up with Get instead of reading the whole
+	// right table.
+	Right func(Model) interface{}
+}
+
+// Join calls f with every row of left, in primary key order, and each row of
+// right whose key matches it, until f returns false. Rows of right matching
+// the same left row are passed in primary key order. Pass the tables of one
+// Snapshot to join rows as they were at one point in time.
+func Join(left, right Reader, kind JoinKind, on JoinOn, f func(left, right Model) bool) error {
+	var index map[interface{}][]Model
+	if on.Right != nil {
+		index = make(map[interface{}][]Model)
+		err := right.Scan(func(m Model) bool {
+			if key := on.Right(m); key != nil {
+				index[key] = append(index[key], m)
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("error scanning %s: %w", right.Name(), err)
+		}
+	}
+	var joinErr error
+	err := left.Scan(func(l Model) bool {
+		var matches []Model
+		switch key := on.Left(l); {
+		case key == nil:
+		case index != nil:
+			matches = index[key]
+		default:
+			id, ok := key.(PrimaryKey)
+			if !ok {
+				joinErr = fmt.Errorf("%w: %T", ErrInvalidJoinKey, key)
+				return false
+			}
+			r, err := right.Get(id)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				joinErr = fmt.Errorf("error getting %s: %w", right.Name(), err)
+				return false
+			}
+			if err == nil {
+				matches = []Model{r}
+			}
+		}
+		if len(matches) == 0 {
+			return kind != LeftJoin || f(l, nil)
+		}
+		for i, r := range matches {
+			if i > 0 {
+				l = clone(l)
+			}
+			if index != nil {
+				// rows of the index are passed for every left row matching them
+				r = clone(r)
+			}
+			if !f(l, r) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error scanning %s: %w", left.Name(), err)
+	}
+	return joinErr
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func newJoinTables(t *testing.T, left, right []string) (Reader, Reader) {
+	t.Helper()
+	d := NewDB(WithShards(2))
+	for name, rows := range map[string][]string{"left": left, "right": right} {
+		if err := d.AddTable(name); err != nil {
+			t.Fatal(err)
+		}
+		table, _ := d.Table(name)
+		for _, data := range rows {
+			if err := table.Insert(&testModel{Data: data}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	s := d.Snapshot()
+	t.Cleanup(s.Release)
+	l, _ := s.Table("left")
+	r, _ := s.Table("right")
+	return l, r
+}
+
+func data(m Model) interface{} {
+	return m.(*testModel).Data
+}
+
+func foreignKey(m Model) interface{} {
+	id, err := strconv.Atoi(m.(*testModel).Data)
+	if err != nil {
+		return nil
+	}
+	return PrimaryKey(id)
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		name  string
+		left  []string
+		right []string
+		kind  JoinKind
+		on    JoinOn
+		want  [][2]PrimaryKey
+	}{
+		{
+			name:  "inner by primary key",
+			left:  []string{"2", "3", "1", "x"},
+			right: []string{"a", "b"},
+			kind:  InnerJoin,
+			on:    JoinOn{Left: foreignKey},
+			want:  [][2]PrimaryKey{{1, 2}, {3, 1}},
+		},
+		{
+			name:  "left by primary key",
+			left:  []string{"2", "3", "1"},
+			right: []string{"a", "b"},
+			kind:  LeftJoin,
+			on:    JoinOn{Left: foreignKey},
+			want:  [][2]PrimaryKey{{1, 2}, {2, 0}, {3, 1}},
+		},
+		{
+			name:  "inner by key",
+			left:  []string{"x", "y", "z"},
+			right: []string{"z", "x", "x"},
+			kind:  InnerJoin,
+			on:    JoinOn{Left: data, Right: data},
+			want:  [][2]PrimaryKey{{1, 2}, {1, 3}, {3, 1}},
+		},
+		{
+			name:  "left by key",
+			left:  []string{"x", "y"},
+			right: []string{"x"},
+			kind:  LeftJoin,
+			on:    JoinOn{Left: data, Right: data},
+			want:  [][2]PrimaryKey{{1, 1}, {2, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, right := newJoinTables(t, tt.left, tt.right)
+			var got [][2]PrimaryKey
+			err := Join(left, right, tt.kind, tt.on, func(l, r Model) bool {
+				pair := [2]PrimaryKey{l.GetID()}
+				if r != nil {
+					pair[1] = r.GetID()
+				}
+				got = append(got, pair)
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoin_Stop(t *testing.T) {
+	left, right := newJoinTables(t, []string{"x", "x"}, []string{"x", "x"})
+	var n int
+	err := Join(left, right, InnerJoin, JoinOn{Left: data, Right: data}, func(l, r Model) bool {
+		n++
+		return n < 3
+	})
+	if err != nil || n != 3 {
+		t.Errorf("called %v times with error %v, want 3 calls", n, err)
+	}
+}
+
+func TestJoin_InvalidKey(t *testing.T) {
+	left, right := newJoinTables(t, []string{"x"}, nil)
+	err := Join(left, right, InnerJoin, JoinOn{Left: data}, func(l, r Model) bool { return true })
+	if !errors.Is(err, ErrInvalidJoinKey) {
+		t.Errorf("error = %v, wantErr %v", err, ErrInvalidJoinKey)
+	}
+}
@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsSink receives measurements of the operations on the tables of a
+// database. Its methods are called from the goroutines doing the
+// operations, so they must be safe for concurrent use and should be cheap.
+type MetricsSink interface {
+	// ObserveOp records an operation on a table, the error it returned, if
+	// any, and how long it took
+	ObserveOp(table, op string, err error, took time.Duration)
+	// ObserveScan records how many rows a Find or Scan read
+	ObserveScan(table, op string, rows int)
+	// SetRows records the number of rows of a table after a write
+	SetRows(table string, rows int)
+}
+
+// Operation names passed to a MetricsSink
+const (
+	OpNameGet            = "get"
+	OpNameFind           = "find"
+	OpNameScan           = "scan"
+	OpNameInsert         = "insert"
+	OpNameUpdate         = "update"
+	OpNameDelete         = "delete"
+	OpNameInsertMany     = "insert_many"
+	OpNameUpdateMany     = "update_many"
+	OpNameUpdateWhere    = "update_where"
+	OpNameDeleteWhere    = "delete_where"
+	OpNameUpsert         = "upsert"
+	OpNameCompareAndSwap = "compare_and_swap"
+)
+
+// errorNames names the errors of the package, most specific first.
+var errorNames = []struct {
+	err  error
+	name string
+}{
+	{ErrNotFound, "not_found"},
+	{ErrAlreadyHasID, "already_has_id"},
+	{ErrKeyChanged, "key_changed"},
+	{ErrNoID, "no_id"},
+	{ErrValidation, "validation"},
+	{ErrReadOnly, "read_only"},
+	{ErrSnapshotReleased, "snapshot_released"},
+	{ErrorNoTable, "no_table"},
+	{ErrorNoTableName, "no_table_name"},
+	{ErrTableExists, "table_exists"},
+	{ErrInvalidJoinKey, "invalid_join_key"},
+	{ErrUnknownKey, "unknown_key"},
+	{ErrInvalidFile, "invalid_file"},
+}
+
+// ErrorName returns a short name for the error of the package err wraps, or
+// "other" if it wraps none of them. A batch error is named after the error
+// of its first rejected row.
+func ErrorName(err error) string {
+	var batch *BatchError
+	if errors.As(err, &batch) && len(batch.Rows) > 0 {
+		err = batch.Rows[0].Err
+	}
+	for _, e := range errorNames {
+		if errors.Is(err, e.err) {
+			return e.name
+		}
+	}
+	return "other"
+}
+
+// WithMetrics sends measurements of every table operation to sink.
+func WithMetrics(sink MetricsSink) Option {
+	return func(d *db) {
+		d.metrics = sink
+	}
+}
+
+// meteredReader measures the reads of a table, or of a table of a
+// snapshot, for a MetricsSink.
+type meteredReader struct {
+	Reader
+	sink MetricsSink
+}
+
+func (r meteredReader) observe(op string, start time.Time, err error) {
+	r.sink.ObserveOp(r.Name(), op, err, time.Since(start))
+}
+
+func (r meteredReader) Get(key PrimaryKey) (Model, error) {
+	start := time.Now()
+	m, err := r.Reader.Get(key)
+	r.observe(OpNameGet, start, err)
+	return m, err
+}
+
+func (r meteredReader) Find(f func(Model) bool) ([]Model, error) {
+	start := time.Now()
+	// sharded tables call f from several goroutines
+	var rows int64
+	ms, err := r.Reader.Find(func(m Model) bool {
+		atomic.AddInt64(&rows, 1)
+		return f(m)
+	})
+	r.observe(OpNameFind, start, err)
+	r.sink.ObserveScan(r.Name(), OpNameFind, int(rows))
+	return ms, err
+}
+
+func (r meteredReader) Scan(f func(Model) bool) error {
+	start := time.Now()
+	var rows int
+	err := r.Reader.Scan(func(m Model) bool {
+		rows++
+		return f(m)
+	})
+	r.observe(OpNameScan, start, err)
+	r.sink.ObserveScan(r.Name(), OpNameScan, rows)
+	return err
+}
+
+// meteredTable measures the operations on a table for a MetricsSink.
+type meteredTable struct {
+	Table
+	sink MetricsSink
+}
+
+func (t *meteredTable) observe(op string, start time.Time, err error) {
+	t.sink.ObserveOp(t.Name(), op, err, time.Since(start))
+}
+
+// observeWrite measures a write and the number of rows it left.
+func (t *meteredTable) observeWrite(op string, start time.Time, err error) {
+	t.observe(op, start, err)
+	var rows int
+	for _, part := range partsOf(t.Table) {
+		rows += part.len()
+	}
+	t.sink.SetRows(t.Name(), rows)
+}
+
+func (t *meteredTable) Get(key PrimaryKey) (Model, error) {
+	return meteredReader{Reader: t.Table, sink: t.sink}.Get(key)
+}
+
+func (t *meteredTable) Find(f func(Model) bool) ([]Model, error) {
+	return meteredReader{Reader: t.Table, sink: t.sink}.Find(f)
+}
+
+func (t *meteredTable) Scan(f func(Model) bool) error {
+	return meteredReader{Reader: t.Table, sink: t.sink}.Scan(f)
+}
+
+func (t *meteredTable) Insert(m Model) error {
+	start := time.Now()
+	err := t.Table.Insert(m)
+	t.observeWrite(OpNameInsert, start, err)
+	return err
+}
+
+func (t *meteredTable) Update(m Model) error {
+	start := time.Now()
+	err := t.Table.Update(m)
+	t.observeWrite(OpNameUpdate, start, err)
+	return err
+}
+
+func (t *meteredTable) Delete(key PrimaryKey) error {
+	start := time.Now()
+	err := t.Table.Delete(key)
+	t.observeWrite(OpNameDelete, start, err)
+	return err
+}
+
+func (t *meteredTable) InsertMany(ms []Model) error {
+	start := time.Now()
+	err := t.Table.InsertMany(ms)
+	t.observeWrite(OpNameInsertMany, start, err)
+	return err
+}
+
+func (t *meteredTable) UpdateMany(ms []Model) error {
+	start := time.Now()
+	err := t.Table.UpdateMany(ms)
+	t.observeWrite(OpNameUpdateMany, start, err)
+	return err
+}
+
+func (t *meteredTable) UpdateWhere(match func(Model) bool, update func(Model) error) (int, error) {
+	start := time.Now()
+	n, err := t.Table.UpdateWhere(match, update)
+	t.observeWrite(OpNameUpdateWhere, start, err)
+	return n, err
+}
+
+func (t *meteredTable) DeleteWhere(f func(Model) bool) (int, error) {
+	start := time.Now()
+	n, err := t.Table.DeleteWhere(f)
+	t.observeWrite(OpNameDeleteWhere, start, err)
+	return n, err
+}
+
+func (t *meteredTable) Upsert(m Model) (bool, error) {
+	start := time.Now()
+	inserted, err := t.Table.Upsert(m)
+	t.observeWrite(OpNameUpsert, start, err)
+	return inserted, err
+}
+
+func (t *meteredTable) CompareAndSwap(key PrimaryKey, expected, new Model) (bool, error) {
+	start := time.Now()
+	swapped, err := t.Table.CompareAndSwap(key, expected, new)
+	t.observeWrite(OpNameCompareAndSwap, start, err)
+	return swapped, err
+}
+
+var (
+	_ Reader = meteredReader{}
+	_ Table  = &meteredTable{}
+)
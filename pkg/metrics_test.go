@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestErrorName(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "sentinel", err: ErrNotFound, want: "not_found"},
+		{name: "wrapped", err: fmt.Errorf("error getting user: %w", ErrNotFound), want: "not_found"},
+		{name: "batch", err: &BatchError{Rows: []*RowError{{Index: 1, Err: ErrAlreadyHasID}}}, want: "already_has_id"},
+		{name: "read only", err: ErrReadOnly, want: "read_only"},
+		{name: "other", err: fmt.Errorf("disk full"), want: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorName(tt.err); got != tt.want {
+				t.Errorf("ErrorName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	sink := NewPrometheus()
+	table := newTestTable(t, WithMetrics(sink), WithShards(2))
+	for i := 0; i < 3; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := table.Insert(&testModel{ID: 1}); err == nil {
+		t.Fatal("inserting a model with an ID succeeded")
+	}
+	if _, err := table.Get(9); err == nil {
+		t.Fatal("getting a missing row succeeded")
+	}
+	if err := table.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Find(func(Model) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := sink.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`db_operations_total{table="users",op="insert"} 4`,
+		`db_operations_total{table="users",op="get"} 1`,
+		`db_errors_total{table="users",op="insert",error="already_has_id"} 1`,
+		`db_errors_total{table="users",op="get",error="not_found"} 1`,
+		`db_operation_duration_seconds_count{table="users",op="delete"} 1`,
+		`db_operation_duration_seconds_bucket{table="users",op="get",le="+Inf"} 1`,
+		`db_scanned_rows_bucket{table="users",op="find",le="1"} 0`,
+		`db_scanned_rows_bucket{table="users",op="find",le="10"} 1`,
+		`db_scanned_rows_sum{table="users",op="find"} 2`,
+		`db_rows{table="users"} 2`,
+		"# TYPE db_operation_duration_seconds histogram",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestPrometheus_Snapshot(t *testing.T) {
+	sink := NewPrometheus()
+	d := NewDB(WithMetrics(sink))
+	if err := d.AddTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	table, err := d.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot := d.Snapshot()
+	defer snapshot.Release()
+	r, err := snapshot.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Scan(func(Model) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Get(1); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := sink.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`db_operations_total{table="users",op="scan"} 1`,
+		`db_operations_total{table="users",op="get"} 1`,
+		`db_scanned_rows_sum{table="users",op="scan"} 3`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got, want := quote("a\"b\\c\nd"), `"a\"b\\c\nd"`; got != want {
+		t.Errorf("quote() = %v, want %v", got, want)
+	}
+}
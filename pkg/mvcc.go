@@ -0,0 +1,294 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+var ErrSnapshotReleased = fmt.Errorf("snapshot released")
+
+// Snapshot is a read-only view of the database as it was when the snapshot
+// was taken. Writes committed afterwards are not visible through it, so reads
+// spanning several tables or calls are consistent with each other, and a
+// long scan, such as an export, does not pick up rows written while it runs.
+// Release must be called once the snapshot is no longer needed, so the
+// versions it keeps alive can be discarded.
+type Snapshot interface {
+	// Seq returns the sequence number of the last change the snapshot sees
+	Seq() uint64
+	// Tables returns the tables that existed when the snapshot was taken
+	Tables() []string
+	// Table returns a table as it was when the snapshot was taken
+	Table(string) (Reader, error)
+	// Release discards the snapshot. Reading from it afterwards fails.
+	Release()
+}
+
+// pendingChange is a change made by the current write along with the
+// version of the row it replaced.
+type pendingChange struct {
+	Change
+	prev *version
+}
+
+// version is a row as it was between the changes numbered from and to. Rows
+// that were spilled are kept as a reference to their overflow record, which
+// stays in the file after the row is rewritten.
+type version struct {
+	model    Model
+	ref      *overflowRef
+	from, to uint64
+}
+
+// previous returns the version of the row stored under key before the
+// current write, if any.
+func (t *table) previous(key PrimaryKey) *version {
+	if t.log == nil {
+		return nil
+	}
+	if model, ok := t.data[key]; ok {
+		return &version{model: model}
+	}
+	if t.overflow.has(key) {
+		ref := t.overflow.index[key]
+		return &version{ref: &ref}
+	}
+	return nil
+}
+
+// keepVersion adds a replaced version of a row to its history, dropping the
+// versions no snapshot at or after oldest can see.
+func (t *table) keepVersion(key PrimaryKey, v version, oldest uint64) {
+	if t.history == nil {
+		t.history = make(map[PrimaryKey][]version)
+	}
+	t.history[key] = append(pruneVersions(t.history[key], oldest), v)
+}
+
+// prune drops the versions no open snapshot can see.
+func (t *table) prune(oldest uint64, open bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !open {
+		t.history = nil
+		return
+	}
+	for key, versions := range t.history {
+		if kept := pruneVersions(versions, oldest); len(kept) > 0 {
+			t.history[key] = kept
+		} else {
+			delete(t.history, key)
+		}
+	}
+}
+
+func pruneVersions(versions []version, oldest uint64) []version {
+	kept := versions[:0]
+	for _, v := range versions {
+		if v.to > oldest {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// visible returns the row stored under key as seen by a snapshot taken after
+// change seq. The caller must hold the lock.
+func (t *table) visible(key PrimaryKey, seq uint64) (Model, bool, error) {
+	if t.has(key) && t.versions[key] <= seq {
+		return t.load(key)
+	}
+	for _, v := range t.history[key] {
+		if v.from > seq || seq >= v.to {
+			continue
+		}
+		if v.ref == nil {
+			return v.model, true, nil
+		}
+		model, err := t.overflow.readRef(*v.ref)
+		if err != nil {
+			return nil, false, err
+		}
+		return model, true, nil
+	}
+	return nil, false, nil
+}
+
+// snapshotKeys returns in ascending order the keys of the current rows and
+// of the rows with a history. The caller must hold the lock.
+func (t *table) snapshotKeys() []PrimaryKey {
+	keys := t.keys()
+	for key := range t.history {
+		if !t.has(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// Snapshot returns a read-only view of the database as it is now.
+func (d *db) Snapshot() Snapshot {
+	// holding the lock keeps tables from being added until the snapshot is
+	// registered, so every row it can see is in one of its tables
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	s := &snapshot{
+		db:     d,
+		tables: make(map[string]partitioned, len(d.tables)),
+		seq:    d.log.openSnapshot(),
+	}
+	for name, t := range d.tables {
+		if p, ok := t.(partitioned); ok {
+			s.tables[name] = p
+		}
+	}
+	return s
+}
+
+type snapshot struct {
+	db       *db
+	tables   map[string]partitioned
+	seq      uint64
+	released int32
+}
+
+func (s *snapshot) Seq() uint64 {
+	return s.seq
+}
+
+func (s *snapshot) Tables() []string {
+	tables := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func (s *snapshot) Table(name string) (Reader, error) {
+	if name == "" {
+		return nil, ErrorNoTableName
+	}
+	t, ok := s.tables[name]
+	if !ok {
+		return nil, ErrorNoTable
+	}
+	var r Reader = &snapshotTable{name: name, table: t, snap: s}
+	if s.db.metrics != nil {
+		r = meteredReader{Reader: r, sink: s.db.metrics}
+	}
+	return r, nil
+}
+
+func (s *snapshot) Release() {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return
+	}
+	oldest, open := s.db.log.releaseSnapshot(s.seq)
+	for _, t := range s.tables {
+		for _, part := range t.parts() {
+			part.prune(oldest, open)
+		}
+	}
+}
+
+func (s *snapshot) isReleased() bool {
+	return atomic.LoadInt32(&s.released) == 1
+}
+
+// snapshotTable reads a table as it was when its snapshot was taken.
+type snapshotTable struct {
+	name  string
+	table partitioned
+	snap  *snapshot
+}
+
+func (t *snapshotTable) Name() string {
+	return t.name
+}
+
+func (t *snapshotTable) Get(key PrimaryKey) (Model, error) {
+	model, ok, err := t.read(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(model), nil
+}
+
+func (t *snapshotTable) Find(f func(Model) bool) ([]Model, error) {
+	if t.snap.isReleased() {
+		return nil, ErrSnapshotReleased
+	}
+	parts := make([][]Model, 0, len(t.table.parts()))
+	for _, part := range t.table.parts() {
+		found, err := t.findPart(part, f)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, found)
+	}
+	return mergeModels(parts), nil
+}
+
+func (t *snapshotTable) findPart(part *table, f func(Model) bool) ([]Model, error) {
+	part.mu.RLock()
+	defer part.mu.RUnlock()
+	var found []Model
+	for _, key := range part.snapshotKeys() {
+		model, ok, err := part.visible(key, t.snap.seq)
+		if err != nil {
+			return nil, err
+		}
+		if ok && f(model) {
+			found = append(found, clone(model))
+		}
+	}
+	return found, nil
+}
+
+func (t *snapshotTable) Scan(f func(Model) bool) error {
+	if t.snap.isReleased() {
+		return ErrSnapshotReleased
+	}
+	parts := make([][]PrimaryKey, 0, len(t.table.parts()))
+	for _, part := range t.table.parts() {
+		part.mu.RLock()
+		parts = append(parts, part.snapshotKeys())
+		part.mu.RUnlock()
+	}
+	for _, key := range mergeKeys(parts) {
+		model, ok, err := t.read(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if !f(clone(model)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (t *snapshotTable) read(key PrimaryKey) (Model, bool, error) {
+	if t.snap.isReleased() {
+		return nil, false, ErrSnapshotReleased
+	}
+	part := t.table.route(key)
+	part.mu.RLock()
+	defer part.mu.RUnlock()
+	return part.visible(key, t.snap.seq)
+}
+
+var (
+	_ Snapshot = &snapshot{}
+	_ Reader   = &snapshotTable{}
+)
@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func snapshotRows(t *testing.T, s Snapshot, name string) map[PrimaryKey]string {
+	t.Helper()
+	table, err := s.Table(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[PrimaryKey]string)
+	var last PrimaryKey
+	if err = table.Scan(func(m Model) bool {
+		if m.GetID() <= last {
+			t.Errorf("key %v after %v, want ascending", m.GetID(), last)
+		}
+		last = m.GetID()
+		rows[m.GetID()] = m.(*testModel).Data
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := table.Find(func(Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != len(rows) {
+		t.Errorf("Find returned %v rows, Scan %v", len(found), len(rows))
+	}
+	return rows
+}
+
+func TestSnapshot(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(dir string) []Option
+	}{
+		{name: "plain", opts: func(string) []Option { return nil }},
+		{name: "sharded", opts: func(string) []Option { return []Option{WithShards(4)} }},
+		{name: "spilled", opts: func(dir string) []Option {
+			return []Option{WithMemoryBudget(MemoryBudget{MaxRows: 2, Dir: dir})}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDB(tt.opts(t.TempDir())...)
+			defer d.Close()
+			if err := d.AddTable("users"); err != nil {
+				t.Fatal(err)
+			}
+			table, _ := d.Table("users")
+			for i := 0; i < 5; i++ {
+				if err := table.Insert(&testModel{Data: "v1"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s := d.Snapshot()
+			if err := table.Update(&testModel{ID: 1, Data: "v2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := table.Update(&testModel{ID: 1, Data: "v3"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := table.Delete(2); err != nil {
+				t.Fatal(err)
+			}
+			if err := table.Insert(&testModel{Data: "new"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := table.InsertMany([]Model{&testModel{Data: "new"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.AddTable("later"); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[PrimaryKey]string{1: "v1", 2: "v1", 3: "v1", 4: "v1", 5: "v1"}
+			got := snapshotRows(t, s, "users")
+			if len(got) != len(want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			for key, data := range want {
+				if got[key] != data {
+					t.Errorf("row %v = %q, want %q", key, got[key], data)
+				}
+			}
+			reader, _ := s.Table("users")
+			if _, err := reader.Get(6); !errors.Is(err, ErrNotFound) {
+				t.Errorf("error = %v, wantErr %v", err, ErrNotFound)
+			}
+			if _, err := s.Table("later"); !errors.Is(err, ErrorNoTable) {
+				t.Errorf("error = %v, wantErr %v", err, ErrorNoTable)
+			}
+
+			latest := d.Snapshot()
+			got = snapshotRows(t, latest, "users")
+			if len(got) != 6 || got[1] != "v3" {
+				t.Errorf("latest snapshot = %v, want current rows", got)
+			}
+			latest.Release()
+
+			s.Release()
+			if _, err := reader.Get(1); !errors.Is(err, ErrSnapshotReleased) {
+				t.Errorf("error = %v, wantErr %v", err, ErrSnapshotReleased)
+			}
+			for _, part := range partsOf(table) {
+				if len(part.history) != 0 {
+					t.Errorf("history = %v, want none after release", part.history)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshot_KeepsHistoryForOpenSnapshots(t *testing.T) {
+	d := NewDB()
+	if err := d.AddTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := d.Table("users")
+	if err := users.Insert(&testModel{Data: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	// no snapshot is open, so nothing is kept
+	if err := users.Update(&testModel{ID: 1, Data: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(users.(*table).history); n != 0 {
+		t.Errorf("history = %v, want none", n)
+	}
+
+	first := d.Snapshot()
+	if err := users.Update(&testModel{ID: 1, Data: "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	second := d.Snapshot()
+	if err := users.Update(&testModel{ID: 1, Data: "v4"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshotRows(t, first, "users")[1]; got != "v2" {
+		t.Errorf("first = %q, want %q", got, "v2")
+	}
+	if got := snapshotRows(t, second, "users")[1]; got != "v3" {
+		t.Errorf("second = %q, want %q", got, "v3")
+	}
+
+	first.Release()
+	if n := len(users.(*table).history[1]); n != 1 {
+		t.Errorf("versions = %v, want %v", n, 1)
+	}
+	if got := snapshotRows(t, second, "users")[1]; got != "v3" {
+		t.Errorf("second = %q, want %q", got, "v3")
+	}
+	second.Release()
+	if n := len(users.(*table).history); n != 0 {
+		t.Errorf("history = %v, want none", n)
+	}
+}
+
+func TestSnapshot_AcrossTables(t *testing.T) {
+	d := NewDB(WithShards(4))
+	for _, name := range []string{"a", "b"} {
+		if err := d.AddTable(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, _ := d.Table("a")
+	b, _ := d.Table("b")
+
+	// rows are inserted two at a time into a and then b, so a consistent
+	// snapshot sees whole batches, with a at most one batch ahead of b
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := a.InsertMany([]Model{&testModel{Data: "a"}, &testModel{Data: "a"}}); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := b.InsertMany([]Model{&testModel{Data: "b"}, &testModel{Data: "b"}}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		s := d.Snapshot()
+		rowsA, rowsB := snapshotRows(t, s, "a"), snapshotRows(t, s, "b")
+		if len(rowsA)%2 != 0 || len(rowsA) < len(rowsB) || len(rowsA) > len(rowsB)+2 {
+			t.Errorf("a has %v rows and b %v, want whole batches", len(rowsA), len(rowsB))
+		}
+		s.Release()
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,38 @@
+package pkg
+
+// Option configures a database created by NewDB
+type Option func(*db)
+
+// WithMemoryBudget limits how many rows the database keeps in memory. Rows
+// that do not fit are written to overflow files and read back when needed.
+func WithMemoryBudget(b MemoryBudget) Option {
+	return func(d *db) {
+		d.budget = newBudget(b)
+	}
+}
+
+// WithEncryption encrypts every file the database persists with the active
+// key of the keyring.
+func WithEncryption(keys *Keyring) Option {
+	return func(d *db) {
+		d.keys = keys
+	}
+}
+
+// WithReplicationBacklog sets how many recent changes the database keeps so
+// that a follower that reconnects can catch up without a full snapshot.
+func WithReplicationBacklog(n int) Option {
+	return func(d *db) {
+		d.log = newChangeLog(n)
+	}
+}
+
+// WithShards partitions every table added to the database across n shards by
+// a hash of the primary key, each with its own lock, so that writes to
+// different rows can proceed in parallel. Find searches the shards in
+// parallel, so its function must be safe for concurrent use.
+func WithShards(n int) Option {
+	return func(d *db) {
+		d.shards = n
+	}
+}
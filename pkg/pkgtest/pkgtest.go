@@ -0,0 +1,592 @@
+// Package pkgtest checks that implementations of pkg.DB and pkg.Table behave
+// like the database of package pkg, so that mocks, persistent backends and
+// remote clients can be validated against the same expectations.
+//
+// A test of an implementation passes a constructor to RunDB or RunTable:
+//
+//	func TestConformance(t *testing.T) {
+//		pkgtest.RunDB(t, func(t *testing.T) pkg.DB {
+//			return mydb.New()
+//		})
+//	}
+package pkgtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"example/pkg"
+)
+
+// Item is the model stored by the suite
+type Item struct {
+	ID    pkg.PrimaryKey `json:"id"`
+	Name  string         `json:"name"`
+	Count int            `json:"count"`
+}
+
+func (i *Item) GetID() pkg.PrimaryKey {
+	return i.ID
+}
+
+func (i *Item) SetID(id pkg.PrimaryKey) {
+	i.ID = id
+}
+
+func init() {
+	pkg.RegisterModel(&Item{})
+}
+
+// RunDB runs the suite against databases returned by newDB, which is called
+// once per test and must return an empty database.
+func RunDB(t *testing.T, newDB func(t *testing.T) pkg.DB) {
+	for _, tt := range dbTests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newDB(t))
+		})
+	}
+	RunTable(t, func(t *testing.T) pkg.Table {
+		d := newDB(t)
+		if err := d.AddTable("items"); err != nil {
+			t.Fatal(err)
+		}
+		table, err := d.Table("items")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.Name() != "items" {
+			t.Fatalf("Name() = %v, want %v", table.Name(), "items")
+		}
+		return table
+	})
+}
+
+// RunTable runs the table suite against tables returned by newTable, which
+// is called once per test and must return an empty table.
+func RunTable(t *testing.T, newTable func(t *testing.T) pkg.Table) {
+	for _, tt := range tableTests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newTable(t))
+		})
+	}
+}
+
+var dbTests = []struct {
+	name string
+	test func(t *testing.T, d pkg.DB)
+}{
+	{"AddTable", testAddTable},
+	{"Table", testTable},
+	{"Snapshot", testSnapshot},
+}
+
+var tableTests = []struct {
+	name string
+	test func(t *testing.T, table pkg.Table)
+}{
+	{"Insert", testInsert},
+	{"Get", testGet},
+	{"Update", testUpdate},
+	{"Delete", testDelete},
+	{"Find", testFind},
+	{"Scan", testScan},
+	{"ReturnsCopies", testReturnsCopies},
+	{"InsertMany", testInsertMany},
+	{"UpdateMany", testUpdateMany},
+	{"UpdateWhere", testUpdateWhere},
+	{"DeleteWhere", testDeleteWhere},
+	{"Upsert", testUpsert},
+	{"CompareAndSwap", testCompareAndSwap},
+	{"ConcurrentInserts", testConcurrentInserts},
+	{"ConcurrentReadsAndWrites", testConcurrentReadsAndWrites},
+}
+
+func testAddTable(t *testing.T, d pkg.DB) {
+	if err := d.AddTable(""); !errors.Is(err, pkg.ErrorNoTableName) {
+		t.Errorf("AddTable(\"\") error = %v, wantErr %v", err, pkg.ErrorNoTableName)
+	}
+	for _, name := range []string{"b", "a"} {
+		if err := d.AddTable(name); err != nil {
+			t.Fatalf("AddTable(%q) error = %v", name, err)
+		}
+	}
+	if err := d.AddTable("a"); !errors.Is(err, pkg.ErrTableExists) {
+		t.Errorf("AddTable(\"a\") again error = %v, wantErr %v", err, pkg.ErrTableExists)
+	}
+	if got := d.Tables(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Tables() = %v, want [a b]", got)
+	}
+}
+
+func testTable(t *testing.T, d pkg.DB) {
+	if _, err := d.Table(""); !errors.Is(err, pkg.ErrorNoTableName) {
+		t.Errorf("Table(\"\") error = %v, wantErr %v", err, pkg.ErrorNoTableName)
+	}
+	if _, err := d.Table("missing"); !errors.Is(err, pkg.ErrorNoTable) {
+		t.Errorf("Table(\"missing\") error = %v, wantErr %v", err, pkg.ErrorNoTable)
+	}
+	if err := d.AddTable("items"); err != nil {
+		t.Fatal(err)
+	}
+	table, err := d.Table("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every call returns the same rows
+	insert(t, table, "a")
+	again, err := d.Table("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = again.Get(1); err != nil {
+		t.Errorf("Get(1) on a second handle error = %v", err)
+	}
+}
+
+func testSnapshot(t *testing.T, d pkg.DB) {
+	if err := d.AddTable("items"); err != nil {
+		t.Fatal(err)
+	}
+	table, err := d.Table("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(t, table, "a", "b")
+	s := d.Snapshot()
+	if err = table.Update(&Item{ID: 1, Name: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = table.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	insert(t, table, "c")
+
+	snapshotTable, err := s.Table("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := snapshotTable.Find(func(pkg.Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, got, "a", "b")
+	s.Release()
+	if _, err = snapshotTable.Get(1); !errors.Is(err, pkg.ErrSnapshotReleased) {
+		t.Errorf("Get after Release error = %v, wantErr %v", err, pkg.ErrSnapshotReleased)
+	}
+}
+
+func testInsert(t *testing.T, table pkg.Table) {
+	for i, name := range []string{"a", "b", "c"} {
+		item := &Item{Name: name}
+		if err := table.Insert(item); err != nil {
+			t.Fatal(err)
+		}
+		// IDs are assigned in sequence starting at 1
+		if want := pkg.PrimaryKey(i + 1); item.ID != want {
+			t.Errorf("ID = %v, want %v", item.ID, want)
+		}
+	}
+	if err := table.Insert(&Item{ID: 7}); !errors.Is(err, pkg.ErrAlreadyHasID) {
+		t.Errorf("Insert with an ID error = %v, wantErr %v", err, pkg.ErrAlreadyHasID)
+	}
+}
+
+func testGet(t *testing.T, table pkg.Table) {
+	insert(t, table, "a")
+	got, err := table.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := got.(*Item); item.ID != 1 || item.Name != "a" {
+		t.Errorf("Get(1) = %+v, want item a", item)
+	}
+	if _, err = table.Get(2); !errors.Is(err, pkg.ErrNotFound) {
+		t.Errorf("Get(2) error = %v, wantErr %v", err, pkg.ErrNotFound)
+	}
+}
+
+func testUpdate(t *testing.T, table pkg.Table) {
+	insert(t, table, "a")
+	if err := table.Update(&Item{ID: 1, Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	assertName(t, table, 1, "b")
+	if err := table.Update(&Item{ID: 2, Name: "c"}); !errors.Is(err, pkg.ErrNotFound) {
+		t.Errorf("Update(2) error = %v, wantErr %v", err, pkg.ErrNotFound)
+	}
+	if _, err := table.Get(2); !errors.Is(err, pkg.ErrNotFound) {
+		t.Errorf("Update of a missing row inserted it")
+	}
+}
+
+func testDelete(t *testing.T, table pkg.Table) {
+	insert(t, table, "a", "b")
+	if err := table.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Get(2); !errors.Is(err, pkg.ErrNotFound) {
+		t.Errorf("Get deleted row error = %v, wantErr %v", err, pkg.ErrNotFound)
+	}
+	if err := table.Delete(2); !errors.Is(err, pkg.ErrNotFound) {
+		t.Errorf("Delete(2) again error = %v, wantErr %v", err, pkg.ErrNotFound)
+	}
+	// IDs of deleted rows are not reused
+	item := &Item{Name: "c"}
+	if err := table.Insert(item); err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != 3 {
+		t.Errorf("ID after delete = %v, want %v", item.ID, 3)
+	}
+}
+
+func testFind(t *testing.T, table pkg.Table) {
+	insert(t, table, "e", "d", "c", "b", "a")
+	if err := table.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	all, err := table.Find(func(pkg.Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rows are returned in primary key order
+	assertNames(t, all, "e", "d", "b", "a")
+	some, err := table.Find(func(m pkg.Model) bool { return m.(*Item).Name < "c" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, some, "b", "a")
+	none, err := table.Find(func(pkg.Model) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, none)
+}
+
+func testScan(t *testing.T, table pkg.Table) {
+	insert(t, table, "c", "b", "a")
+	var got []pkg.Model
+	if err := table.Scan(func(m pkg.Model) bool {
+		got = append(got, m)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, got, "c", "b", "a")
+	got = nil
+	if err := table.Scan(func(m pkg.Model) bool {
+		got = append(got, m)
+		return len(got) < 2
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, got, "c", "b")
+}
+
+func testReturnsCopies(t *testing.T, table pkg.Table) {
+	item := &Item{Name: "a"}
+	if err := table.Insert(item); err != nil {
+		t.Fatal(err)
+	}
+	item.Name = "changed after insert"
+	assertName(t, table, 1, "a")
+
+	got, err := table.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.(*Item).Name = "changed after get"
+	assertName(t, table, 1, "a")
+
+	found, err := table.Find(func(pkg.Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	found[0].(*Item).Name = "changed after find"
+	assertName(t, table, 1, "a")
+}
+
+func testInsertMany(t *testing.T, table pkg.Table) {
+	items := []pkg.Model{&Item{Name: "a"}, &Item{Name: "b"}}
+	if err := table.InsertMany(items); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range items {
+		if want := pkg.PrimaryKey(i + 1); m.GetID() != want {
+			t.Errorf("ID = %v, want %v", m.GetID(), want)
+		}
+	}
+	err := table.InsertMany([]pkg.Model{&Item{Name: "c"}, &Item{ID: 9, Name: "d"}})
+	assertBatchError(t, err, 1, pkg.ErrAlreadyHasID)
+	assertCount(t, table, 2)
+}
+
+func testUpdateMany(t *testing.T, table pkg.Table) {
+	insert(t, table, "a", "b")
+	if err := table.UpdateMany([]pkg.Model{&Item{ID: 1, Name: "c"}, &Item{ID: 2, Name: "d"}}); err != nil {
+		t.Fatal(err)
+	}
+	assertName(t, table, 2, "d")
+	err := table.UpdateMany([]pkg.Model{&Item{ID: 1, Name: "e"}, &Item{ID: 5, Name: "f"}})
+	assertBatchError(t, err, 1, pkg.ErrNotFound)
+	assertName(t, table, 1, "c")
+}
+
+func testUpdateWhere(t *testing.T, table pkg.Table) {
+	insert(t, table, "a", "b", "c")
+	n, err := table.UpdateWhere(func(m pkg.Model) bool {
+		return m.(*Item).Name != "b"
+	}, func(m pkg.Model) error {
+		m.(*Item).Count++
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("UpdateWhere() = %v, %v, want 2 rows", n, err)
+	}
+	assertCounts(t, table, 1, 0, 1)
+
+	failed := fmt.Errorf("rejected")
+	_, err = table.UpdateWhere(func(pkg.Model) bool { return true }, func(m pkg.Model) error {
+		if m.GetID() == 3 {
+			return failed
+		}
+		m.(*Item).Count++
+		return nil
+	})
+	assertBatchError(t, err, 2, failed)
+	assertCounts(t, table, 1, 0, 1)
+
+	_, err = table.UpdateWhere(func(pkg.Model) bool { return true }, func(m pkg.Model) error {
+		m.SetID(m.GetID() + 10)
+		return nil
+	})
+	if !errors.Is(err, pkg.ErrKeyChanged) {
+		t.Errorf("UpdateWhere changing IDs error = %v, wantErr %v", err, pkg.ErrKeyChanged)
+	}
+}
+
+func testDeleteWhere(t *testing.T, table pkg.Table) {
+	insert(t, table, "a", "b", "a")
+	n, err := table.DeleteWhere(func(m pkg.Model) bool { return m.(*Item).Name == "a" })
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteWhere() = %v, %v, want 2 rows", n, err)
+	}
+	assertCount(t, table, 1)
+}
+
+func testUpsert(t *testing.T, table pkg.Table) {
+	if _, err := table.Upsert(&Item{Name: "a"}); !errors.Is(err, pkg.ErrNoID) {
+		t.Errorf("Upsert without an ID error = %v, wantErr %v", err, pkg.ErrNoID)
+	}
+	inserted, err := table.Upsert(&Item{ID: 10, Name: "a"})
+	if err != nil || !inserted {
+		t.Errorf("Upsert(10) = %v, %v, want inserted", inserted, err)
+	}
+	inserted, err = table.Upsert(&Item{ID: 10, Name: "b"})
+	if err != nil || inserted {
+		t.Errorf("Upsert(10) again = %v, %v, want replaced", inserted, err)
+	}
+	assertName(t, table, 10, "b")
+	// IDs assigned later do not collide with upserted ones
+	item := &Item{Name: "c"}
+	if err = table.Insert(item); err != nil {
+		t.Fatal(err)
+	}
+	if item.ID <= 10 {
+		t.Errorf("ID after upsert = %v, want more than 10", item.ID)
+	}
+}
+
+func testCompareAndSwap(t *testing.T, table pkg.Table) {
+	insert(t, table, "a")
+	if _, err := table.CompareAndSwap(2, &Item{ID: 2}, &Item{ID: 2}); !errors.Is(err, pkg.ErrNotFound) {
+		t.Errorf("CompareAndSwap(2) error = %v, wantErr %v", err, pkg.ErrNotFound)
+	}
+	swapped, err := table.CompareAndSwap(1, &Item{ID: 1, Name: "stale"}, &Item{ID: 1, Name: "b"})
+	if err != nil || swapped {
+		t.Errorf("CompareAndSwap with a stale model = %v, %v, want not swapped", swapped, err)
+	}
+	swapped, err = table.CompareAndSwap(1, &Item{ID: 1, Name: "a"}, &Item{ID: 1, Name: "b"})
+	if err != nil || !swapped {
+		t.Errorf("CompareAndSwap = %v, %v, want swapped", swapped, err)
+	}
+	assertName(t, table, 1, "b")
+	if _, err = table.CompareAndSwap(1, &Item{ID: 1, Name: "b"}, &Item{ID: 2}); !errors.Is(err, pkg.ErrKeyChanged) {
+		t.Errorf("CompareAndSwap changing the ID error = %v, wantErr %v", err, pkg.ErrKeyChanged)
+	}
+}
+
+// testConcurrentInserts checks that concurrent inserts are all stored under
+// distinct IDs.
+func testConcurrentInserts(t *testing.T, table pkg.Table) {
+	const writers, inserts = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < inserts; i++ {
+				if err := table.Insert(&Item{Name: "a"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	all, err := table.Find(func(pkg.Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != writers*inserts {
+		t.Fatalf("len = %v, want %v", len(all), writers*inserts)
+	}
+	ids := make([]int, len(all))
+	for i, m := range all {
+		ids[i] = int(m.GetID())
+	}
+	if !sort.IntsAreSorted(ids) {
+		t.Errorf("Find returned IDs out of order")
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			t.Errorf("ID %v assigned twice", ids[i])
+		}
+	}
+}
+
+// testConcurrentReadsAndWrites checks that reads running alongside writes
+// see whole rows and that concurrent compare-and-swaps lose no update.
+func testConcurrentReadsAndWrites(t *testing.T, table pkg.Table) {
+	insert(t, table, "a", "b", "c")
+	const writers, increments = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				stored, err := table.Get(1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				next := *stored.(*Item)
+				next.Count++
+				swapped, err := table.CompareAndSwap(1, stored, &next)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if swapped {
+					i++
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				if _, err := table.Find(func(m pkg.Model) bool { return m.(*Item).Count >= 0 }); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := table.Scan(func(pkg.Model) bool { return true }); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := table.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := got.(*Item).Count; count != writers*increments {
+		t.Errorf("Count = %v, want %v", count, writers*increments)
+	}
+}
+
+func insert(t *testing.T, table pkg.Table, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := table.Insert(&Item{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertName(t *testing.T, table pkg.Table, key pkg.PrimaryKey, want string) {
+	t.Helper()
+	got, err := table.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := got.(*Item).Name; name != want {
+		t.Errorf("Name of row %v = %q, want %q", key, name, want)
+	}
+}
+
+func assertNames(t *testing.T, models []pkg.Model, want ...string) {
+	t.Helper()
+	got := make([]string, len(models))
+	for i, m := range models {
+		got[i] = m.(*Item).Name
+		if i > 0 && m.GetID() <= models[i-1].GetID() {
+			t.Errorf("ID %v after %v, want ascending IDs", m.GetID(), models[i-1].GetID())
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+}
+
+func assertCount(t *testing.T, table pkg.Table, want int) {
+	t.Helper()
+	all, err := table.Find(func(pkg.Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != want {
+		t.Errorf("len = %v, want %v", len(all), want)
+	}
+}
+
+func assertCounts(t *testing.T, table pkg.Table, want ...int) {
+	t.Helper()
+	all, err := table.Find(func(pkg.Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]int, len(all))
+	for i, m := range all {
+		got[i] = m.(*Item).Count
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("counts = %v, want %v", got, want)
+	}
+}
+
+// assertBatchError checks that err rejects the row at index with want.
+func assertBatchError(t *testing.T, err error, index int, want error) {
+	t.Helper()
+	var batch *pkg.BatchError
+	if !errors.As(err, &batch) {
+		t.Fatalf("error = %v, want a *pkg.BatchError", err)
+	}
+	if !errors.Is(err, want) {
+		t.Errorf("error = %v, wantErr %v", err, want)
+	}
+	for _, row := range batch.Rows {
+		if row.Index == index {
+			return
+		}
+	}
+	t.Errorf("rejected rows = %v, want row %v", batch.Rows, index)
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// latencyBuckets are the upper bounds, in seconds, of the operation
+	// latency histograms
+	latencyBuckets = []float64{0.00001, 0.0001, 0.001, 0.01, 0.1, 1, 10}
+	// scanBuckets are the upper bounds of the scanned rows histograms
+	scanBuckets = []float64{1, 10, 100, 1000, 10000, 100000, 1000000}
+)
+
+// Prometheus is a MetricsSink that aggregates measurements and serves them
+// in the Prometheus text exposition format.
+type Prometheus struct {
+	mu        sync.Mutex
+	ops       map[opLabels]uint64
+	errors    map[errorLabels]uint64
+	latencies map[opLabels]*histogram
+	scans     map[opLabels]*histogram
+	rows      map[string]int
+}
+
+type opLabels struct {
+	table, op string
+}
+
+type errorLabels struct {
+	table, op, err string
+}
+
+// NewPrometheus returns an empty Prometheus sink
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		ops:       make(map[opLabels]uint64),
+		errors:    make(map[errorLabels]uint64),
+		latencies: make(map[opLabels]*histogram),
+		scans:     make(map[opLabels]*histogram),
+		rows:      make(map[string]int),
+	}
+}
+
+func (p *Prometheus) ObserveOp(table, op string, err error, took time.Duration) {
+	labels := opLabels{table, op}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ops[labels]++
+	if err != nil {
+		p.errors[errorLabels{table, op, ErrorName(err)}]++
+	}
+	h, ok := p.latencies[labels]
+	if !ok {
+		h = newHistogram(latencyBuckets)
+		p.latencies[labels] = h
+	}
+	h.observe(took.Seconds())
+}
+
+func (p *Prometheus) ObserveScan(table, op string, rows int) {
+	labels := opLabels{table, op}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.scans[labels]
+	if !ok {
+		h = newHistogram(scanBuckets)
+		p.scans[labels] = h
+	}
+	h.observe(float64(rows))
+}
+
+func (p *Prometheus) SetRows(table string, rows int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rows[table] = rows
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cw := &countingWriter{w: w}
+	b := bufio.NewWriter(cw)
+
+	writeMetricHeader(b, "db_operations_total", "counter", "Operations on tables.")
+	ops := make([]opLabels, 0, len(p.ops))
+	for l := range p.ops {
+		ops = append(ops, l)
+	}
+	for _, l := range sortOpLabels(ops) {
+		fmt.Fprintf(b, "db_operations_total{table=%s,op=%s} %d\n", quote(l.table), quote(l.op), p.ops[l])
+	}
+
+	writeMetricHeader(b, "db_errors_total", "counter", "Operations on tables that returned an error, by error.")
+	errs := make([]errorLabels, 0, len(p.errors))
+	for l := range p.errors {
+		errs = append(errs, l)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		x, y := errs[i], errs[j]
+		if x.table != y.table {
+			return x.table < y.table
+		}
+		if x.op != y.op {
+			return x.op < y.op
+		}
+		return x.err < y.err
+	})
+	for _, l := range errs {
+		fmt.Fprintf(b, "db_errors_total{table=%s,op=%s,error=%s} %d\n", quote(l.table), quote(l.op), quote(l.err), p.errors[l])
+	}
+
+	writeMetricHeader(b, "db_operation_duration_seconds", "histogram", "Latency of operations on tables.")
+	writeHistograms(b, "db_operation_duration_seconds", p.latencies)
+
+	writeMetricHeader(b, "db_scanned_rows", "histogram", "Rows read by Find and Scan.")
+	writeHistograms(b, "db_scanned_rows", p.scans)
+
+	writeMetricHeader(b, "db_rows", "gauge", "Rows stored in tables.")
+	tables := make([]string, 0, len(p.rows))
+	for table := range p.rows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Fprintf(b, "db_rows{table=%s} %d\n", quote(table), p.rows[table])
+	}
+
+	err := b.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistograms(w io.Writer, name string, histograms map[opLabels]*histogram) {
+	labels := make([]opLabels, 0, len(histograms))
+	for l := range histograms {
+		labels = append(labels, l)
+	}
+	for _, l := range sortOpLabels(labels) {
+		histograms[l].write(w, name, l)
+	}
+}
+
+// sortOpLabels sorts labels by table and operation.
+func sortOpLabels(labels []opLabels) []opLabels {
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].table != labels[j].table {
+			return labels[i].table < labels[j].table
+		}
+		return labels[i].op < labels[j].op
+	})
+	return labels
+}
+
+// quote returns a label value quoted and escaped for the text format.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+type histogram struct {
+	bounds []float64
+	// counts holds the observations per bucket, not cumulated
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	if i := sort.SearchFloat64s(h.bounds, v); i < len(h.bounds) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string, l opLabels) {
+	labels := fmt.Sprintf("table=%s,op=%s", quote(l.table), quote(l.op))
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+var (
+	_ MetricsSink  = &Prometheus{}
+	_ http.Handler = &Prometheus{}
+)
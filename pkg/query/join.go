@@ -0,0 +1,260 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"example/pkg"
+)
+
+// source is a table named in FROM or JOIN.
+type source struct {
+	table string
+	// alias qualifies the columns of the table, its name unless given
+	alias string
+}
+
+type join struct {
+	source
+	left bool
+	// on holds the two columns compared by ON
+	on [2]string
+}
+
+// input is a table read by a statement.
+type input struct {
+	source
+	reader pkg.Reader
+	// fields are the columns of the table in declaration order, nil if the
+	// table is empty and they are not known
+	fields []string
+	// joined is set when the statement has a join, qualifying record keys
+	joined bool
+}
+
+// key returns the record key of a column of the table.
+func (in *input) key(field string) string {
+	if in.joined {
+		return in.alias + "." + field
+	}
+	return field
+}
+
+// qualify returns the record of a row of the table.
+func (in *input) qualify(r row) record {
+	if !in.joined {
+		return r.values
+	}
+	rec := make(record, len(r.values))
+	for field, v := range r.values {
+		rec[in.key(field)] = v
+	}
+	return rec
+}
+
+// open returns the tables the statement reads, with their columns taken from
+// their first row.
+func (s *Statement) open(snapshot pkg.Snapshot) ([]*input, error) {
+	sources := []source{s.from}
+	if s.join != nil {
+		if s.join.alias == s.from.alias {
+			return nil, fmt.Errorf("%w: %s is used for both tables", ErrInvalidQuery, s.from.alias)
+		}
+		sources = append(sources, s.join.source)
+	}
+	inputs := make([]*input, len(sources))
+	for i, src := range sources {
+		reader, err := snapshot.Table(src.table)
+		if err != nil {
+			return nil, fmt.Errorf("error getting table: %w", err)
+		}
+		in := &input{source: src, reader: reader, joined: s.join != nil}
+		var decodeErr error
+		err = reader.Scan(func(m pkg.Model) bool {
+			var r row
+			r, decodeErr = decodeRow(m)
+			in.fields = r.fields
+			return false
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error scanning %s: %w", src.table, err)
+		}
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		inputs[i] = in
+	}
+	return inputs, nil
+}
+
+// resolveColumn returns the record key of a column as written in the
+// statement.
+func resolveColumn(inputs []*input, name string) (string, error) {
+	if prefix, field, ok := strings.Cut(name, "."); ok {
+		for _, in := range inputs {
+			if in.alias != prefix {
+				continue
+			}
+			if in.fields != nil && !contains(in.fields, field) {
+				break
+			}
+			return in.key(field), nil
+		}
+		return "", fmt.Errorf("%w: %s", ErrUnknownColumn, name)
+	}
+	var found, unknown []*input
+	for _, in := range inputs {
+		if in.fields == nil {
+			unknown = append(unknown, in)
+		} else if contains(in.fields, name) {
+			found = append(found, in)
+		}
+	}
+	if len(found) == 0 && len(unknown) == 1 {
+		// an empty table may have the column
+		found = unknown
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", ErrUnknownColumn, name)
+	case 1:
+		return found[0].key(name), nil
+	}
+	return "", fmt.Errorf("%w: column %s is ambiguous", ErrInvalidQuery, name)
+}
+
+// resolve returns a copy of the statement reading record keys instead of the
+// columns as written.
+func (s *Statement) resolve(inputs []*input) (*Statement, error) {
+	resolve := func(name string) (string, error) {
+		return resolveColumn(inputs, name)
+	}
+	r := *s
+	var err error
+	r.columns = make([]column, len(s.columns))
+	for i, c := range s.columns {
+		if c.field != "" {
+			if c.field, err = resolve(c.field); err != nil {
+				return nil, err
+			}
+		}
+		r.columns[i] = c
+	}
+	if s.join != nil {
+		j := *s.join
+		for i := range j.on {
+			if j.on[i], err = resolve(j.on[i]); err != nil {
+				return nil, err
+			}
+		}
+		r.join = &j
+	}
+	if s.where != nil {
+		if r.where, err = s.where.rename(resolve); err != nil {
+			return nil, err
+		}
+	}
+	r.groupBy = make([]string, len(s.groupBy))
+	for i, field := range s.groupBy {
+		if r.groupBy[i], err = resolve(field); err != nil {
+			return nil, err
+		}
+	}
+	r.orderBy = make([]order, len(s.orderBy))
+	for i, o := range s.orderBy {
+		// ordering by a column of the result needs no resolving
+		if !o.count && !s.hasColumn(o.field) {
+			if o.field, err = resolve(o.field); err != nil {
+				return nil, err
+			}
+		}
+		r.orderBy[i] = o
+	}
+	return &r, nil
+}
+
+// joinRows passes the joined rows of the two inputs to keep until it returns
+// false.
+func (s *Statement) joinRows(inputs []*input, keep func(record) bool) error {
+	left, right := inputs[0], inputs[1]
+	leftKey, rightKey := s.join.on[0], s.join.on[1]
+	if strings.HasPrefix(leftKey, right.alias+".") {
+		leftKey, rightKey = rightKey, leftKey
+	}
+	if !strings.HasPrefix(leftKey, left.alias+".") || !strings.HasPrefix(rightKey, right.alias+".") {
+		return fmt.Errorf("%w: ON must compare a column of each table", ErrInvalidQuery)
+	}
+	byID := rightKey == right.key("id")
+
+	var current record
+	var decodeErr error
+	decode := func(in *input, m pkg.Model) record {
+		r, err := decodeRow(m)
+		if err != nil {
+			decodeErr = err
+			return nil
+		}
+		return in.qualify(r)
+	}
+	on := pkg.JoinOn{
+		Left: func(m pkg.Model) interface{} {
+			if current = decode(left, m); current == nil {
+				return nil
+			}
+			return joinKey(current[leftKey], byID)
+		},
+	}
+	if !byID {
+		on.Right = func(m pkg.Model) interface{} {
+			rec := decode(right, m)
+			if rec == nil {
+				return nil
+			}
+			return joinKey(rec[rightKey], false)
+		}
+	}
+	kind := pkg.InnerJoin
+	if s.join.left {
+		kind = pkg.LeftJoin
+	}
+	err := pkg.Join(left.reader, right.reader, kind, on, func(_, r pkg.Model) bool {
+		if decodeErr != nil {
+			return false
+		}
+		rec := make(record, len(current))
+		for key, v := range current {
+			rec[key] = v
+		}
+		if r != nil {
+			for key, v := range decode(right, r) {
+				rec[key] = v
+			}
+		}
+		return decodeErr == nil && keep(rec)
+	})
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+// joinKey returns the key a value is joined on, a primary key when byID is
+// set. Values that cannot match, such as NULL, return nil.
+func joinKey(v interface{}, byID bool) interface{} {
+	n, isNumber := number(v)
+	switch {
+	case byID:
+		if !isNumber || n != math.Trunc(n) {
+			return nil
+		}
+		return pkg.PrimaryKey(n)
+	case isNumber:
+		return n
+	}
+	switch v.(type) {
+	case string, bool:
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,443 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenKeyword
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "AND": true, "OR": true,
+	"NOT": true, "GROUP": true, "BY": true, "ORDER": true, "ASC": true,
+	"DESC": true, "LIMIT": true, "COUNT": true, "AS": true, "TRUE": true,
+	"FALSE": true, "NULL": true, "JOIN": true, "INNER": true, "LEFT": true,
+	"OUTER": true, "ON": true,
+}
+
+// lex splits a statement into tokens. Keywords are returned upper case and
+// qualified names such as u.id as a single identifier.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isLetter(c):
+			start := i
+			for i < len(s) && (isLetter(s[i]) || isDigit(s[i]) ||
+				(s[i] == '.' && i+1 < len(s) && isLetter(s[i+1]))) {
+				i++
+			}
+			word := s[start:i]
+			if keywords[strings.ToUpper(word)] {
+				tokens = append(tokens, token{tokenKeyword, strings.ToUpper(word), start})
+			} else {
+				tokens = append(tokens, token{tokenIdent, word, start})
+			}
+		case isDigit(c) || (c == '-' && i+1 < len(s) && isDigit(s[i+1])):
+			start := i
+			i++
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, s[start:i], start})
+		case c == '\'':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, fmt.Errorf("%w at %d: unterminated string", ErrSyntax, start)
+				}
+				if s[i] == '\'' {
+					// a doubled quote stands for one quote
+					if i+1 < len(s) && s[i+1] == '\'' {
+						b.WriteByte('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			tokens = append(tokens, token{tokenString, b.String(), start})
+		case strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">=") ||
+			strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<>"):
+			tokens = append(tokens, token{tokenSymbol, s[i : i+2], i})
+			i += 2
+		case strings.IndexByte("=<>(),*;", c) >= 0:
+			tokens = append(tokens, token{tokenSymbol, string(c), i})
+			i++
+		default:
+			return nil, fmt.Errorf("%w at %d: unexpected %q", ErrSyntax, i, c)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+// accept consumes the next token if it is the given keyword or symbol.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenKeyword || t.kind == tokenSymbol) && t.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %s", text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokenIdent {
+		return "", p.errorf("expected a column name")
+	}
+	p.next++
+	return t.text, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := t.text
+	if t.kind == tokenEOF {
+		found = "end of statement"
+	}
+	return fmt.Errorf("%w at %d: %s, found %q", ErrSyntax, t.pos, fmt.Sprintf(format, args...), found)
+}
+
+// Parse parses a SELECT statement
+func Parse(statement string) (*Statement, error) {
+	tokens, err := lex(statement)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	s, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("expected end of statement")
+	}
+	return s, nil
+}
+
+func (p *parser) statement() (*Statement, error) {
+	s := &Statement{limit: -1}
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.accept("*") {
+		s.all = true
+	} else {
+		for {
+			c, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			s.columns = append(s.columns, c)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.from, err = p.source(); err != nil {
+		return nil, err
+	}
+	if s.join, err = p.join(); err != nil {
+		return nil, err
+	}
+	if p.accept("WHERE") {
+		if s.where, err = p.or(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("GROUP") {
+		if err = p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			field, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			s.groupBy = append(s.groupBy, field)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("ORDER") {
+		if err = p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			var o order
+			if p.accept("COUNT") {
+				// ORDER BY COUNT(...) sorts by the count column
+				c, err := p.count()
+				if err != nil {
+					return nil, err
+				}
+				o.field, o.count = c.name, true
+			} else if o.field, err = p.ident(); err != nil {
+				return nil, err
+			}
+			if p.accept("DESC") {
+				o.desc = true
+			} else {
+				p.accept("ASC")
+			}
+			s.orderBy = append(s.orderBy, o)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		t := p.peek()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil || n < 0 {
+			return nil, p.errorf("expected a row count")
+		}
+		p.next++
+		s.limit = n
+	}
+	return s, nil
+}
+
+func (p *parser) source() (source, error) {
+	t := p.peek()
+	if t.kind != tokenIdent || strings.Contains(t.text, ".") {
+		return source{}, p.errorf("expected a table name")
+	}
+	p.next++
+	src := source{table: t.text, alias: t.text}
+	explicit := p.accept("AS")
+	if t := p.peek(); t.kind == tokenIdent && !strings.Contains(t.text, ".") {
+		src.alias = t.text
+		p.next++
+	} else if explicit {
+		return src, p.errorf("expected an alias")
+	}
+	return src, nil
+}
+
+// join parses an optional JOIN clause.
+func (p *parser) join() (*join, error) {
+	j := &join{}
+	switch {
+	case p.accept("JOIN"):
+	case p.accept("INNER"):
+		if err := p.expect("JOIN"); err != nil {
+			return nil, err
+		}
+	case p.accept("LEFT"):
+		j.left = true
+		p.accept("OUTER")
+		if err := p.expect("JOIN"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	var err error
+	if j.source, err = p.source(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("ON"); err != nil {
+		return nil, err
+	}
+	if j.on[0], err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("="); err != nil {
+		return nil, err
+	}
+	if j.on[1], err = p.ident(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (p *parser) column() (column, error) {
+	var c column
+	var err error
+	if p.accept("COUNT") {
+		if c, err = p.count(); err != nil {
+			return c, err
+		}
+	} else {
+		if c.field, err = p.ident(); err != nil {
+			return c, err
+		}
+		c.name = c.field
+	}
+	if p.accept("AS") {
+		if c.name, err = p.ident(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// count parses the arguments of COUNT, whose keyword was consumed.
+func (p *parser) count() (column, error) {
+	c := column{count: true, name: "count"}
+	if err := p.expect("("); err != nil {
+		return c, err
+	}
+	if !p.accept("*") {
+		field, err := p.ident()
+		if err != nil {
+			return c, err
+		}
+		c.field = field
+	}
+	return c, p.expect(")")
+}
+
+func (p *parser) or() (expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{or: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("NOT") {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &negation{e}, nil
+	}
+	if p.accept("(") {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch t.text {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		if t.kind != tokenSymbol {
+			break
+		}
+		p.next++
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		op := t.text
+		if op == "<>" {
+			op = "!="
+		}
+		return &comparison{op: op, left: left, right: right}, nil
+	}
+	return nil, p.errorf("expected a comparison")
+}
+
+func (p *parser) operand() (operand, error) {
+	t := p.peek()
+	var o operand
+	switch {
+	case t.kind == tokenIdent:
+		o = operand{field: t.text}
+	case t.kind == tokenString:
+		o = operand{value: t.text, literal: true}
+	case t.kind == tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return o, p.errorf("invalid number")
+		}
+		o = operand{value: f, literal: true}
+	case t.kind == tokenKeyword && t.text == "TRUE":
+		o = operand{value: true, literal: true}
+	case t.kind == tokenKeyword && t.text == "FALSE":
+		o = operand{value: false, literal: true}
+	case t.kind == tokenKeyword && t.text == "NULL":
+		o = operand{literal: true}
+	default:
+		return o, p.errorf("expected a column name or value")
+	}
+	p.next++
+	return o, nil
+}
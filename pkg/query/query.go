@@ -0,0 +1,556 @@
+// Package query runs a subset of SQL against the tables of a pkg.DB.
+//
+// Statements have the form
+//
+//	SELECT * | column [AS name], COUNT(*) | COUNT(column) [AS name], ...
+//	FROM table [[AS] alias]
+//	[[INNER | LEFT [OUTER]] JOIN table [[AS] alias] ON column = column]
+//	[WHERE condition]
+//	[GROUP BY column, ...]
+//	[ORDER BY column [ASC | DESC], ...]
+//	[LIMIT n]
+//
+// Columns are the JSON field names of the models stored in the table. In a
+// join, columns are qualified with their table name or alias as in u.id
+// unless only one of the tables has them, and SELECT * returns every column
+// qualified. Joins on the id column of the right table look its rows up by
+// primary key; other joins read the whole right table once.
+// Conditions compare columns and values with =, !=, <>, <, <=, > and >= and
+// combine comparisons with AND, OR, NOT and parentheses. Values are numbers,
+// 'quoted strings', TRUE, FALSE and NULL. Comparing values of different types
+// is false, except for != which is true; NULL is only equal to NULL.
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"example/pkg"
+)
+
+var (
+	ErrSyntax        = fmt.Errorf("syntax error")
+	ErrUnknownColumn = fmt.Errorf("unknown column")
+	ErrInvalidQuery  = fmt.Errorf("invalid query")
+)
+
+// Statement is a parsed SELECT statement
+type Statement struct {
+	all     bool
+	columns []column
+	from    source
+	join    *join
+	where   expr
+	groupBy []string
+	orderBy []order
+	// limit is the maximum number of rows returned, -1 for no limit
+	limit int
+}
+
+type column struct {
+	field string
+	name  string
+	count bool
+}
+
+type order struct {
+	field string
+	desc  bool
+	count bool
+}
+
+// Result holds the rows returned by a statement. Numbers are returned as
+// json.Number so they keep the exact value stored in the model.
+type Result struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// Run parses and runs a statement against db
+func Run(db pkg.DB, statement string) (*Result, error) {
+	s, err := Parse(statement)
+	if err != nil {
+		return nil, err
+	}
+	return s.Run(db)
+}
+
+// Run runs the statement against a snapshot of db, so it sees the tables at
+// one point in time without blocking writers.
+func (s *Statement) Run(db pkg.DB) (*Result, error) {
+	snapshot := db.Snapshot()
+	defer snapshot.Release()
+	inputs, err := s.open(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := s.resolve(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.run(inputs)
+}
+
+func (s *Statement) run(inputs []*input) (*Result, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	records, err := s.scan(inputs)
+	if err != nil {
+		return nil, err
+	}
+	if s.grouped() {
+		records = s.group(records)
+	}
+	s.sort(records)
+	if s.limit >= 0 && len(records) > s.limit {
+		records = records[:s.limit]
+	}
+	return s.project(records, inputs), nil
+}
+
+// grouped reports whether the statement returns one row per group.
+func (s *Statement) grouped() bool {
+	if len(s.groupBy) > 0 {
+		return true
+	}
+	for _, c := range s.columns {
+		if c.count {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that the columns and ordering of a statement fit its grouping.
+func (s *Statement) validate() error {
+	if !s.grouped() {
+		for _, o := range s.orderBy {
+			if o.count {
+				return fmt.Errorf("%w: ORDER BY COUNT needs COUNT in the column list", ErrInvalidQuery)
+			}
+		}
+		return nil
+	}
+	if s.all {
+		return fmt.Errorf("%w: SELECT * cannot be grouped", ErrInvalidQuery)
+	}
+	for _, c := range s.columns {
+		if !c.count && !contains(s.groupBy, c.field) {
+			return fmt.Errorf("%w: column %s must appear in GROUP BY", ErrInvalidQuery, c.field)
+		}
+	}
+	for _, o := range s.orderBy {
+		if !o.count && !contains(s.groupBy, o.field) && !s.hasColumn(o.field) {
+			return fmt.Errorf("%w: cannot order groups by %s", ErrInvalidQuery, o.field)
+		}
+	}
+	return nil
+}
+
+// row is a model decoded from JSON, with its fields in declaration order.
+type row struct {
+	fields []string
+	values record
+}
+
+// record maps column names to values.
+type record map[string]interface{}
+
+// scan returns the rows, joined if the statement has a join, that match the
+// WHERE condition.
+func (s *Statement) scan(inputs []*input) ([]record, error) {
+	// without sorting or grouping, the first rows in key order are the result
+	early := !s.grouped() && len(s.orderBy) == 0 && s.limit >= 0
+	if early && s.limit == 0 {
+		return nil, nil
+	}
+	var records []record
+	keep := func(rec record) bool {
+		if s.where != nil && !s.where.eval(rec) {
+			return true
+		}
+		records = append(records, rec)
+		return !early || len(records) < s.limit
+	}
+	if s.join != nil {
+		if err := s.joinRows(inputs, keep); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+	var scanErr error
+	err := inputs[0].reader.Scan(func(m pkg.Model) bool {
+		r, err := decodeRow(m)
+		if err != nil {
+			scanErr = err
+			return false
+		}
+		return keep(r.values)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning %s: %w", s.from.table, err)
+	}
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	return records, nil
+}
+
+// group returns a record per group holding its GROUP BY columns and the
+// columns of the statement by name.
+func (s *Statement) group(rows []record) []record {
+	var records []record
+	counts := make(map[string][]int)
+	var order []string
+	for _, r := range rows {
+		keyValues := make([]interface{}, len(s.groupBy))
+		for i, field := range s.groupBy {
+			keyValues[i] = r[field]
+		}
+		// the values were decoded from JSON, so they encode back
+		b, _ := json.Marshal(keyValues)
+		key := string(b)
+		c, ok := counts[key]
+		if !ok {
+			c = make([]int, len(s.columns))
+			order = append(order, key)
+			rec := make(record)
+			for _, field := range s.groupBy {
+				rec[field] = r[field]
+			}
+			for _, col := range s.columns {
+				if !col.count {
+					rec[col.name] = r[col.field]
+				}
+			}
+			records = append(records, rec)
+		}
+		for i, col := range s.columns {
+			if col.count && (col.field == "" || r[col.field] != nil) {
+				c[i]++
+			}
+		}
+		counts[key] = c
+	}
+	if len(s.groupBy) == 0 && len(records) == 0 {
+		// counting every row of an empty table still returns a row
+		order = append(order, "")
+		records = append(records, make(record))
+		counts[""] = make([]int, len(s.columns))
+	}
+	for i, rec := range records {
+		for j, col := range s.columns {
+			if col.count {
+				rec[col.name] = counts[order[i]][j]
+			}
+		}
+	}
+	return records
+}
+
+// orderKey returns the key of the records that o sorts by. Ungrouped
+// records are rows, so column aliases are followed to their field.
+func (s *Statement) orderKey(o order) string {
+	for _, c := range s.columns {
+		if o.count && c.count {
+			return c.name
+		}
+		if !o.count && !c.count && c.name == o.field && !s.grouped() {
+			return c.field
+		}
+	}
+	return o.field
+}
+
+func (s *Statement) sort(records []record) {
+	if len(s.orderBy) == 0 {
+		return
+	}
+	keys := make([]string, len(s.orderBy))
+	for i, o := range s.orderBy {
+		keys[i] = s.orderKey(o)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		for k, o := range s.orderBy {
+			c := compareAny(records[i][keys[k]], records[j][keys[k]])
+			if c == 0 {
+				continue
+			}
+			if o.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+func (s *Statement) hasColumn(name string) bool {
+	for _, c := range s.columns {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// project returns the selected columns of records.
+func (s *Statement) project(records []record, inputs []*input) *Result {
+	res := &Result{Rows: make([][]interface{}, 0, len(records))}
+	var keys []string
+	switch {
+	case s.all:
+		for _, in := range inputs {
+			for _, field := range in.fields {
+				res.Columns = append(res.Columns, in.key(field))
+			}
+		}
+		keys = res.Columns
+	case s.grouped():
+		for _, c := range s.columns {
+			res.Columns = append(res.Columns, c.name)
+		}
+		keys = res.Columns
+	default:
+		for _, c := range s.columns {
+			res.Columns = append(res.Columns, c.name)
+			keys = append(keys, c.field)
+		}
+	}
+	for _, rec := range records {
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = rec[key]
+		}
+		res.Rows = append(res.Rows, values)
+	}
+	return res
+}
+
+// decodeRow converts a model to a row through its JSON encoding.
+func decodeRow(m pkg.Model) (row, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return row{}, fmt.Errorf("error encoding row: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	r := row{values: make(record)}
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return row{}, fmt.Errorf("%w: %T is not encoded as a JSON object", ErrInvalidQuery, m)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return row{}, fmt.Errorf("error decoding row: %w", err)
+		}
+		field := t.(string)
+		var v interface{}
+		if err = dec.Decode(&v); err != nil {
+			return row{}, fmt.Errorf("error decoding row: %w", err)
+		}
+		if _, ok := r.values[field]; !ok {
+			r.fields = append(r.fields, field)
+		}
+		r.values[field] = v
+	}
+	return r, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// expr is a WHERE condition.
+type expr interface {
+	eval(record) bool
+	// rename returns a copy of the condition reading the columns renamed by f
+	rename(f func(string) (string, error)) (expr, error)
+}
+
+type logical struct {
+	or          bool
+	left, right expr
+}
+
+func (e *logical) eval(r record) bool {
+	if e.or {
+		return e.left.eval(r) || e.right.eval(r)
+	}
+	return e.left.eval(r) && e.right.eval(r)
+}
+
+func (e *logical) rename(f func(string) (string, error)) (expr, error) {
+	left, err := e.left.rename(f)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.rename(f)
+	if err != nil {
+		return nil, err
+	}
+	return &logical{or: e.or, left: left, right: right}, nil
+}
+
+type negation struct {
+	e expr
+}
+
+func (e *negation) eval(r record) bool {
+	return !e.e.eval(r)
+}
+
+func (e *negation) rename(f func(string) (string, error)) (expr, error) {
+	renamed, err := e.e.rename(f)
+	if err != nil {
+		return nil, err
+	}
+	return &negation{renamed}, nil
+}
+
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+func (e *comparison) eval(r record) bool {
+	c, ok := compare(e.left.get(r), e.right.get(r))
+	if !ok {
+		return e.op == "!="
+	}
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func (e *comparison) rename(f func(string) (string, error)) (expr, error) {
+	renamed := *e
+	for _, o := range []*operand{&renamed.left, &renamed.right} {
+		if o.literal {
+			continue
+		}
+		field, err := f(o.field)
+		if err != nil {
+			return nil, err
+		}
+		o.field = field
+	}
+	return &renamed, nil
+}
+
+// operand is a column or a literal value.
+type operand struct {
+	field   string
+	value   interface{}
+	literal bool
+}
+
+func (o operand) get(r record) interface{} {
+	if o.literal {
+		return o.value
+	}
+	return r[o.field]
+}
+
+// compare compares two values of the same type. ok is false if the values
+// cannot be compared.
+func compare(a, b interface{}) (c int, ok bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case y:
+				return -1, true
+			}
+			return 1, true
+		}
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// order3 orders any two values for ORDER BY: NULL first, then booleans,
+// numbers, strings and other values.
+func compareAny(a, b interface{}) int {
+	if c, ok := compare(a, b); ok {
+		return c
+	}
+	ra, rb := rank(a), rank(b)
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	return 0
+}
+
+func rank(v interface{}) int {
+	if _, ok := number(v); ok {
+		return 2
+	}
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return 3
+	}
+	return 4
+}
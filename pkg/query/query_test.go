@@ -0,0 +1,247 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"example/pkg"
+)
+
+type testPlan struct {
+	ID     pkg.PrimaryKey `json:"id"`
+	Name   string         `json:"name"`
+	Price  float64        `json:"price"`
+	Active bool           `json:"active"`
+	Owner  *string        `json:"owner"`
+}
+
+func (p *testPlan) GetID() pkg.PrimaryKey {
+	return p.ID
+}
+
+func (p *testPlan) SetID(id pkg.PrimaryKey) {
+	p.ID = id
+}
+
+type testSubscription struct {
+	ID     pkg.PrimaryKey `json:"id"`
+	PlanID pkg.PrimaryKey `json:"plan_id"`
+	Plan   string         `json:"plan"`
+}
+
+func (s *testSubscription) GetID() pkg.PrimaryKey {
+	return s.ID
+}
+
+func (s *testSubscription) SetID(id pkg.PrimaryKey) {
+	s.ID = id
+}
+
+func newTestDB(t *testing.T) pkg.DB {
+	t.Helper()
+	db := pkg.NewDB()
+	if err := db.AddTable("plans"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTable("empty"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTable("subs"); err != nil {
+		t.Fatal(err)
+	}
+	table, _ := db.Table("plans")
+	owner := "ops"
+	for _, p := range []*testPlan{
+		{Name: "free", Price: 0, Active: true},
+		{Name: "basic", Price: 9.99, Active: true, Owner: &owner},
+		{Name: "premium", Price: 19.99, Active: false},
+		{Name: "basic", Price: 4.99, Active: false, Owner: &owner},
+	} {
+		if err := table.Insert(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subs, _ := db.Table("subs")
+	for _, s := range []*testSubscription{
+		{PlanID: 2, Plan: "basic"},
+		{PlanID: 9, Plan: "gold"},
+		{PlanID: 3, Plan: "premium"},
+	} {
+		if err := subs.Insert(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// rows converts a result to plain values so it can be compared.
+func rows(res *Result) [][]interface{} {
+	b, _ := json.Marshal(res.Rows)
+	var out [][]interface{}
+	_ = json.Unmarshal(b, &out)
+	return out
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		columns []string
+		rows    [][]interface{}
+	}{
+		{
+			name:    "select all",
+			query:   "SELECT * FROM plans LIMIT 1",
+			columns: []string{"id", "name", "price", "active", "owner"},
+			rows:    [][]interface{}{{1.0, "free", 0.0, true, nil}},
+		},
+		{
+			name:    "columns and alias",
+			query:   "select id, name as plan from plans where price > 5 and active = true",
+			columns: []string{"id", "plan"},
+			rows:    [][]interface{}{{2.0, "basic"}},
+		},
+		{
+			name:    "or and not",
+			query:   "SELECT id FROM plans WHERE NOT (name = 'basic' OR price >= 19.99)",
+			columns: []string{"id"},
+			rows:    [][]interface{}{{1.0}},
+		},
+		{
+			name:    "null",
+			query:   "SELECT id FROM plans WHERE owner = NULL",
+			columns: []string{"id"},
+			rows:    [][]interface{}{{1.0}, {3.0}},
+		},
+		{
+			name:    "order and limit",
+			query:   "SELECT name, price FROM plans ORDER BY name DESC, price LIMIT 3;",
+			columns: []string{"name", "price"},
+			rows:    [][]interface{}{{"premium", 19.99}, {"free", 0.0}, {"basic", 4.99}},
+		},
+		{
+			name:    "order by alias",
+			query:   "SELECT id AS n FROM plans ORDER BY n DESC LIMIT 1",
+			columns: []string{"n"},
+			rows:    [][]interface{}{{4.0}},
+		},
+		{
+			name:    "count",
+			query:   "SELECT COUNT(*), COUNT(owner) AS owned FROM plans",
+			columns: []string{"count", "owned"},
+			rows:    [][]interface{}{{4.0, 2.0}},
+		},
+		{
+			name:    "count empty table",
+			query:   "SELECT COUNT(*) FROM empty",
+			columns: []string{"count"},
+			rows:    [][]interface{}{{0.0}},
+		},
+		{
+			name:    "group by",
+			query:   "SELECT name, COUNT(*) FROM plans WHERE id > 1 GROUP BY name ORDER BY COUNT(*) DESC, name",
+			columns: []string{"name", "count"},
+			rows:    [][]interface{}{{"basic", 2.0}, {"premium", 1.0}},
+		},
+		{
+			name:    "qualified column",
+			query:   "SELECT p.id FROM plans AS p LIMIT 1",
+			columns: []string{"p.id"},
+			rows:    [][]interface{}{{1.0}},
+		},
+		{
+			name:    "inner join by id",
+			query:   "SELECT s.id, p.name FROM subs s JOIN plans p ON s.plan_id = p.id",
+			columns: []string{"s.id", "p.name"},
+			rows:    [][]interface{}{{1.0, "basic"}, {3.0, "premium"}},
+		},
+		{
+			name:    "left join by id",
+			query:   "SELECT s.id, name FROM subs AS s LEFT OUTER JOIN plans p ON p.id = s.plan_id",
+			columns: []string{"s.id", "name"},
+			rows:    [][]interface{}{{1.0, "basic"}, {2.0, nil}, {3.0, "premium"}},
+		},
+		{
+			name:    "join on other column",
+			query:   "SELECT p.id, s.id FROM plans p INNER JOIN subs s ON p.name = s.plan ORDER BY p.id DESC",
+			columns: []string{"p.id", "s.id"},
+			rows:    [][]interface{}{{4.0, 1.0}, {3.0, 3.0}, {2.0, 1.0}},
+		},
+		{
+			name:    "group join",
+			query:   "SELECT p.name, COUNT(s.id) AS subs FROM plans p LEFT JOIN subs s ON s.plan = p.name GROUP BY p.name ORDER BY p.name",
+			columns: []string{"p.name", "subs"},
+			rows:    [][]interface{}{{"basic", 2.0}, {"free", 0.0}, {"premium", 1.0}},
+		},
+		{
+			name:    "join all columns",
+			query:   "SELECT * FROM subs s JOIN plans p ON s.plan_id = p.id LIMIT 1",
+			columns: []string{"s.id", "s.plan_id", "s.plan", "p.id", "p.name", "p.price", "p.active", "p.owner"},
+			rows:    [][]interface{}{{1.0, 2.0, "basic", 2.0, "basic", 9.99, true, "ops"}},
+		},
+		{
+			name:    "no rows",
+			query:   "SELECT id FROM plans WHERE name = 'gold'",
+			columns: []string{"id"},
+			rows:    [][]interface{}{},
+		},
+		{
+			name:    "quoted string",
+			query:   "SELECT id FROM plans WHERE name != 'it''s'",
+			columns: []string{"id"},
+			rows:    [][]interface{}{{1.0}, {2.0}, {3.0}, {4.0}},
+		},
+	}
+	db := newTestDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Run(db, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.Columns, tt.columns) {
+				t.Errorf("columns = %v, want %v", res.Columns, tt.columns)
+			}
+			if got := rows(res); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("rows = %v, want %v", got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr error
+	}{
+		{name: "not a select", query: "DELETE FROM plans", wantErr: ErrSyntax},
+		{name: "missing from", query: "SELECT id", wantErr: ErrSyntax},
+		{name: "trailing tokens", query: "SELECT id FROM plans p q", wantErr: ErrSyntax},
+		{name: "join without on", query: "SELECT id FROM plans JOIN subs", wantErr: ErrSyntax},
+		{name: "unterminated string", query: "SELECT id FROM plans WHERE name = 'x", wantErr: ErrSyntax},
+		{name: "missing comparison", query: "SELECT id FROM plans WHERE name", wantErr: ErrSyntax},
+		{name: "bad limit", query: "SELECT id FROM plans LIMIT x", wantErr: ErrSyntax},
+		{name: "unknown table", query: "SELECT id FROM nope", wantErr: pkg.ErrorNoTable},
+		{name: "unknown column", query: "SELECT nope FROM plans", wantErr: ErrUnknownColumn},
+		{name: "unknown where column", query: "SELECT id FROM plans WHERE nope = 1", wantErr: ErrUnknownColumn},
+		{name: "unknown qualifier", query: "SELECT x.id FROM plans", wantErr: ErrUnknownColumn},
+		{name: "ambiguous column", query: "SELECT id FROM subs s JOIN plans p ON s.plan_id = p.id", wantErr: ErrInvalidQuery},
+		{name: "join on one table", query: "SELECT s.id FROM subs s JOIN plans p ON s.plan_id = s.id", wantErr: ErrInvalidQuery},
+		{name: "same alias", query: "SELECT p.id FROM subs p JOIN plans p ON p.plan_id = p.id", wantErr: ErrInvalidQuery},
+		{name: "ungrouped column", query: "SELECT name, COUNT(*) FROM plans", wantErr: ErrInvalidQuery},
+		{name: "grouped star", query: "SELECT * FROM plans GROUP BY name", wantErr: ErrInvalidQuery},
+		{name: "order groups by other column", query: "SELECT name, COUNT(*) FROM plans GROUP BY name ORDER BY price", wantErr: ErrInvalidQuery},
+		{name: "order by count without count", query: "SELECT id FROM plans ORDER BY COUNT(*)", wantErr: ErrInvalidQuery},
+	}
+	db := newTestDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Run(db, tt.query); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import "fmt"
+
+var ErrReadOnly = fmt.Errorf("database is read-only")
+
+// ReadOnly returns a view of d whose tables reject every write with
+// ErrReadOnly, such as the database of a follower. Tables can still be added
+// so that the application can declare the tables it reads.
+func ReadOnly(d DB) DB {
+	return &readOnlyDB{d}
+}
+
+type readOnlyDB struct {
+	DB
+}
+
+func (d *readOnlyDB) Table(s string) (Table, error) {
+	t, err := d.DB.Table(s)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyTable{t}, nil
+}
+
+// readOnlyTable is a table that can only be read, such as a table of a
+// read-only database or a view.
+type readOnlyTable struct {
+	Reader
+}
+
+func (t *readOnlyTable) Insert(Model) error {
+	return ErrReadOnly
+}
+
+func (t *readOnlyTable) Update(Model) error {
+	return ErrReadOnly
+}
+
+func (t *readOnlyTable) Delete(PrimaryKey) error {
+	return ErrReadOnly
+}
+
+func (t *readOnlyTable) InsertMany([]Model) error {
+	return ErrReadOnly
+}
+
+func (t *readOnlyTable) UpdateMany([]Model) error {
+	return ErrReadOnly
+}
+
+func (t *readOnlyTable) UpdateWhere(func(Model) bool, func(Model) error) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (t *readOnlyTable) DeleteWhere(func(Model) bool) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (t *readOnlyTable) Upsert(Model) (bool, error) {
+	return false, ErrReadOnly
+}
+
+func (t *readOnlyTable) CompareAndSwap(PrimaryKey, Model, Model) (bool, error) {
+	return false, ErrReadOnly
+}
+
+var (
+	_ DB    = &readOnlyDB{}
+	_ Table = &readOnlyTable{}
+)
@@ -0,0 +1,500 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrReplicationUnsupported = fmt.Errorf("database does not support replication")
+
+const (
+	// followerBuffer is the number of change batches queued for a follower
+	// before it is disconnected and has to catch up again.
+	followerBuffer = 1024
+	// snapshotChunk is the number of rows sent per snapshot message.
+	snapshotChunk = 256
+)
+
+// replHello is sent by a follower when it connects. LastSeq is the sequence
+// number of the last leader change it applied, in the history of the leader
+// identified by Epoch. A leader with another epoch restarted since, so its
+// sequence numbers name other changes and it sends a snapshot.
+type replHello struct {
+	Epoch   uint64
+	LastSeq uint64
+}
+
+type replKind int
+
+const (
+	// replSnapshotStart starts a copy of every table, taken after change Seq
+	// of the history Epoch
+	replSnapshotStart replKind = iota + 1
+	// replSnapshotTable starts the copy of Table, whose last ID is LastID
+	replSnapshotTable
+	// replSnapshotRows holds rows of Table
+	replSnapshotRows
+	// replSnapshotEnd ends the copy
+	replSnapshotEnd
+	// replChanges holds changes committed on the leader
+	replChanges
+)
+
+// replMessage is sent by the leader to its followers.
+type replMessage struct {
+	Kind    replKind
+	Epoch   uint64
+	Seq     uint64
+	Table   string
+	LastID  PrimaryKey
+	Rows    []Model
+	Changes []Change
+}
+
+// replica is implemented by tables that can be written by a leader.
+type replica interface {
+	// apply writes a change received from the leader
+	apply(Change)
+	// reset removes every row and sets the last assigned ID
+	reset(lastID PrimaryKey)
+	// lastAssignedID returns the last ID assigned by Insert
+	lastAssignedID() PrimaryKey
+}
+
+// Leader streams the changes committed to a database to follower processes
+// over TCP. A follower that reconnects receives the changes it missed, or a
+// snapshot of every table if they are no longer in the replication backlog.
+type Leader struct {
+	db       *db
+	listener net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewLeader listens on addr for followers of d.
+func NewLeader(d DB, addr string) (*Leader, error) {
+	source, ok := d.(*db)
+	if !ok {
+		return nil, ErrReplicationUnsupported
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for followers: %w", err)
+	}
+	return &Leader{
+		db:       source,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Addr returns the address followers connect to
+func (l *Leader) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Serve accepts followers until the leader is closed
+func (l *Leader) Serve() error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.mu.Lock()
+			closed := l.closed
+			l.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return fmt.Errorf("error accepting follower: %w", err)
+		}
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+		go func() {
+			// errors only end this follower's stream; it reconnects on its own
+			_ = l.serveFollower(conn)
+			l.mu.Lock()
+			delete(l.conns, conn)
+			l.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// Close stops accepting followers and disconnects the connected ones
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	return l.listener.Close()
+}
+
+func (l *Leader) serveFollower(conn net.Conn) error {
+	var hello replHello
+	if err := gob.NewDecoder(conn).Decode(&hello); err != nil {
+		return fmt.Errorf("error reading hello: %w", err)
+	}
+	w, seq, missed, ok := l.db.log.watch(hello.LastSeq, followerBuffer)
+	defer l.db.log.unwatch(w)
+	if hello.Epoch != l.db.log.epoch {
+		ok = false
+	}
+	go func() {
+		// followers send nothing after their hello, so this returns once
+		// the connection is gone and stops the stream below
+		_, _ = io.Copy(io.Discard, conn)
+		l.db.log.unwatch(w)
+	}()
+
+	buf := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(buf)
+	if !ok {
+		if err := l.sendSnapshot(enc, seq); err != nil {
+			return err
+		}
+	} else if len(missed) > 0 {
+		if err := enc.Encode(&replMessage{Kind: replChanges, Changes: missed}); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	for changes := range w.c {
+		if err := enc.Encode(&replMessage{Kind: replChanges, Changes: changes}); err != nil {
+			return err
+		}
+		if len(w.c) == 0 {
+			if err := buf.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sendSnapshot sends a copy of every table. The copy is taken after change
+// seq was committed, so applying the changes that follow it in order brings
+// the follower up to date even if the copy already includes some of them.
+func (l *Leader) sendSnapshot(enc *gob.Encoder, seq uint64) error {
+	if err := enc.Encode(&replMessage{Kind: replSnapshotStart, Epoch: l.db.log.epoch, Seq: seq}); err != nil {
+		return err
+	}
+	for _, name := range l.db.Tables() {
+		t, err := l.db.table(name)
+		if err != nil {
+			return err
+		}
+		r, ok := t.(replica)
+		if !ok {
+			// views are computed by the follower
+			continue
+		}
+		lastID := r.lastAssignedID()
+		if err = enc.Encode(&replMessage{Kind: replSnapshotTable, Table: name, LastID: lastID}); err != nil {
+			return err
+		}
+		rows := make([]Model, 0, snapshotChunk)
+		var sendErr error
+		flush := func() bool {
+			if len(rows) == 0 {
+				return true
+			}
+			sendErr = enc.Encode(&replMessage{Kind: replSnapshotRows, Table: name, Rows: rows})
+			rows = rows[:0]
+			return sendErr == nil
+		}
+		err = t.Scan(func(m Model) bool {
+			rows = append(rows, m)
+			return len(rows) < snapshotChunk || flush()
+		})
+		if err != nil {
+			return err
+		}
+		if !flush() {
+			return sendErr
+		}
+	}
+	return enc.Encode(&replMessage{Kind: replSnapshotEnd, Seq: seq})
+}
+
+// Follower keeps a database up to date with the changes committed on a
+// leader. The follower's database should only be written by the follower;
+// serve it to the application through ReadOnly.
+type Follower struct {
+	db     *db
+	leader string
+	// RetryInterval is how long the follower waits before reconnecting
+	RetryInterval time.Duration
+
+	// staged holds the tables a snapshot is copied into, swapped in for
+	// the tables of db once the copy is complete
+	staged      map[string]Table
+	stagedEpoch uint64
+
+	mu      sync.Mutex
+	epoch   uint64
+	lastSeq uint64
+	err     error
+}
+
+// NewFollower returns a follower that copies the leader listening on
+// leaderAddr into d.
+func NewFollower(d DB, leaderAddr string) (*Follower, error) {
+	target, ok := d.(*db)
+	if !ok {
+		return nil, ErrReplicationUnsupported
+	}
+	return &Follower{
+		db:            target,
+		leader:        leaderAddr,
+		RetryInterval: time.Second,
+	}, nil
+}
+
+// Run replicates from the leader until ctx is done, reconnecting whenever the
+// connection is lost.
+func (f *Follower) Run(ctx context.Context) error {
+	for {
+		err := f.follow(ctx)
+		f.mu.Lock()
+		f.err = err
+		f.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(f.RetryInterval):
+		}
+	}
+}
+
+// LastSeq returns the sequence number of the last leader change applied
+func (f *Follower) LastSeq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastSeq
+}
+
+// Err returns the error that ended the last connection to the leader
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *Follower) follow(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", f.leader)
+	if err != nil {
+		return fmt.Errorf("error connecting to leader: %w", err)
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	// a snapshot cut short is started over
+	defer f.discardStaged()
+	f.mu.Lock()
+	hello := replHello{Epoch: f.epoch, LastSeq: f.lastSeq}
+	f.mu.Unlock()
+	if err = gob.NewEncoder(conn).Encode(&hello); err != nil {
+		return fmt.Errorf("error sending hello: %w", err)
+	}
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	for {
+		var msg replMessage
+		if err = dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("leader closed the connection: %w", err)
+			}
+			return fmt.Errorf("error reading from leader: %w", err)
+		}
+		if err = f.handle(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Follower) handle(msg *replMessage) error {
+	switch msg.Kind {
+	case replSnapshotStart:
+		// the copy is made into new tables so that readers keep the old
+		// rows until it is complete. Tables the leader does not send end
+		// up empty.
+		f.discardStaged()
+		f.staged = make(map[string]Table)
+		f.stagedEpoch = msg.Epoch
+		for _, name := range f.db.Tables() {
+			t, err := f.db.table(name)
+			if err != nil {
+				return err
+			}
+			// views are computed again once the copy is swapped in
+			if _, ok := t.(replica); !ok {
+				continue
+			}
+			if err = f.stage(name, 0); err != nil {
+				return err
+			}
+		}
+	case replSnapshotTable:
+		if err := f.stage(msg.Table, msg.LastID); err != nil {
+			return err
+		}
+	case replSnapshotRows:
+		t, ok := f.staged[msg.Table]
+		if !ok {
+			return fmt.Errorf("rows of table %s sent outside its snapshot", msg.Table)
+		}
+		r := t.(replica)
+		for _, m := range msg.Rows {
+			r.apply(Change{Table: msg.Table, Op: OpPut, Key: m.GetID(), Model: m})
+		}
+	case replSnapshotEnd:
+		if f.staged == nil {
+			return fmt.Errorf("end of a snapshot that was not started")
+		}
+		if err := f.db.swapTables(f.staged); err != nil {
+			return err
+		}
+		f.staged = nil
+		f.mu.Lock()
+		f.epoch = f.stagedEpoch
+		f.lastSeq = msg.Seq
+		f.mu.Unlock()
+	case replChanges:
+		for _, c := range msg.Changes {
+			if c.Seq <= f.LastSeq() {
+				continue
+			}
+			r, err := f.replica(c.Table)
+			if err != nil {
+				return err
+			}
+			r.apply(c)
+			f.mu.Lock()
+			f.lastSeq = c.Seq
+			f.mu.Unlock()
+		}
+	default:
+		return fmt.Errorf("unknown replication message %d", msg.Kind)
+	}
+	return nil
+}
+
+// stage adds an empty table named name, whose last ID is lastID, to the
+// tables a snapshot is copied into, replacing the one staged already.
+func (f *Follower) stage(name string, lastID PrimaryKey) error {
+	if f.staged == nil {
+		return fmt.Errorf("table %s sent outside a snapshot", name)
+	}
+	if prev, ok := f.staged[name]; ok {
+		if err := discard(prev); err != nil {
+			return err
+		}
+	}
+	// the rows of the copy are not new changes, so nothing records them
+	t, err := f.db.newTable(name, nil)
+	if err != nil {
+		return err
+	}
+	t.(replica).reset(lastID)
+	f.staged[name] = t
+	return nil
+}
+
+// discardStaged drops the tables of a snapshot that was not swapped in.
+func (f *Follower) discardStaged() {
+	for _, t := range f.staged {
+		// the tables were never read, so there is nothing to report
+		_ = discard(t)
+	}
+	f.staged = nil
+}
+
+// swapTables replaces tables of the database, and adds the ones it lacks,
+// with tables filled outside it. The views are computed again from the new
+// tables, so readers see either the old rows or the new ones.
+func (d *db) swapTables(tables map[string]Table) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	next := make(map[string]Table, len(d.tables)+len(tables))
+	for name, t := range d.tables {
+		next[name] = t
+	}
+	for name, t := range tables {
+		next[name] = t
+	}
+	old := d.log.allViews()
+	views := make([]*view, len(old))
+	for i, v := range old {
+		nv, err := loadView(v.name, v.def, next)
+		if err != nil {
+			return err
+		}
+		views[i] = nv
+		next[v.name] = &readOnlyTable{nv}
+	}
+	for _, t := range tables {
+		for _, part := range partsOf(t) {
+			part.mu.Lock()
+			part.log = d.log
+			part.mu.Unlock()
+		}
+	}
+	d.log.replaceViews(views)
+	replaced := d.tables
+	d.tables = next
+	var firstErr error
+	for name := range tables {
+		if t, ok := replaced[name]; ok {
+			if err := discard(t); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// replica returns the table changes for name are applied to, adding it if
+// the leader has a table the follower does not.
+func (f *Follower) replica(name string) (replica, error) {
+	t, err := f.db.table(name)
+	if errors.Is(err, ErrorNoTable) {
+		if err = f.db.AddTable(name); err != nil && !errors.Is(err, ErrTableExists) {
+			return nil, err
+		}
+		t, err = f.db.table(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, ok := t.(replica)
+	if !ok {
+		return nil, fmt.Errorf("%w: table %s", ErrReplicationUnsupported, name)
+	}
+	return r, nil
+}
@@ -0,0 +1,251 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func startLeader(t *testing.T, d DB) *Leader {
+	t.Helper()
+	leader, err := NewLeader(d, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve()
+	t.Cleanup(func() { leader.Close() })
+	return leader
+}
+
+func startFollower(t *testing.T, d DB, addr string) (*Follower, context.CancelFunc) {
+	t.Helper()
+	follower, err := NewFollower(d, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	follower.RetryInterval = 10 * time.Millisecond
+	return follower, runFollower(t, follower)
+}
+
+// runFollower runs a follower until the returned function is called.
+func runFollower(t *testing.T, follower *Follower) context.CancelFunc {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		follower.Run(ctx)
+		close(done)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// waitForRows waits until the table of d named name holds want rows.
+func waitForRows(t *testing.T, d DB, name string, want int) []Model {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var got []Model
+		if table, err := d.Table(name); err == nil {
+			got, _ = table.Find(func(Model) bool { return true })
+			if len(got) == want {
+				return got
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has %d rows, want %d", name, len(got), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leaderDB := NewDB()
+	if err := leaderDB.AddTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := leaderDB.Table("users")
+	for i := 0; i < 3; i++ {
+		if err := users.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leader := startLeader(t, leaderDB)
+
+	followerDB := NewDB()
+	startFollower(t, followerDB, leader.Addr().String())
+	waitForRows(t, followerDB, "users", 3)
+
+	if err := users.Update(&testModel{ID: 2, Data: "test1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := leaderDB.AddTable("groups"); err != nil {
+		t.Fatal(err)
+	}
+	groups, _ := leaderDB.Table("groups")
+	if err := groups.Insert(&testModel{Data: "admins"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForRows(t, followerDB, "groups", 1)
+	got := waitForRows(t, followerDB, "users", 2)
+	if got[0].GetID() != 2 || got[0].(*testModel).Data != "test1" {
+		t.Errorf("got = %+v, want row 2 updated", got[0])
+	}
+
+	readOnly := ReadOnly(followerDB)
+	table, err := readOnly.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = table.Insert(&testModel{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("error = %v, wantErr %v", err, ErrReadOnly)
+	}
+	if _, err = table.Get(2); err != nil {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestReplication_CatchUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		backlog int
+	}{
+		{name: "from backlog", backlog: 100},
+		{name: "from snapshot", backlog: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			leaderDB := NewDB(WithReplicationBacklog(test.backlog))
+			if err := leaderDB.AddTable("users"); err != nil {
+				t.Fatal(err)
+			}
+			users, _ := leaderDB.Table("users")
+			if err := users.Insert(&testModel{Data: "test"}); err != nil {
+				t.Fatal(err)
+			}
+			leader := startLeader(t, leaderDB)
+
+			followerDB := NewDB()
+			follower, stop := startFollower(t, followerDB, leader.Addr().String())
+			waitForRows(t, followerDB, "users", 1)
+			stop()
+			synced := follower.LastSeq()
+
+			for i := 0; i < 10; i++ {
+				if err := users.Insert(&testModel{Data: "test"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := users.Delete(1); err != nil {
+				t.Fatal(err)
+			}
+
+			// readers keep the old rows until a snapshot is swapped in
+			emptied := make(chan bool)
+			done := make(chan struct{})
+			go func() {
+				for {
+					select {
+					case <-done:
+						emptied <- false
+						return
+					default:
+					}
+					table, _ := followerDB.Table("users")
+					if ms, _ := table.Find(func(Model) bool { return true }); len(ms) == 0 {
+						emptied <- true
+						return
+					}
+				}
+			}()
+			runFollower(t, follower)
+			got := waitForRows(t, followerDB, "users", 10)
+			close(done)
+			if <-emptied {
+				t.Errorf("users was empty while catching up")
+			}
+			if got[0].GetID() != 2 {
+				t.Errorf("first id = %v, want %v", got[0].GetID(), 2)
+			}
+			if follower.LastSeq() <= synced {
+				t.Errorf("last seq = %v, want more than %v", follower.LastSeq(), synced)
+			}
+		})
+	}
+}
+
+func TestReplication_LeaderRestart(t *testing.T) {
+	insert := func(d DB, subs ...*testSub) {
+		t.Helper()
+		table, _ := d.Table("subs")
+		for _, sub := range subs {
+			if err := table.Insert(sub); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	first := NewDB()
+	if err := first.AddTable("subs"); err != nil {
+		t.Fatal(err)
+	}
+	insert(first, &testSub{UserID: 1, Plan: "basic"}, &testSub{UserID: 2, Plan: "basic"})
+	leader, err := NewLeader(first, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve()
+	addr := leader.Addr().String()
+
+	followerDB := NewDB()
+	if err = followerDB.AddTable("subs"); err != nil {
+		t.Fatal(err)
+	}
+	if err = followerDB.AddView("latest", latestSub); err != nil {
+		t.Fatal(err)
+	}
+	follower, stop := startFollower(t, followerDB, addr)
+	waitForRows(t, followerDB, "subs", 2)
+	stop()
+	leader.Close()
+
+	// the restarted leader has a new history that has gone past the
+	// sequence number the follower reached in the old one
+	second := NewDB()
+	if err = second.AddTable("subs"); err != nil {
+		t.Fatal(err)
+	}
+	insert(second, &testSub{UserID: 1, Plan: "premium"}, &testSub{UserID: 3, Plan: "free"}, &testSub{UserID: 3, Plan: "premium"})
+	leader, err = NewLeader(second, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve()
+	t.Cleanup(func() { leader.Close() })
+	runFollower(t, follower)
+
+	want := "[1:premium 3:premium]"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := fmt.Sprint(viewRows(t, followerDB, "latest"))
+		if got == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("latest = %v, want %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := fmt.Sprint(viewRows(t, followerDB, "subs")); got != "[1:premium 2:free 3:premium]" {
+		t.Errorf("subs = %v, want %v", got, "[1:premium 2:free 3:premium]")
+	}
+}
@@ -0,0 +1,205 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrValidation = fmt.Errorf("validation failed")
+
+// Constrained is implemented by models that declare constraints on their
+// fields. Tables reject writes of models breaking them with a
+// *ValidationError.
+type Constrained interface {
+	Model
+	// Schema returns the constraints. It is called on every write, so it
+	// should return a schema declared once.
+	Schema() Schema
+}
+
+// Schema lists the constraints on the fields of a model
+type Schema []FieldSchema
+
+// FieldSchema holds the constraints on a field of a model
+type FieldSchema struct {
+	// Name is the name of the struct field
+	Name        string
+	Constraints []Constraint
+}
+
+// Field returns the constraints on the struct field named name
+func Field(name string, constraints ...Constraint) FieldSchema {
+	return FieldSchema{Name: name, Constraints: constraints}
+}
+
+// Rule names of the constraints
+const (
+	RuleRequired = "required"
+	RuleEnum     = "enum"
+	RuleLength   = "length"
+	RulePattern  = "pattern"
+	RuleRange    = "range"
+)
+
+// Constraint is a rule a field must follow. Apart from Required, the
+// constraints of a field only apply to values that are not empty strings.
+type Constraint struct {
+	Rule string
+	// check returns why v breaks the rule, or "" if it does not
+	check func(v reflect.Value) string
+}
+
+// Required rejects the zero value of the field's type
+func Required() Constraint {
+	return Constraint{Rule: RuleRequired, check: func(v reflect.Value) string {
+		if v.IsZero() {
+			return "is required"
+		}
+		return ""
+	}}
+}
+
+// OneOf accepts strings equal to one of values
+func OneOf(values ...string) Constraint {
+	return Constraint{Rule: RuleEnum, check: func(v reflect.Value) string {
+		if v.Kind() != reflect.String {
+			return fmt.Sprintf("has unsupported type %s", v.Type())
+		}
+		for _, value := range values {
+			if v.String() == value {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(values, ", "))
+	}}
+}
+
+// Length accepts strings of min to max characters. A max of 0 sets no upper
+// limit.
+func Length(min, max int) Constraint {
+	return Constraint{Rule: RuleLength, check: func(v reflect.Value) string {
+		if v.Kind() != reflect.String {
+			return fmt.Sprintf("has unsupported type %s", v.Type())
+		}
+		n := utf8.RuneCountInString(v.String())
+		if n < min {
+			return fmt.Sprintf("must be at least %d characters long", min)
+		}
+		if max > 0 && n > max {
+			return fmt.Sprintf("must be at most %d characters long", max)
+		}
+		return ""
+	}}
+}
+
+// Pattern accepts strings matching the regular expression expr. It panics if
+// expr does not compile.
+func Pattern(expr string) Constraint {
+	re := regexp.MustCompile(expr)
+	return Constraint{Rule: RulePattern, check: func(v reflect.Value) string {
+		if v.Kind() != reflect.String {
+			return fmt.Sprintf("has unsupported type %s", v.Type())
+		}
+		if !re.MatchString(v.String()) {
+			return fmt.Sprintf("must match %s", expr)
+		}
+		return ""
+	}}
+}
+
+// Range accepts numbers from min to max included
+func Range(min, max float64) Constraint {
+	return Constraint{Rule: RuleRange, check: func(v reflect.Value) string {
+		var n float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		default:
+			return fmt.Sprintf("has unsupported type %s", v.Type())
+		}
+		if n < min || n > max {
+			return fmt.Sprintf("must be between %v and %v", min, max)
+		}
+		return ""
+	}}
+}
+
+// FieldError is a field breaking a constraint
+type FieldError struct {
+	// Field is the JSON name of the field, or its struct field name if it has
+	// none
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// ValidationError lists the fields of a model that break their constraints,
+// the first broken constraint of each field in schema order
+type ValidationError struct {
+	Fields []*FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Validate checks m against its schema, if it declares one, and returns a
+// *ValidationError listing the fields breaking it.
+func Validate(m Model) error {
+	c, ok := m.(Constrained)
+	if !ok {
+		return nil
+	}
+	v := reflect.Indirect(reflect.ValueOf(m))
+	var fields []*FieldError
+	for _, field := range c.Schema() {
+		sf, ok := v.Type().FieldByName(field.Name)
+		if !ok {
+			panic(fmt.Sprintf("pkg: schema of %s names unknown field %s", v.Type(), field.Name))
+		}
+		value := v.FieldByIndex(sf.Index)
+		for _, constraint := range field.Constraints {
+			if constraint.Rule != RuleRequired && value.Kind() == reflect.String && value.Len() == 0 {
+				continue
+			}
+			if msg := constraint.check(value); msg != "" {
+				fields = append(fields, &FieldError{Field: jsonName(sf), Rule: constraint.Rule, Message: msg})
+				break
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// jsonName returns the name of a struct field in JSON.
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+var _ error = &ValidationError{}
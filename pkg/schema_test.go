@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testAccount struct {
+	ID      PrimaryKey `json:"id"`
+	Name    string     `json:"name"`
+	Plan    string     `json:"plan,omitempty"`
+	Code    string
+	Seats   int     `json:"seats"`
+	Balance float64 `json:"balance"`
+}
+
+func (a *testAccount) GetID() PrimaryKey {
+	return a.ID
+}
+
+func (a *testAccount) SetID(id PrimaryKey) {
+	a.ID = id
+}
+
+var testAccountSchema = Schema{
+	Field("Name", Required(), Length(2, 5)),
+	Field("Plan", OneOf("free", "paid")),
+	Field("Code", Pattern(`^[A-Z]{3}$`)),
+	Field("Seats", Range(1, 10)),
+	Field("Balance", Range(-5, 5)),
+}
+
+func (a *testAccount) Schema() Schema {
+	return testAccountSchema
+}
+
+func TestValidate(t *testing.T) {
+	valid := testAccount{Name: "ann", Plan: "free", Code: "ABC", Seats: 1}
+	tests := []struct {
+		name   string
+		change func(a *testAccount)
+		// want lists the rejected fields and rules
+		want []FieldError
+	}{
+		{name: "valid", change: func(*testAccount) {}},
+		{name: "optional strings", change: func(a *testAccount) { a.Plan, a.Code = "", "" }},
+		{name: "required", change: func(a *testAccount) { a.Name = "" }, want: []FieldError{{Field: "name", Rule: RuleRequired}}},
+		{name: "too short", change: func(a *testAccount) { a.Name = "a" }, want: []FieldError{{Field: "name", Rule: RuleLength}}},
+		{name: "too long", change: func(a *testAccount) { a.Name = "abcdef" }, want: []FieldError{{Field: "name", Rule: RuleLength}}},
+		{name: "length in characters", change: func(a *testAccount) { a.Name = "éééé" }},
+		{name: "enum", change: func(a *testAccount) { a.Plan = "gold" }, want: []FieldError{{Field: "plan", Rule: RuleEnum}}},
+		{name: "pattern", change: func(a *testAccount) { a.Code = "abc" }, want: []FieldError{{Field: "Code", Rule: RulePattern}}},
+		{name: "int range", change: func(a *testAccount) { a.Seats = 0 }, want: []FieldError{{Field: "seats", Rule: RuleRange}}},
+		{name: "float range", change: func(a *testAccount) { a.Balance = 5.5 }, want: []FieldError{{Field: "balance", Rule: RuleRange}}},
+		{
+			name:   "several fields",
+			change: func(a *testAccount) { a.Name, a.Seats = "", 11 },
+			want:   []FieldError{{Field: "name", Rule: RuleRequired}, {Field: "seats", Rule: RuleRange}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := valid
+			tt.change(&a)
+			err := Validate(&a)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrValidation) {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, ErrValidation)
+			}
+			var v *ValidationError
+			errors.As(err, &v)
+			got := make([]FieldError, len(v.Fields))
+			for i, f := range v.Fields {
+				got[i] = FieldError{Field: f.Field, Rule: f.Rule}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTable_Validates(t *testing.T) {
+	for _, shards := range []int{1, 4} {
+		table := newTestTable(t, WithShards(shards))
+		invalid := &testAccount{Name: "a", Seats: 1}
+		if err := table.Insert(invalid); !errors.Is(err, ErrValidation) {
+			t.Errorf("Insert error = %v, wantErr %v", err, ErrValidation)
+		}
+		if invalid.ID != 0 {
+			t.Errorf("rejected Insert set ID %v", invalid.ID)
+		}
+		if err := table.Insert(&testAccount{Name: "ann", Seats: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := table.Update(&testAccount{ID: 1, Name: "ann"}); !errors.Is(err, ErrValidation) {
+			t.Errorf("Update error = %v, wantErr %v", err, ErrValidation)
+		}
+		if _, err := table.Upsert(&testAccount{ID: 5, Name: "ann"}); !errors.Is(err, ErrValidation) {
+			t.Errorf("Upsert error = %v, wantErr %v", err, ErrValidation)
+		}
+		if _, err := table.CompareAndSwap(1, &testAccount{ID: 1, Name: "ann", Seats: 1}, &testAccount{ID: 1}); !errors.Is(err, ErrValidation) {
+			t.Errorf("CompareAndSwap error = %v, wantErr %v", err, ErrValidation)
+		}
+		err := table.InsertMany([]Model{&testAccount{Name: "bob", Seats: 1}, &testAccount{Name: "b"}})
+		var batch *BatchError
+		if !errors.As(err, &batch) || len(batch.Rows) != 1 || batch.Rows[0].Index != 1 || !errors.Is(err, ErrValidation) {
+			t.Errorf("InsertMany error = %v, want row 1 rejected by validation", err)
+		}
+		_, err = table.UpdateWhere(func(Model) bool { return true }, func(m Model) error {
+			m.(*testAccount).Seats = 0
+			return nil
+		})
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf("UpdateWhere error = %v, wantErr %v", err, ErrValidation)
+		}
+		got, err := table.Get(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (&testAccount{ID: 1, Name: "ann", Seats: 1}); !reflect.DeepEqual(got, want) {
+			t.Errorf("row = %+v, want %+v", got, want)
+		}
+	}
+}
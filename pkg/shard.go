@@ -0,0 +1,272 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+)
+
+// shardedTable partitions its rows across shards by a hash of their primary
+// key. Each shard is a table with its own lock, so writes to rows in
+// different shards do not wait for each other. Single row operations lock
+// one shard; batches lock every shard and stay all or nothing. Reads that
+// span shards see each shard at a slightly different time.
+type shardedTable struct {
+	name   string
+	shards []*table
+
+	idMu   sync.Mutex
+	lastID PrimaryKey
+}
+
+func newShardedTable(name string, n int) *shardedTable {
+	t := &shardedTable{
+		name:   name,
+		shards: make([]*table, n),
+	}
+	for i := range t.shards {
+		t.shards[i] = &table{
+			name: name,
+			data: make(map[PrimaryKey]Model),
+		}
+	}
+	return t
+}
+
+// shard returns the shard holding key.
+func (t *shardedTable) shard(key PrimaryKey) *table {
+	// Fibonacci hashing spreads sequential keys over the shards
+	h := uint64(key) * 0x9E3779B97F4A7C15
+	return t.shards[(h>>32)%uint64(len(t.shards))]
+}
+
+func (t *shardedTable) nextID() PrimaryKey {
+	t.idMu.Lock()
+	defer t.idMu.Unlock()
+	t.lastID++
+	return t.lastID
+}
+
+// seenID makes sure IDs assigned later are greater than key.
+func (t *shardedTable) seenID(key PrimaryKey) {
+	t.idMu.Lock()
+	defer t.idMu.Unlock()
+	if key > t.lastID {
+		t.lastID = key
+	}
+}
+
+func (t *shardedTable) Name() string {
+	return t.name
+}
+
+func (t *shardedTable) Insert(model Model) error {
+	if model.GetID() != 0 {
+		return ErrAlreadyHasID
+	}
+	// validating before taking an ID leaves no gap when the model is rejected
+	if err := Validate(model); err != nil {
+		return err
+	}
+	model.SetID(t.nextID())
+	_, err := t.shard(model.GetID()).Upsert(model)
+	return err
+}
+
+func (t *shardedTable) Update(model Model) error {
+	return t.shard(model.GetID()).Update(model)
+}
+
+func (t *shardedTable) Delete(key PrimaryKey) error {
+	return t.shard(key).Delete(key)
+}
+
+func (t *shardedTable) Get(key PrimaryKey) (Model, error) {
+	return t.shard(key).Get(key)
+}
+
+// Find searches the shards in parallel, so f must be safe to call from
+// several goroutines at once.
+func (t *shardedTable) Find(f func(Model) bool) ([]Model, error) {
+	parts := make([][]Model, len(t.shards))
+	errs := make([]error, len(t.shards))
+	var wg sync.WaitGroup
+	for i, shard := range t.shards {
+		wg.Add(1)
+		go func(i int, shard *table) {
+			defer wg.Done()
+			parts[i], errs[i] = shard.Find(f)
+		}(i, shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mergeModels(parts), nil
+}
+
+func (t *shardedTable) Scan(f func(Model) bool) error {
+	parts := make([][]PrimaryKey, len(t.shards))
+	for i, shard := range t.shards {
+		parts[i] = shard.sortedKeys()
+	}
+	for _, key := range mergeKeys(parts) {
+		model, ok, err := t.shard(key).read(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if !f(clone(model)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (t *shardedTable) InsertMany(models []Model) error {
+	defer t.lockAll()()
+	return insertMany(t.rows(), models, func() PrimaryKey {
+		t.lastID++
+		return t.lastID
+	})
+}
+
+func (t *shardedTable) UpdateMany(models []Model) error {
+	defer t.lockAll()()
+	return updateMany(t.rows(), models)
+}
+
+func (t *shardedTable) UpdateWhere(match func(Model) bool, update func(Model) error) (int, error) {
+	defer t.lockAll()()
+	return updateWhere(t.rows(), match, update)
+}
+
+func (t *shardedTable) DeleteWhere(f func(Model) bool) (int, error) {
+	defer t.lockAll()()
+	return deleteWhere(t.rows(), f)
+}
+
+func (t *shardedTable) Upsert(model Model) (bool, error) {
+	if model.GetID() == 0 {
+		return false, ErrNoID
+	}
+	t.seenID(model.GetID())
+	return t.shard(model.GetID()).Upsert(model)
+}
+
+func (t *shardedTable) CompareAndSwap(key PrimaryKey, expected, new Model) (bool, error) {
+	return t.shard(key).CompareAndSwap(key, expected, new)
+}
+
+// lockAll locks the ID sequence and every shard in order and returns the
+// function that publishes the changes made and unlocks them.
+func (t *shardedTable) lockAll() func() {
+	t.idMu.Lock()
+	for _, shard := range t.shards {
+		shard.mu.Lock()
+	}
+	return func() {
+		publish(t.shards)
+		for i := len(t.shards) - 1; i >= 0; i-- {
+			t.shards[i].mu.Unlock()
+		}
+		t.idMu.Unlock()
+		t.shards[0].refreshViews()
+		t.shards[0].budget.enforce()
+	}
+}
+
+// rows returns the shards as a row set. The caller must hold every lock.
+func (t *shardedTable) rows() rowSet {
+	return rowSet{
+		tables: t.shards,
+		route:  t.shard,
+	}
+}
+
+func (t *shardedTable) apply(c Change) {
+	t.seenID(c.Key)
+	t.shard(c.Key).apply(c)
+}
+
+func (t *shardedTable) reset(lastID PrimaryKey) {
+	t.idMu.Lock()
+	defer t.idMu.Unlock()
+	for _, shard := range t.shards {
+		shard.reset(0)
+	}
+	t.lastID = lastID
+}
+
+func (t *shardedTable) lastAssignedID() PrimaryKey {
+	t.idMu.Lock()
+	defer t.idMu.Unlock()
+	return t.lastID
+}
+
+func (t *shardedTable) parts() []*table {
+	return t.shards
+}
+
+func (t *shardedTable) route(key PrimaryKey) *table {
+	return t.shard(key)
+}
+
+// mergeKeys merges lists of ascending keys into one ascending list.
+func mergeKeys(parts [][]PrimaryKey) []PrimaryKey {
+	var n int
+	for _, part := range parts {
+		n += len(part)
+	}
+	merged := make([]PrimaryKey, 0, n)
+	next := make([]int, len(parts))
+	for len(merged) < n {
+		min := -1
+		for i, part := range parts {
+			if next[i] < len(part) && (min < 0 || part[next[i]] < parts[min][next[min]]) {
+				min = i
+			}
+		}
+		merged = append(merged, parts[min][next[min]])
+		next[min]++
+	}
+	return merged
+}
+
+// mergeModels merges lists of models in ascending key order into one list.
+func mergeModels(parts [][]Model) []Model {
+	var n int
+	for _, part := range parts {
+		n += len(part)
+	}
+	if n == 0 {
+		return nil
+	}
+	merged := make([]Model, 0, n)
+	next := make([]int, len(parts))
+	for len(merged) < n {
+		min := -1
+		for i, part := range parts {
+			if next[i] < len(part) && (min < 0 || part[next[i]].GetID() < parts[min][next[min]].GetID()) {
+				min = i
+			}
+		}
+		merged = append(merged, parts[min][next[min]])
+		next[min]++
+	}
+	return merged
+}
+
+// shardName names the overflow file of a shard.
+func shardName(name string, i int) string {
+	return fmt.Sprintf("%s-%d", name, i)
+}
+
+var (
+	_ Table       = &shardedTable{}
+	_ replica     = &shardedTable{}
+	_ partitioned = &shardedTable{}
+)
@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func newTestTable(t testing.TB, opts ...Option) Table {
+	t.Helper()
+	d := NewDB(opts...)
+	if err := d.AddTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	table, err := d.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestShardedTable(t *testing.T) {
+	table := newTestTable(t, WithShards(4))
+	if _, ok := table.(*shardedTable); !ok {
+		t.Fatalf("table = %T, want *shardedTable", table)
+	}
+	for i := 0; i < 20; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := table.Update(&testModel{ID: 3, Data: "test1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Delete(4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Get(4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error = %v, wantErr %v", err, ErrNotFound)
+	}
+	got, err := table.Get(3)
+	if err != nil || got.(*testModel).Data != "test1" {
+		t.Errorf("got = %+v, %v, want row 3 updated", got, err)
+	}
+
+	models, err := table.Find(func(Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 19 {
+		t.Fatalf("len = %v, want %v", len(models), 19)
+	}
+	var scanned []PrimaryKey
+	if err = table.Scan(func(m Model) bool {
+		scanned = append(scanned, m.GetID())
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range models {
+		if i > 0 && models[i-1].GetID() >= models[i].GetID() {
+			t.Errorf("find not in key order: %v before %v", models[i-1].GetID(), models[i].GetID())
+		}
+		if scanned[i] != models[i].GetID() {
+			t.Errorf("scanned[%d] = %v, want %v", i, scanned[i], models[i].GetID())
+		}
+	}
+}
+
+func TestShardedTable_Batches(t *testing.T) {
+	table := newTestTable(t, WithShards(4))
+	if err := table.InsertMany([]Model{&testModel{}, &testModel{}, &testModel{}, &testModel{}}); err != nil {
+		t.Fatal(err)
+	}
+	err := table.UpdateMany([]Model{&testModel{ID: 1, Data: "test1"}, &testModel{ID: 9}})
+	assertRejectedRows(t, err, []int{1})
+	if got, _ := table.Get(1); got.(*testModel).Data != "" {
+		t.Errorf("rejected batch updated row 1")
+	}
+	n, err := table.UpdateWhere(func(m Model) bool { return m.GetID()%2 == 0 }, func(m Model) error {
+		m.(*testModel).Data = "even"
+		return nil
+	})
+	if err != nil || n != 2 {
+		t.Errorf("updated = %v, %v, want 2", n, err)
+	}
+	n, err = table.DeleteWhere(func(m Model) bool { return m.(*testModel).Data == "even" })
+	if err != nil || n != 2 {
+		t.Errorf("deleted = %v, %v, want 2", n, err)
+	}
+	if inserted, err := table.Upsert(&testModel{ID: 10}); err != nil || !inserted {
+		t.Errorf("upsert = %v, %v, want inserted", inserted, err)
+	}
+	m := &testModel{}
+	if err = table.Insert(m); err != nil || m.ID != 11 {
+		t.Errorf("insert id = %v, %v, want 11", m.ID, err)
+	}
+}
+
+func TestShardedTable_Concurrent(t *testing.T) {
+	table := newTestTable(t, WithShards(8))
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m := &testModel{Data: "test"}
+				if err := table.Insert(m); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := table.Get(m.ID); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	models, err := table.Find(func(Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range models {
+		if m.GetID() != PrimaryKey(i+1) {
+			t.Fatalf("models[%d] id = %v, want %v", i, m.GetID(), i+1)
+		}
+	}
+}
+
+func benchmarkInsert(b *testing.B, opts ...Option) {
+	table := newTestTable(b, opts...)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := table.Insert(&testModel{Data: "test"}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func benchmarkMixed(b *testing.B, opts ...Option) {
+	table := newTestTable(b, opts...)
+	for i := 0; i < 1000; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i PrimaryKey
+		for pb.Next() {
+			i++
+			key := i%1000 + 1
+			if i%4 == 0 {
+				if err := table.Update(&testModel{ID: key, Data: "test1"}); err != nil {
+					b.Error(err)
+					return
+				}
+			} else if _, err := table.Get(key); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkTable_Insert(b *testing.B) {
+	benchmarkInsert(b)
+}
+
+func BenchmarkShardedTable_Insert(b *testing.B) {
+	benchmarkInsert(b, WithShards(16))
+}
+
+func BenchmarkTable_Mixed(b *testing.B) {
+	benchmarkMixed(b)
+}
+
+func BenchmarkShardedTable_Mixed(b *testing.B) {
+	benchmarkMixed(b, WithShards(16))
+}
@@ -0,0 +1,366 @@
+package pkg
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// MemoryBudget limits the rows a database keeps in memory across all of its
+// tables. A zero limit is not enforced.
+type MemoryBudget struct {
+	// MaxRows is the number of rows kept in memory
+	MaxRows int
+	// MaxBytes is the estimated size in bytes of the rows kept in memory
+	MaxBytes int64
+	// Dir is the directory overflow files are written to, os.TempDir() if empty
+	Dir string
+}
+
+// SpillStats describes how a database with a memory budget uses its overflow files
+type SpillStats struct {
+	// Hits is the number of row reads served from memory
+	Hits int64
+	// Faults is the number of row reads served from an overflow file
+	Faults int64
+	// SpilledRows is the number of rows written to overflow files
+	SpilledRows int64
+	// SpilledBytes is the number of bytes written to overflow files
+	SpilledBytes int64
+	// Errors is the number of rows that could not be spilled and stayed in memory
+	Errors int64
+	// ResidentRows is the number of rows currently in memory
+	ResidentRows int
+	// ResidentBytes is the estimated size of the rows currently in memory
+	ResidentBytes int64
+	// OverflowRows is the number of rows currently in overflow files
+	OverflowRows int
+}
+
+// HitRate returns the fraction of row reads served from memory
+func (s SpillStats) HitRate() float64 {
+	if s.Hits+s.Faults == 0 {
+		return 1
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Faults)
+}
+
+// budget tracks the rows held in memory by every table of a database in least
+// recently used order. Locks are always taken table first, budget second.
+type budget struct {
+	mu     sync.Mutex
+	limits MemoryBudget
+	lru    *list.List
+	rows   map[*table]map[PrimaryKey]*list.Element
+	bytes  int64
+	stats  SpillStats
+}
+
+type residentRow struct {
+	t    *table
+	key  PrimaryKey
+	size int64
+}
+
+func newBudget(limits MemoryBudget) *budget {
+	return &budget{
+		limits: limits,
+		lru:    list.New(),
+		rows:   make(map[*table]map[PrimaryKey]*list.Element),
+	}
+}
+
+// track records that a row of t is in memory and was just used.
+func (b *budget) track(t *table, key PrimaryKey, m Model) {
+	if b == nil {
+		return
+	}
+	size := estimateSize(m)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trackLocked(t, key, size)
+}
+
+func (b *budget) trackLocked(t *table, key PrimaryKey, size int64) {
+	if b.rows[t] == nil {
+		b.rows[t] = make(map[PrimaryKey]*list.Element)
+	}
+	if e, ok := b.rows[t][key]; ok {
+		row := e.Value.(*residentRow)
+		b.bytes += size - row.size
+		row.size = size
+		b.lru.MoveToFront(e)
+		return
+	}
+	b.rows[t][key] = b.lru.PushFront(&residentRow{t: t, key: key, size: size})
+	b.bytes += size
+}
+
+// hit records a read of a row of t served from memory.
+func (b *budget) hit(t *table, key PrimaryKey) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Hits++
+	if e, ok := b.rows[t][key]; ok {
+		b.lru.MoveToFront(e)
+	}
+}
+
+// fault records a read of a row served from an overflow file.
+func (b *budget) fault() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Faults++
+}
+
+// forget stops tracking a row of t that is no longer in memory.
+func (b *budget) forget(t *table, key PrimaryKey) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.forgetLocked(t, key)
+}
+
+func (b *budget) forgetLocked(t *table, key PrimaryKey) {
+	e, ok := b.rows[t][key]
+	if !ok {
+		return
+	}
+	b.bytes -= e.Value.(*residentRow).size
+	b.lru.Remove(e)
+	delete(b.rows[t], key)
+}
+
+func (b *budget) overLocked() bool {
+	return (b.limits.MaxRows > 0 && b.lru.Len() > b.limits.MaxRows) ||
+		(b.limits.MaxBytes > 0 && b.bytes > b.limits.MaxBytes)
+}
+
+// enforce spills the least recently used rows until the budget is met. It
+// must be called without holding any table lock.
+func (b *budget) enforce() {
+	if b == nil {
+		return
+	}
+	for {
+		b.mu.Lock()
+		if !b.overLocked() {
+			b.mu.Unlock()
+			return
+		}
+		victim := *b.lru.Back().Value.(*residentRow)
+		b.mu.Unlock()
+		if !victim.t.spill(victim.key) {
+			return
+		}
+	}
+}
+
+func (b *budget) statsSnapshot() SpillStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.ResidentRows = b.lru.Len()
+	stats.ResidentBytes = b.bytes
+	return stats
+}
+
+// spill moves the row stored under key from memory to the overflow file. It
+// reports whether the budget should keep evicting.
+func (t *table) spill(key PrimaryKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.budget.mu.Lock()
+	if !t.budget.overLocked() {
+		t.budget.mu.Unlock()
+		return false
+	}
+	if e, ok := t.budget.rows[t][key]; !ok || e != t.budget.lru.Back() {
+		// the row was used or removed while the budget lock was released
+		t.budget.mu.Unlock()
+		return true
+	}
+	t.budget.forgetLocked(t, key)
+	t.budget.mu.Unlock()
+
+	n, err := t.overflow.write(key, t.data[key])
+	t.budget.mu.Lock()
+	defer t.budget.mu.Unlock()
+	if err != nil {
+		t.budget.stats.Errors++
+		t.budget.trackLocked(t, key, estimateSize(t.data[key]))
+		return false
+	}
+	delete(t.data, key)
+	t.budget.stats.SpilledRows++
+	t.budget.stats.SpilledBytes += int64(n)
+	return true
+}
+
+// overflow is the file holding the rows of a table that were spilled from
+// memory. Rows are appended as records; the space of rows read back is not
+// reused.
+type overflow struct {
+	file   *os.File
+	sealer *sealer
+	size   int64
+	index  map[PrimaryKey]overflowRef
+}
+
+// overflowRef locates the sealed bytes of a record, without its prefix, and
+// the row it holds.
+type overflowRef struct {
+	key    PrimaryKey
+	offset int64
+	length int
+}
+
+func newOverflow(dir, name string, keys *Keyring) (*overflow, error) {
+	s, err := keys.sealer(keys.ActiveKeyID())
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dir, name+"-*.overflow")
+	if err != nil {
+		return nil, fmt.Errorf("error creating overflow file: %w", err)
+	}
+	n, err := writeHeader(file, s.keyID)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("error writing overflow file: %w", err)
+	}
+	return &overflow{
+		file:   file,
+		sealer: s,
+		size:   int64(n),
+		index:  make(map[PrimaryKey]overflowRef),
+	}, nil
+}
+
+func (o *overflow) has(key PrimaryKey) bool {
+	if o == nil {
+		return false
+	}
+	_, ok := o.index[key]
+	return ok
+}
+
+func (o *overflow) len() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.index)
+}
+
+func (o *overflow) write(key PrimaryKey, m Model) (int, error) {
+	b, err := encodeModel(m)
+	if err != nil {
+		return 0, err
+	}
+	frame, err := frameRecord(o.sealer, b, key, o.size)
+	if err != nil {
+		return 0, err
+	}
+	n, err := o.file.WriteAt(frame, o.size)
+	if err != nil {
+		return 0, fmt.Errorf("error writing overflow file: %w", err)
+	}
+	o.index[key] = overflowRef{key: key, offset: o.size + recordPrefix, length: n - recordPrefix}
+	o.size += int64(n)
+	return n, nil
+}
+
+func (o *overflow) read(key PrimaryKey) (Model, error) {
+	return o.readRef(o.index[key])
+}
+
+// readRef reads a record, which need not be the current row of its key.
+func (o *overflow) readRef(ref overflowRef) (Model, error) {
+	sealed := make([]byte, ref.length)
+	if _, err := o.file.ReadAt(sealed, ref.offset); err != nil {
+		return nil, fmt.Errorf("error reading overflow file: %w", err)
+	}
+	b, err := o.sealer.open(sealed, ref.key, ref.offset)
+	if err != nil {
+		return nil, err
+	}
+	return decodeModel(b)
+}
+
+func (o *overflow) remove(key PrimaryKey) {
+	if o == nil {
+		return
+	}
+	delete(o.index, key)
+}
+
+func (o *overflow) close() error {
+	if o == nil {
+		return nil
+	}
+	if err := o.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(o.file.Name())
+}
+
+// estimateSize returns the approximate number of bytes a model occupies in memory.
+func estimateSize(m Model) int64 {
+	v := reflect.ValueOf(m)
+	if !v.IsValid() {
+		return 0
+	}
+	return int64(v.Type().Size()) + indirectSize(v, 0)
+}
+
+// indirectSize returns the size of the memory v refers to beyond its own value.
+func indirectSize(v reflect.Value, depth int) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Ptr, reflect.Interface:
+		// only the model itself is followed; pointers inside it, such as a
+		// time.Time location, usually refer to shared data
+		if v.IsNil() || depth > 0 {
+			return 0
+		}
+		return int64(v.Elem().Type().Size()) + indirectSize(v.Elem(), depth+1)
+	case reflect.Slice:
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), depth+1)
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		for i := 0; i < v.Len(); i++ {
+			size += indirectSize(v.Index(i), depth+1)
+		}
+		return size
+	case reflect.Map:
+		size := int64(v.Len()) * int64(v.Type().Key().Size()+v.Type().Elem().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			size += indirectSize(iter.Key(), depth+1) + indirectSize(iter.Value(), depth+1)
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += indirectSize(v.Field(i), depth+1)
+		}
+		return size
+	}
+	return 0
+}
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+func init() {
+	RegisterModel(&testModel{})
+}
+
+func newBudgetTable(t *testing.T, limits MemoryBudget) (DB, Table) {
+	t.Helper()
+	limits.Dir = t.TempDir()
+	d := NewDB(WithMemoryBudget(limits))
+	t.Cleanup(func() {
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	if err := d.AddTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	table, err := d.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, table
+}
+
+func TestSpill_MaxRows(t *testing.T) {
+	d, table := newBudgetTable(t, MemoryBudget{MaxRows: 2})
+	for i := 0; i < 5; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := d.SpillStats()
+	if stats.ResidentRows != 2 || stats.OverflowRows != 3 || stats.SpilledRows != 3 {
+		t.Fatalf("stats = %+v, want 2 resident and 3 spilled rows", stats)
+	}
+	if stats.SpilledBytes == 0 {
+		t.Errorf("spilled bytes = 0")
+	}
+
+	got, err := table.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.(*testModel).Data != "test" || got.GetID() != 1 {
+		t.Errorf("got = %+v", got)
+	}
+	stats = d.SpillStats()
+	if stats.Faults != 1 || stats.ResidentRows != 2 || stats.OverflowRows != 3 {
+		t.Errorf("stats = %+v, want 1 fault with 2 resident and 3 spilled rows", stats)
+	}
+
+	models, err := table.Find(func(Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 5 {
+		t.Fatalf("len = %v, want %v", len(models), 5)
+	}
+	for i, m := range models {
+		if m.GetID() != PrimaryKey(i+1) {
+			t.Errorf("models[%d] id = %v, want %v", i, m.GetID(), i+1)
+		}
+	}
+}
+
+func TestSpill_WritesToSpilledRows(t *testing.T) {
+	d, table := newBudgetTable(t, MemoryBudget{MaxRows: 1})
+	for i := 0; i < 3; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := table.Update(&testModel{ID: 1, Data: "test1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Delete(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Get(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("error = %v, wantErr %v", err, ErrNotFound)
+	}
+	got, err := table.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.(*testModel).Data != "test1" {
+		t.Errorf("data = %v, want %v", got.(*testModel).Data, "test1")
+	}
+	stats := d.SpillStats()
+	if stats.ResidentRows+stats.OverflowRows != 2 {
+		t.Errorf("stats = %+v, want 2 rows", stats)
+	}
+}
+
+func TestSpill_MaxBytes(t *testing.T) {
+	size := estimateSize(&testModel{Data: "test"})
+	d, table := newBudgetTable(t, MemoryBudget{MaxBytes: 3 * size})
+	for i := 0; i < 10; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := d.SpillStats()
+	if stats.ResidentBytes > 3*size || stats.ResidentRows != 3 {
+		t.Errorf("stats = %+v, want at most %d resident bytes", stats, 3*size)
+	}
+	var n int
+	if err := table.Scan(func(Model) bool { n++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("scanned = %v, want %v", n, 10)
+	}
+}
+
+func TestSpill_WriteError(t *testing.T) {
+	size := estimateSize(&testModel{Data: "test"})
+	d, table := newBudgetTable(t, MemoryBudget{MaxRows: 2})
+	// a read-only handle on the overflow file makes every spill fail
+	o := partsOf(table)[0].overflow
+	readOnly, err := os.Open(o.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.file.Close()
+	o.file = readOnly
+	for i := 0; i < 4; i++ {
+		if err := table.Insert(&testModel{Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := d.SpillStats()
+	if stats.Errors == 0 || stats.SpilledRows != 0 {
+		t.Errorf("stats = %+v, want errors and no spilled rows", stats)
+	}
+	// rows that could not be spilled stay in memory and count against it
+	if stats.ResidentRows != 4 || stats.ResidentBytes != 4*size {
+		t.Errorf("stats = %+v, want 4 resident rows of %d bytes", stats, 4*size)
+	}
+}
+
+func TestSpillStats_HitRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats SpillStats
+		want  float64
+	}{
+		{name: "no reads", want: 1},
+		{name: "all hits", stats: SpillStats{Hits: 4}, want: 1},
+		{name: "mixed", stats: SpillStats{Hits: 3, Faults: 1}, want: 0.75},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.stats.HitRate(); got != test.want {
+				t.Errorf("%s got = %v, want %v", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSpill_Concurrent(t *testing.T) {
+	limits := MemoryBudget{MaxRows: 10, Dir: t.TempDir()}
+	d := NewDB(WithMemoryBudget(limits))
+	defer d.Close()
+	var tables []Table
+	for _, name := range []string{"users", "groups"} {
+		if err := d.AddTable(name); err != nil {
+			t.Fatal(err)
+		}
+		table, _ := d.Table(name)
+		tables = append(tables, table)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(table Table) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				m := &testModel{Data: "test"}
+				if err := table.Insert(m); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := table.Get(m.ID / 2); err != nil && !errors.Is(err, ErrNotFound) {
+					t.Error(err)
+					return
+				}
+			}
+		}(tables[w%2])
+	}
+	wg.Wait()
+	stats := d.SpillStats()
+	if stats.ResidentRows > 10 || stats.ResidentRows+stats.OverflowRows != 400 {
+		t.Errorf("stats = %+v, want at most 10 of 400 rows resident", stats)
+	}
+}
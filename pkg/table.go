@@ -1,35 +1,121 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"sort"
+	"strings"
+	"sync"
 )
 
 var (
 	ErrNotFound     = fmt.Errorf("not found")
 	ErrAlreadyHasID = fmt.Errorf("already has an ID")
+	ErrKeyChanged   = fmt.Errorf("primary key changed")
+	ErrNoID         = fmt.Errorf("no ID provided")
 )
 
-// Table is the interface that all tables must implement
-type Table interface {
+// Reader is the read-only part of a table
+type Reader interface {
 	// Name returns the name of the table
 	Name() string
+	// Get returns a model from the database by its primary key
+	Get(PrimaryKey) (Model, error)
+	// Find returns a slice of models from the database that match the given function.
+	Find(func(Model) bool) ([]Model, error)
+	// Scan calls the given function for each model in primary key order.
+	// It stops as soon as the function returns false.
+	Scan(func(Model) bool) error
+}
+
+// Table is the interface that all tables must implement.
+// Tables keep their own copy of every model: changing a model after writing
+// it, or one returned by a read, does not change the stored row.
+type Table interface {
+	Reader
 	// Insert inserts a new model into the database
 	Insert(Model) error
 	// Update updates an existing model in the database
 	Update(Model) error
 	// Delete deletes an existing model from the database
 	Delete(PrimaryKey) error
-	// Get returns a model from the database by its primary key
-	Get(PrimaryKey) (Model, error)
-	// Find returns a slice of models from the database that match the given function.
-	Find(func(Model) bool) ([]Model, error)
+	// InsertMany inserts all the models or, if any of them is rejected, none of them.
+	InsertMany([]Model) error
+	// UpdateMany updates all the models or, if any of them is rejected, none of them.
+	UpdateMany([]Model) error
+	// UpdateWhere applies the update function to a copy of every model that
+	// matches the given function and stores the copies, all or nothing.
+	// It returns the number of updated models.
+	UpdateWhere(match func(Model) bool, update func(Model) error) (int, error)
+	// DeleteWhere deletes every model that matches the given function and
+	// returns the number of deleted models.
+	DeleteWhere(func(Model) bool) (int, error)
+	// Upsert stores the model under its own primary key, inserting it if the
+	// key is absent and replacing the stored model otherwise. It reports
+	// whether the model was inserted.
+	Upsert(Model) (bool, error)
+	// CompareAndSwap replaces the model stored under key with new only if the
+	// stored model still equals expected. It reports whether the swap happened.
+	CompareAndSwap(key PrimaryKey, expected, new Model) (bool, error)
+}
+
+// RowError describes why a single row of a batch was rejected.
+type RowError struct {
+	// Index is the position of the row in the batch. For predicate based
+	// batches it counts the matching rows in primary key order.
+	Index int
+	Key   PrimaryKey
+	Err   error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d (id %d): %s", e.Index, e.Key, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned when a batch is rejected. None of the batch has been
+// written; Rows holds one entry for every row that failed validation.
+type BatchError struct {
+	Rows []*RowError
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Rows))
+	for i, row := range e.Rows {
+		msgs[i] = row.Error()
+	}
+	return fmt.Sprintf("batch rejected: %s", strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the rejected rows failed with target.
+func (e *BatchError) Is(target error) bool {
+	for _, row := range e.Rows {
+		if errors.Is(row.Err, target) {
+			return true
+		}
+	}
+	return false
 }
 
 type table struct {
+	mu     sync.RWMutex
 	name   string
 	lastID PrimaryKey
 	data   map[PrimaryKey]Model
+	// budget and overflow are set when the database has a memory budget
+	budget   *budget
+	overflow *overflow
+	// log receives the changes made by each write, collected in pending
+	log     *changeLog
+	pending []pendingChange
+	// versions holds the sequence number of the change that wrote each row
+	// and history the versions replaced while snapshots were open
+	versions map[PrimaryKey]uint64
+	history  map[PrimaryKey][]version
 }
 
 func (t *table) Name() string {
@@ -37,49 +123,553 @@ func (t *table) Name() string {
 }
 
 func (t *table) Insert(model Model) error {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
 	if model.GetID() != 0 {
 		return ErrAlreadyHasID
 	}
+	if err := Validate(model); err != nil {
+		return err
+	}
 	t.lastID++
 	model.SetID(t.lastID)
-	t.data[t.lastID] = model
+	t.store(t.lastID, clone(model))
 	return nil
 }
 
 func (t *table) Update(model Model) error {
-	if _, ok := t.data[model.GetID()]; !ok {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	if !t.has(model.GetID()) {
 		return ErrNotFound
 	}
-	t.data[model.GetID()] = model
+	if err := Validate(model); err != nil {
+		return err
+	}
+	t.store(model.GetID(), clone(model))
 	return nil
 }
 
 func (t *table) Delete(key PrimaryKey) error {
-	if _, ok := t.data[key]; !ok {
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	if !t.has(key) {
 		return ErrNotFound
 	}
-	delete(t.data, key)
+	t.remove(key)
 	return nil
 }
 
 func (t *table) Get(key PrimaryKey) (Model, error) {
-	if model, ok := t.data[key]; ok {
-		return model, nil
+	if t.overflow != nil {
+		// reading a spilled row brings it back into memory
+		defer t.budget.enforce()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	} else {
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+	}
+	model, ok, err := t.fetch(key)
+	if err != nil {
+		return nil, err
 	}
-	return nil, ErrNotFound
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(model), nil
 }
 
 func (t *table) Find(f func(Model) bool) ([]Model, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	var models []Model
-	for _, model := range t.data {
+	for _, key := range t.keys() {
+		model, _, err := t.load(key)
+		if err != nil {
+			return nil, err
+		}
 		if f(model) {
-			models = append(models, model)
+			models = append(models, clone(model))
 		}
 	}
-	sort.Slice(models, func(i, j int) bool {
-		return models[i].GetID() < models[j].GetID()
-	})
 	return models, nil
 }
 
-var _ Table = &table{}
+func (t *table) Scan(f func(Model) bool) error {
+	for _, key := range t.sortedKeys() {
+		model, ok, err := t.read(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if !f(clone(model)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (t *table) InsertMany(models []Model) error {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	return insertMany(t.rows(), models, func() PrimaryKey {
+		t.lastID++
+		return t.lastID
+	})
+}
+
+func (t *table) UpdateMany(models []Model) error {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	return updateMany(t.rows(), models)
+}
+
+func (t *table) UpdateWhere(match func(Model) bool, update func(Model) error) (int, error) {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	return updateWhere(t.rows(), match, update)
+}
+
+func (t *table) DeleteWhere(f func(Model) bool) (int, error) {
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	return deleteWhere(t.rows(), f)
+}
+
+func (t *table) Upsert(model Model) (bool, error) {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	key := model.GetID()
+	if key == 0 {
+		return false, ErrNoID
+	}
+	if err := Validate(model); err != nil {
+		return false, err
+	}
+	exists := t.has(key)
+	t.store(key, clone(model))
+	if key > t.lastID {
+		t.lastID = key
+	}
+	return !exists, nil
+}
+
+func (t *table) CompareAndSwap(key PrimaryKey, expected, new Model) (bool, error) {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	stored, ok, err := t.load(key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrNotFound
+	}
+	if new.GetID() == 0 {
+		new.SetID(key)
+	}
+	if new.GetID() != key {
+		return false, ErrKeyChanged
+	}
+	if err := Validate(new); err != nil {
+		return false, err
+	}
+	if !reflect.DeepEqual(stored, expected) {
+		return false, nil
+	}
+	t.store(key, clone(new))
+	return true, nil
+}
+
+// The helpers below hide where a row lives. Callers must hold the lock, and
+// the write lock for store, remove and fetch.
+
+// has reports whether a row is stored under key.
+func (t *table) has(key PrimaryKey) bool {
+	if _, ok := t.data[key]; ok {
+		return true
+	}
+	return t.overflow.has(key)
+}
+
+// load returns the row stored under key, reading spilled rows from the
+// overflow file without bringing them back into memory.
+func (t *table) load(key PrimaryKey) (Model, bool, error) {
+	if model, ok := t.data[key]; ok {
+		t.budget.hit(t, key)
+		return model, true, nil
+	}
+	if !t.overflow.has(key) {
+		return nil, false, nil
+	}
+	model, err := t.overflow.read(key)
+	if err != nil {
+		return nil, false, err
+	}
+	t.budget.fault()
+	return model, true, nil
+}
+
+// fetch returns the row stored under key and keeps it in memory.
+func (t *table) fetch(key PrimaryKey) (Model, bool, error) {
+	model, ok, err := t.load(key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if t.overflow.has(key) {
+		t.keep(key, model)
+	}
+	return model, true, nil
+}
+
+// store writes a row under key.
+func (t *table) store(key PrimaryKey, model Model) {
+	prev := t.previous(key)
+	t.keep(key, model)
+	if t.log != nil {
+		t.pending = append(t.pending, pendingChange{
+			Change: Change{Table: t.name, Op: OpPut, Key: key, Model: model},
+			prev:   prev,
+		})
+	}
+}
+
+// remove deletes the row stored under key.
+func (t *table) remove(key PrimaryKey) {
+	prev := t.previous(key)
+	delete(t.data, key)
+	t.overflow.remove(key)
+	t.budget.forget(t, key)
+	if t.log != nil {
+		t.pending = append(t.pending, pendingChange{
+			Change: Change{Table: t.name, Op: OpDelete, Key: key},
+			prev:   prev,
+		})
+	}
+}
+
+// keep holds a row in memory under key, replacing any spilled copy.
+func (t *table) keep(key PrimaryKey, model Model) {
+	t.data[key] = model
+	t.overflow.remove(key)
+	t.budget.track(t, key, model)
+}
+
+// refreshViews reduces the groups of the views computed from the table that
+// its writes left stale. It is deferred before the table is locked, so it
+// runs once the lock is released.
+func (t *table) refreshViews() {
+	t.log.refresh(t.name)
+}
+
+// publish hands the changes made by the current write to the change log and
+// records the version of each row it wrote.
+func (t *table) publish() {
+	publish([]*table{t})
+}
+
+// publish hands the changes made to tables by the current write to the change
+// log as one batch, so that snapshots see all of them or none. The caller
+// must hold every lock.
+func publish(tables []*table) {
+	var changes []Change
+	for _, t := range tables {
+		for _, p := range t.pending {
+			changes = append(changes, p.Change)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	oldest, open := tables[0].log.append(changes)
+	for _, t := range tables {
+		if t.versions == nil && len(t.pending) > 0 {
+			t.versions = make(map[PrimaryKey]uint64)
+		}
+		for _, p := range t.pending {
+			key, seq := p.Key, changes[0].Seq
+			changes = changes[1:]
+			if open && p.prev != nil {
+				p.prev.from = t.versions[key]
+				p.prev.to = seq
+				t.keepVersion(key, *p.prev, oldest)
+			} else if !open {
+				delete(t.history, key)
+			}
+			if p.Op == OpPut {
+				t.versions[key] = seq
+			} else {
+				delete(t.versions, key)
+			}
+		}
+		t.pending = nil
+	}
+}
+
+// apply writes a change received from another database, keeping its key.
+func (t *table) apply(c Change) {
+	defer t.budget.enforce()
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.publish()
+	switch c.Op {
+	case OpPut:
+		t.store(c.Key, c.Model)
+		if c.Key > t.lastID {
+			t.lastID = c.Key
+		}
+	case OpDelete:
+		if t.has(c.Key) {
+			t.remove(c.Key)
+		}
+	}
+}
+
+// reset removes every row and sets the last assigned ID without recording
+// changes, before a copy of another database is loaded.
+func (t *table) reset(lastID PrimaryKey) {
+	defer t.refreshViews()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.data {
+		t.budget.forget(t, key)
+	}
+	t.data = make(map[PrimaryKey]Model)
+	if t.overflow != nil {
+		t.overflow.index = make(map[PrimaryKey]overflowRef)
+	}
+	t.versions = nil
+	t.history = nil
+	t.lastID = lastID
+	t.log.clear(t.name)
+}
+
+// len returns the number of rows of the table.
+func (t *table) len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.data) + t.overflow.len()
+}
+
+func (t *table) lastAssignedID() PrimaryKey {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.lastID
+}
+
+func (t *table) parts() []*table {
+	return []*table{t}
+}
+
+func (t *table) route(PrimaryKey) *table {
+	return t
+}
+
+// rows returns the table as a row set. The caller must hold the lock.
+func (t *table) rows() rowSet {
+	return rowSet{
+		tables: []*table{t},
+		route:  func(PrimaryKey) *table { return t },
+	}
+}
+
+// sortedKeys returns the primary keys of the table in ascending order.
+func (t *table) sortedKeys() []PrimaryKey {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.keys()
+}
+
+// read returns the row stored under key without keeping the lock.
+func (t *table) read(key PrimaryKey) (Model, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.load(key)
+}
+
+// rowSet addresses the rows of one or more tables, whose locks are held, as
+// a single table so that plain and sharded tables share their batch writes.
+type rowSet struct {
+	tables []*table
+	route  func(PrimaryKey) *table
+}
+
+func (r rowSet) has(key PrimaryKey) bool {
+	return r.route(key).has(key)
+}
+
+func (r rowSet) load(key PrimaryKey) (Model, bool, error) {
+	return r.route(key).load(key)
+}
+
+func (r rowSet) store(key PrimaryKey, model Model) {
+	r.route(key).store(key, model)
+}
+
+func (r rowSet) remove(key PrimaryKey) {
+	r.route(key).remove(key)
+}
+
+func (r rowSet) keys() []PrimaryKey {
+	if len(r.tables) == 1 {
+		return r.tables[0].keys()
+	}
+	parts := make([][]PrimaryKey, len(r.tables))
+	for i, t := range r.tables {
+		parts[i] = t.keys()
+	}
+	return mergeKeys(parts)
+}
+
+func insertMany(r rowSet, models []Model, nextID func() PrimaryKey) error {
+	var rejected []*RowError
+	for i, model := range models {
+		if model.GetID() != 0 {
+			rejected = append(rejected, &RowError{Index: i, Key: model.GetID(), Err: ErrAlreadyHasID})
+		} else if err := Validate(model); err != nil {
+			rejected = append(rejected, &RowError{Index: i, Err: err})
+		}
+	}
+	if len(rejected) > 0 {
+		return &BatchError{Rows: rejected}
+	}
+	for _, model := range models {
+		model.SetID(nextID())
+		r.store(model.GetID(), clone(model))
+	}
+	return nil
+}
+
+func updateMany(r rowSet, models []Model) error {
+	var rejected []*RowError
+	for i, model := range models {
+		if !r.has(model.GetID()) {
+			rejected = append(rejected, &RowError{Index: i, Key: model.GetID(), Err: ErrNotFound})
+		} else if err := Validate(model); err != nil {
+			rejected = append(rejected, &RowError{Index: i, Key: model.GetID(), Err: err})
+		}
+	}
+	if len(rejected) > 0 {
+		return &BatchError{Rows: rejected}
+	}
+	for _, model := range models {
+		r.store(model.GetID(), clone(model))
+	}
+	return nil
+}
+
+func updateWhere(r rowSet, match func(Model) bool, update func(Model) error) (int, error) {
+	var updated []Model
+	var rejected []*RowError
+	for _, key := range r.keys() {
+		stored, _, err := r.load(key)
+		if err != nil {
+			return 0, err
+		}
+		if !match(stored) {
+			continue
+		}
+		i := len(updated) + len(rejected)
+		model := clone(stored)
+		if err := update(model); err != nil {
+			rejected = append(rejected, &RowError{Index: i, Key: key, Err: err})
+			continue
+		}
+		if model.GetID() != key {
+			rejected = append(rejected, &RowError{Index: i, Key: key, Err: ErrKeyChanged})
+			continue
+		}
+		if err := Validate(model); err != nil {
+			rejected = append(rejected, &RowError{Index: i, Key: key, Err: err})
+			continue
+		}
+		updated = append(updated, model)
+	}
+	if len(rejected) > 0 {
+		return 0, &BatchError{Rows: rejected}
+	}
+	for _, model := range updated {
+		r.store(model.GetID(), model)
+	}
+	return len(updated), nil
+}
+
+func deleteWhere(r rowSet, f func(Model) bool) (int, error) {
+	var matched []PrimaryKey
+	for _, key := range r.keys() {
+		model, _, err := r.load(key)
+		if err != nil {
+			return 0, err
+		}
+		if f(model) {
+			matched = append(matched, key)
+		}
+	}
+	for _, key := range matched {
+		r.remove(key)
+	}
+	return len(matched), nil
+}
+
+// keys returns the primary keys of the table in ascending order.
+func (t *table) keys() []PrimaryKey {
+	keys := make([]PrimaryKey, 0, len(t.data)+t.overflow.len())
+	for key := range t.data {
+		keys = append(keys, key)
+	}
+	if t.overflow != nil {
+		for key := range t.overflow.index {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// clone returns a shallow copy of a model so it can be changed without
+// touching the stored row.
+func clone(model Model) Model {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return model
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface().(Model)
+}
+
+var (
+	_ Table       = &table{}
+	_ replica     = &table{}
+	_ partitioned = &table{}
+)
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"errors"
+	"sync"
 	"testing"
 )
 
@@ -240,3 +241,439 @@ func TestTable_Name(t *testing.T) {
 		t.Errorf("name = %v, want %v", table.Name(), "users")
 	}
 }
+
+func TestTable_Scan(t *testing.T) {
+	tests := []struct {
+		name    string
+		stopAt  PrimaryKey
+		want    []PrimaryKey
+		wantErr error
+	}{
+		{
+			name: "scan all",
+			want: []PrimaryKey{1, 2, 3},
+		},
+		{
+			name:   "stop early",
+			stopAt: 2,
+			want:   []PrimaryKey{1, 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := &table{
+				name: "users",
+				data: map[PrimaryKey]Model{
+					3: &testModel{ID: 3, Data: "test"},
+					1: &testModel{ID: 1, Data: "test"},
+					2: &testModel{ID: 2, Data: "test"},
+				},
+			}
+			var got []PrimaryKey
+			err := table.Scan(func(m Model) bool {
+				got = append(got, m.GetID())
+				return m.GetID() != test.stopAt
+			})
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s error = %v, wantErr %v", test.name, err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("%s got = %v, want %v", test.name, got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("%s got = %v, want %v", test.name, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTable_InsertMany(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        []Model
+		wantErr  error
+		wantRows []int
+	}{
+		{
+			name:     "one already has id",
+			v:        []Model{&testModel{}, &testModel{ID: 7}, &testModel{}},
+			wantErr:  ErrAlreadyHasID,
+			wantRows: []int{1},
+		},
+		{
+			name: "insert many",
+			v:    []Model{&testModel{}, &testModel{}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := &table{
+				name: "users",
+				data: make(map[PrimaryKey]Model),
+			}
+			err := table.InsertMany(test.v)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s error = %v, wantErr %v", test.name, err, test.wantErr)
+			}
+			if err != nil {
+				assertRejectedRows(t, err, test.wantRows)
+				if len(table.data) != 0 {
+					t.Errorf("%s rejected batch wrote %d rows", test.name, len(table.data))
+				}
+				return
+			}
+			for i, v := range test.v {
+				if v.GetID() != PrimaryKey(i+1) {
+					t.Errorf("%s id = %v, want %v", test.name, v.GetID(), i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestTable_UpdateMany(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        []Model
+		wantErr  error
+		wantRows []int
+	}{
+		{
+			name:     "one not found",
+			v:        []Model{&testModel{ID: 1, Data: "test1"}, &testModel{ID: 3, Data: "test1"}},
+			wantErr:  ErrNotFound,
+			wantRows: []int{1},
+		},
+		{
+			name: "update many",
+			v:    []Model{&testModel{ID: 1, Data: "test1"}, &testModel{ID: 2, Data: "test1"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := &table{
+				name: "users",
+				data: map[PrimaryKey]Model{
+					1: &testModel{ID: 1, Data: "test"},
+					2: &testModel{ID: 2, Data: "test"},
+				},
+			}
+			err := table.UpdateMany(test.v)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s error = %v, wantErr %v", test.name, err, test.wantErr)
+			}
+			want := "test1"
+			if err != nil {
+				assertRejectedRows(t, err, test.wantRows)
+				want = "test"
+			}
+			for key, m := range table.data {
+				if m.(*testModel).Data != want {
+					t.Errorf("%s row %d data = %v, want %v", test.name, key, m.(*testModel).Data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTable_UpdateWhere(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	tests := []struct {
+		name     string
+		update   func(Model) error
+		want     int
+		wantErr  error
+		wantRows []int
+	}{
+		{
+			name: "update matching",
+			update: func(m Model) error {
+				m.(*testModel).Data = "test1"
+				return nil
+			},
+			want: 2,
+		},
+		{
+			name: "update rejected",
+			update: func(m Model) error {
+				m.(*testModel).Data = "test1"
+				if m.GetID() == 3 {
+					return errInvalid
+				}
+				return nil
+			},
+			wantErr:  errInvalid,
+			wantRows: []int{1},
+		},
+		{
+			name: "key changed",
+			update: func(m Model) error {
+				m.SetID(m.GetID() + 10)
+				return nil
+			},
+			wantErr:  ErrKeyChanged,
+			wantRows: []int{0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := &table{
+				name: "users",
+				data: map[PrimaryKey]Model{
+					1: &testModel{ID: 1, Data: "test"},
+					2: &testModel{ID: 2, Data: "other"},
+					3: &testModel{ID: 3, Data: "test"},
+				},
+			}
+			got, err := table.UpdateWhere(func(m Model) bool {
+				return m.(*testModel).Data == "test"
+			}, test.update)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s error = %v, wantErr %v", test.name, err, test.wantErr)
+			}
+			if err != nil {
+				assertRejectedRows(t, err, test.wantRows)
+				for key, m := range table.data {
+					if m.GetID() != key || m.(*testModel).Data == "test1" {
+						t.Errorf("%s rejected batch changed row %d", test.name, key)
+					}
+				}
+				return
+			}
+			if got != test.want {
+				t.Errorf("%s got = %v, want %v", test.name, got, test.want)
+			}
+			if table.data[1].(*testModel).Data != "test1" || table.data[2].(*testModel).Data != "other" {
+				t.Errorf("%s data not updated", test.name)
+			}
+		})
+	}
+}
+
+func TestTable_DeleteWhere(t *testing.T) {
+	table := &table{
+		name: "users",
+		data: map[PrimaryKey]Model{
+			1: &testModel{ID: 1, Data: "test"},
+			2: &testModel{ID: 2, Data: "other"},
+			3: &testModel{ID: 3, Data: "test"},
+		},
+	}
+	got, err := table.DeleteWhere(func(m Model) bool {
+		return m.(*testModel).Data == "test"
+	})
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	if got != 2 {
+		t.Errorf("deleted = %v, want %v", got, 2)
+	}
+	if _, ok := table.data[2]; !ok || len(table.data) != 1 {
+		t.Errorf("data = %v, want only row 2", table.data)
+	}
+}
+
+func assertRejectedRows(t *testing.T, err error, want []int) {
+	t.Helper()
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("error = %v, want a *BatchError", err)
+	}
+	if len(batchErr.Rows) != len(want) {
+		t.Fatalf("rejected rows = %v, want %v", batchErr.Rows, want)
+	}
+	for i, row := range batchErr.Rows {
+		if row.Index != want[i] {
+			t.Errorf("rejected row %d index = %v, want %v", i, row.Index, want[i])
+		}
+	}
+}
+
+func TestTable_Upsert(t *testing.T) {
+	tests := []struct {
+		name         string
+		v            Model
+		wantInserted bool
+		wantErr      error
+		wantLastID   PrimaryKey
+	}{
+		{
+			name:    "no id",
+			v:       &testModel{},
+			wantErr: ErrNoID,
+		},
+		{
+			name:       "update",
+			v:          &testModel{ID: 1, Data: "test1"},
+			wantLastID: 2,
+		},
+		{
+			name:         "insert",
+			v:            &testModel{ID: 5, Data: "test1"},
+			wantInserted: true,
+			wantLastID:   5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := &table{
+				name:   "users",
+				lastID: 2,
+				data: map[PrimaryKey]Model{
+					1: &testModel{ID: 1, Data: "test"},
+					2: &testModel{ID: 2, Data: "test"},
+				},
+			}
+			inserted, err := table.Upsert(test.v)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s error = %v, wantErr %v", test.name, err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if inserted != test.wantInserted {
+				t.Errorf("%s inserted = %v, want %v", test.name, inserted, test.wantInserted)
+			}
+			if table.data[test.v.GetID()].(*testModel).Data != "test1" {
+				t.Errorf("%s data not stored", test.name)
+			}
+			if table.lastID != test.wantLastID {
+				t.Errorf("%s lastID = %v, want %v", test.name, table.lastID, test.wantLastID)
+			}
+		})
+	}
+}
+
+func TestTable_CompareAndSwap(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         PrimaryKey
+		expected    Model
+		new         Model
+		wantSwapped bool
+		wantErr     error
+	}{
+		{
+			name:     "not found",
+			key:      3,
+			expected: &testModel{ID: 3},
+			new:      &testModel{ID: 3},
+			wantErr:  ErrNotFound,
+		},
+		{
+			name:     "key changed",
+			key:      1,
+			expected: &testModel{ID: 1, Data: "test"},
+			new:      &testModel{ID: 2},
+			wantErr:  ErrKeyChanged,
+		},
+		{
+			name:     "stale expected",
+			key:      1,
+			expected: &testModel{ID: 1, Data: "stale"},
+			new:      &testModel{ID: 1, Data: "test1"},
+		},
+		{
+			name:        "swap",
+			key:         1,
+			expected:    &testModel{ID: 1, Data: "test"},
+			new:         &testModel{Data: "test1"},
+			wantSwapped: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := &table{
+				name: "users",
+				data: map[PrimaryKey]Model{
+					1: &testModel{ID: 1, Data: "test"},
+				},
+			}
+			swapped, err := table.CompareAndSwap(test.key, test.expected, test.new)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s error = %v, wantErr %v", test.name, err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if swapped != test.wantSwapped {
+				t.Errorf("%s swapped = %v, want %v", test.name, swapped, test.wantSwapped)
+			}
+			want := "test"
+			if test.wantSwapped {
+				want = "test1"
+			}
+			if table.data[1].(*testModel).Data != want {
+				t.Errorf("%s data = %v, want %v", test.name, table.data[1].(*testModel).Data, want)
+			}
+		})
+	}
+}
+
+func TestTable_CompareAndSwapConcurrent(t *testing.T) {
+	table := &table{
+		name: "users",
+		data: map[PrimaryKey]Model{
+			1: &testModel{ID: 1, Data: ""},
+		},
+	}
+	const workers, increments = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				current, err := table.Get(1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				next := &testModel{ID: 1, Data: current.(*testModel).Data + "x"}
+				swapped, err := table.CompareAndSwap(1, current, next)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if swapped {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(table.data[1].(*testModel).Data); got != workers*increments {
+		t.Errorf("len = %v, want %v", got, workers*increments)
+	}
+}
+
+func TestTable_ReturnsCopies(t *testing.T) {
+	table := &table{
+		name: "users",
+		data: make(map[PrimaryKey]Model),
+	}
+	m := &testModel{Data: "test"}
+	if err := table.Insert(m); err != nil {
+		t.Fatal(err)
+	}
+	m.Data = "changed"
+	got, err := table.Get(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.(*testModel).Data = "changed"
+	if table.data[m.ID].(*testModel).Data != "test" {
+		t.Errorf("stored data = %v, want %v", table.data[m.ID].(*testModel).Data, "test")
+	}
+}
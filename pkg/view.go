@@ -0,0 +1,305 @@
+package pkg
+
+import (
+	"sort"
+	"sync"
+)
+
+// View defines a read-only table derived from source tables. Every source
+// row is put in the groups Group returns for it, and the view holds a row
+// per group, computed by Reduce from the rows of the group. The view is kept
+// up to date as the source tables are written, recomputing only the groups
+// whose rows changed, so reading it costs no more than reading a table.
+type View struct {
+	// Sources are the names of the tables the view is computed from
+	Sources []string
+	// Group returns the primary keys, in the view, of the groups a row of a
+	// source table belongs to
+	Group func(table string, m Model) []PrimaryKey
+	// Reduce returns the row of the view stored under key, computed from
+	// the rows of its group, or nil to store none. The rows are ordered by
+	// source and then by primary key and must not be changed.
+	Reduce func(key PrimaryKey, rows []Model) Model
+}
+
+// sourceRow addresses a row of a source table of a view.
+type sourceRow struct {
+	source int
+	key    PrimaryKey
+}
+
+// view is the table holding the rows of a View. Its groups are maintained by
+// the change log, with the lock of the table written held, so it sees the
+// writes to a row in the order they were made. Reducing a group reads the
+// other parts of the sources too, so the writer reduces the groups it
+// changed once it released its lock, before the write returns, and reads
+// never reduce.
+type view struct {
+	mu      sync.RWMutex
+	name    string
+	def     View
+	sources map[string]int
+	// tables holds the source tables by index, and parts their plain tables
+	// in the order they are locked
+	tables []partitioned
+	parts  []*table
+	// groups holds the rows of each group and members the groups of each
+	// source row
+	groups  map[PrimaryKey]map[sourceRow]struct{}
+	members map[sourceRow][]PrimaryKey
+	// stale holds the groups whose row in data must be recomputed
+	stale map[PrimaryKey]struct{}
+	data  map[PrimaryKey]Model
+}
+
+func newView(name string, def View) *view {
+	v := &view{
+		name:    name,
+		def:     def,
+		sources: make(map[string]int, len(def.Sources)),
+		tables:  make([]partitioned, len(def.Sources)),
+		groups:  make(map[PrimaryKey]map[sourceRow]struct{}),
+		members: make(map[sourceRow][]PrimaryKey),
+		stale:   make(map[PrimaryKey]struct{}),
+		data:    make(map[PrimaryKey]Model),
+	}
+	for i, source := range def.Sources {
+		v.sources[source] = i
+	}
+	return v
+}
+
+// AddView adds a table named name holding the rows of the view def. Its rows
+// are computed from the rows already in the sources, which must exist, and
+// kept up to date as they change. The table rejects writes with ErrReadOnly.
+// Views are not part of snapshots and are not replicated; a follower that
+// needs one adds it to its own database.
+func (d *db) AddView(name string, def View) error {
+	if name == "" {
+		return ErrorNoTableName
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.tables[name]; ok {
+		return ErrTableExists
+	}
+	v, err := loadView(name, def, d.tables)
+	if err != nil {
+		return err
+	}
+	d.log.addView(v)
+	d.tables[name] = &readOnlyTable{v}
+	return nil
+}
+
+// loadView returns the view def named name computed from the rows of its
+// sources, found in tables.
+func loadView(name string, def View, tables map[string]Table) (*view, error) {
+	sources := make([]string, len(def.Sources))
+	copy(sources, def.Sources)
+	// sources are locked in name order, so views added at the same time
+	// cannot deadlock
+	sort.Strings(sources)
+	var parts []*table
+	for _, source := range sources {
+		t, ok := tables[source]
+		if !ok {
+			return nil, ErrorNoTable
+		}
+		parts = append(parts, partsOf(t)...)
+	}
+	for _, part := range parts {
+		part.mu.Lock()
+		defer part.mu.Unlock()
+	}
+	v := newView(name, def)
+	v.parts = parts
+	for i, source := range def.Sources {
+		v.tables[i], _ = tables[source].(partitioned)
+	}
+	for _, part := range parts {
+		for _, key := range part.keys() {
+			model, ok, err := part.load(key)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				v.put(part.name, key, model)
+			}
+		}
+	}
+	if err := v.reduce(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// apply updates the groups of the row a change wrote.
+func (v *view) apply(c Change) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c.Op == OpPut {
+		v.put(c.Table, c.Key, c.Model)
+	} else {
+		v.remove(c.Table, c.Key)
+	}
+}
+
+// put adds a source row to its groups, removing it from the groups it no
+// longer belongs to.
+func (v *view) put(table string, key PrimaryKey, model Model) {
+	row := sourceRow{v.sources[table], key}
+	v.leave(row)
+	groups := v.def.Group(table, model)
+	for _, group := range groups {
+		rows, ok := v.groups[group]
+		if !ok {
+			rows = make(map[sourceRow]struct{})
+			v.groups[group] = rows
+		}
+		rows[row] = struct{}{}
+		v.stale[group] = struct{}{}
+	}
+	if len(groups) > 0 {
+		v.members[row] = groups
+	}
+}
+
+// remove removes a source row from its groups.
+func (v *view) remove(table string, key PrimaryKey) {
+	v.leave(sourceRow{v.sources[table], key})
+}
+
+// clear removes every row of a source table from its groups.
+func (v *view) clear(table string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	source := v.sources[table]
+	for row := range v.members {
+		if row.source == source {
+			v.leave(row)
+		}
+	}
+}
+
+// leave removes a source row from its groups.
+func (v *view) leave(row sourceRow) {
+	for _, group := range v.members[row] {
+		delete(v.groups[group], row)
+		if len(v.groups[group]) == 0 {
+			delete(v.groups, group)
+		}
+		v.stale[group] = struct{}{}
+	}
+	delete(v.members, row)
+}
+
+// refresh recomputes the stale groups. The source tables are locked before
+// the view, as writers do, so no row of a group changes while it is reduced.
+// It must be called without holding any table lock.
+func (v *view) refresh() error {
+	v.mu.RLock()
+	fresh := len(v.stale) == 0
+	v.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+	for _, part := range v.parts {
+		part.mu.RLock()
+		defer part.mu.RUnlock()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.reduce()
+}
+
+// reduce recomputes the view rows of the stale groups from the rows of the
+// source tables, which must be locked.
+func (v *view) reduce() error {
+	for group := range v.stale {
+		members := v.groups[group]
+		if len(members) == 0 {
+			delete(v.data, group)
+			delete(v.stale, group)
+			continue
+		}
+		order := make([]sourceRow, 0, len(members))
+		for row := range members {
+			order = append(order, row)
+		}
+		sort.Slice(order, func(i, j int) bool {
+			if order[i].source != order[j].source {
+				return order[i].source < order[j].source
+			}
+			return order[i].key < order[j].key
+		})
+		rows := make([]Model, 0, len(order))
+		for _, row := range order {
+			model, ok, err := v.tables[row.source].route(row.key).load(row.key)
+			if err != nil {
+				return err
+			}
+			if ok {
+				rows = append(rows, model)
+			}
+		}
+		delete(v.stale, group)
+		var model Model
+		if len(rows) > 0 {
+			model = v.def.Reduce(group, rows)
+		}
+		if model == nil {
+			delete(v.data, group)
+			continue
+		}
+		model = clone(model)
+		model.SetID(group)
+		v.data[group] = model
+	}
+	return nil
+}
+
+func (v *view) Name() string {
+	return v.name
+}
+
+func (v *view) Get(key PrimaryKey) (Model, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	model, ok := v.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(model), nil
+}
+
+func (v *view) Find(f func(Model) bool) ([]Model, error) {
+	models := make([]Model, 0)
+	err := v.Scan(func(m Model) bool {
+		if f(m) {
+			models = append(models, m)
+		}
+		return true
+	})
+	return models, err
+}
+
+func (v *view) Scan(f func(Model) bool) error {
+	v.mu.RLock()
+	rows := make([]Model, 0, len(v.data))
+	for _, model := range v.data {
+		rows = append(rows, model)
+	}
+	v.mu.RUnlock()
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].GetID() < rows[j].GetID()
+	})
+	for _, model := range rows {
+		if !f(clone(model)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+var _ Reader = &view{}
@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testSub struct {
+	ID     PrimaryKey
+	UserID PrimaryKey
+	Plan   string
+}
+
+func (s *testSub) GetID() PrimaryKey {
+	return s.ID
+}
+
+func (s *testSub) SetID(id PrimaryKey) {
+	s.ID = id
+}
+
+func init() {
+	RegisterModel(&testSub{})
+}
+
+// latestSub is a view of the latest subscription of each user.
+var latestSub = View{
+	Sources: []string{"subs"},
+	Group: func(_ string, m Model) []PrimaryKey {
+		return []PrimaryKey{m.(*testSub).UserID}
+	},
+	Reduce: func(_ PrimaryKey, rows []Model) Model {
+		return rows[len(rows)-1]
+	},
+}
+
+// viewRows returns the rows of a view of subscriptions as user:plan.
+func viewRows(t *testing.T, d DB, name string) []string {
+	t.Helper()
+	table, err := d.Table(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := table.Find(func(Model) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]string, len(ms))
+	for i, m := range ms {
+		rows[i] = fmt.Sprintf("%d:%s", m.GetID(), m.(*testSub).Plan)
+	}
+	return rows
+}
+
+func TestView(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(dir string) []Option
+	}{
+		{name: "plain", opts: func(string) []Option { return nil }},
+		{name: "sharded", opts: func(string) []Option { return []Option{WithShards(4)} }},
+		{name: "spilled", opts: func(dir string) []Option {
+			return []Option{WithMemoryBudget(MemoryBudget{MaxRows: 2, Dir: dir})}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDB(tt.opts(t.TempDir())...)
+			defer d.Close()
+			if err := d.AddTable("subs"); err != nil {
+				t.Fatal(err)
+			}
+			subs, _ := d.Table("subs")
+			// rows written before the view is added are in it
+			if err := subs.Insert(&testSub{UserID: 1, Plan: "free"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.AddView("latest", latestSub); err != nil {
+				t.Fatal(err)
+			}
+			steps := []struct {
+				write func() error
+				want  string
+			}{
+				{func() error { return nil }, "[1:free]"},
+				{func() error { return subs.Insert(&testSub{UserID: 1, Plan: "basic"}) }, "[1:basic]"},
+				{func() error { return subs.Insert(&testSub{UserID: 2, Plan: "premium"}) }, "[1:basic 2:premium]"},
+				{func() error { return subs.Update(&testSub{ID: 2, UserID: 1, Plan: "gold"}) }, "[1:gold 2:premium]"},
+				// the row moves to the group of another user
+				{func() error { return subs.Update(&testSub{ID: 2, UserID: 3, Plan: "gold"}) }, "[1:free 2:premium 3:gold]"},
+				{func() error { return subs.Delete(1) }, "[2:premium 3:gold]"},
+				{func() error {
+					return subs.InsertMany([]Model{&testSub{UserID: 2, Plan: "a"}, &testSub{UserID: 4, Plan: "b"}})
+				}, "[2:a 3:gold 4:b]"},
+				{func() error {
+					_, err := subs.DeleteWhere(func(m Model) bool { return m.(*testSub).UserID != 3 })
+					return err
+				}, "[3:gold]"},
+			}
+			for i, step := range steps {
+				if err := step.write(); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if got := fmt.Sprint(viewRows(t, d, "latest")); got != step.want {
+					t.Errorf("step %d: rows = %v, want %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestView_Reduce(t *testing.T) {
+	d := NewDB()
+	for _, name := range []string{"subs", "extra"} {
+		if err := d.AddTable(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// plans holds, for each user with a paid plan, the plans of the user
+	// in both sources
+	err := d.AddView("plans", View{
+		Sources: []string{"subs", "extra"},
+		Group: func(table string, m Model) []PrimaryKey {
+			return []PrimaryKey{m.(*testSub).UserID}
+		},
+		Reduce: func(key PrimaryKey, rows []Model) Model {
+			plans := ""
+			for _, m := range rows {
+				plans += m.(*testSub).Plan
+			}
+			if plans == "free" {
+				return nil
+			}
+			return &testSub{Plan: plans}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := d.Table("subs")
+	extra, _ := d.Table("extra")
+	for _, write := range []func() error{
+		func() error { return extra.Insert(&testSub{UserID: 1, Plan: "x"}) },
+		func() error { return subs.Insert(&testSub{UserID: 1, Plan: "a"}) },
+		func() error { return subs.Insert(&testSub{UserID: 2, Plan: "free"}) },
+		func() error { return subs.Insert(&testSub{UserID: 1, Plan: "b"}) },
+	} {
+		if err = write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := fmt.Sprint(viewRows(t, d, "plans")), "[1:abx]"; got != want {
+		t.Errorf("rows = %v, want %v", got, want)
+	}
+}
+
+func TestView_ReducedOnWrite(t *testing.T) {
+	d := NewDB()
+	if err := d.AddTable("subs"); err != nil {
+		t.Fatal(err)
+	}
+	reduced := 0
+	err := d.AddView("latest", View{
+		Sources: latestSub.Sources,
+		Group:   latestSub.Group,
+		Reduce: func(key PrimaryKey, rows []Model) Model {
+			reduced++
+			return latestSub.Reduce(key, rows)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := d.Table("subs")
+	for i := 0; i < 10; i++ {
+		if err = subs.Insert(&testSub{UserID: PrimaryKey(i%2 + 1), Plan: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every write reduces the one group it changed
+	if reduced != 10 {
+		t.Errorf("reduced %d groups writing, want 10", reduced)
+	}
+	if got, want := fmt.Sprint(viewRows(t, d, "latest")), "[1:8 2:9]"; got != want {
+		t.Errorf("rows = %v, want %v", got, want)
+	}
+	view, err := d.Table("latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = view.Get(1); err != nil {
+		t.Fatal(err)
+	}
+	if reduced != 10 {
+		t.Errorf("reduced %d groups reading, want none", reduced-10)
+	}
+}
+
+func TestView_ReadOnly(t *testing.T) {
+	d := NewDB()
+	if err := d.AddTable("subs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddView("latest", latestSub); err != nil {
+		t.Fatal(err)
+	}
+	view, err := d.Table("latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = view.Insert(&testSub{UserID: 1}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Insert error = %v, wantErr %v", err, ErrReadOnly)
+	}
+	if _, err = view.Get(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get error = %v, wantErr %v", err, ErrNotFound)
+	}
+	subs, _ := d.Table("subs")
+	if err = subs.Insert(&testSub{UserID: 1, Plan: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := view.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rows read from the view are copies
+	got.(*testSub).Plan = "changed"
+	if fmt.Sprint(viewRows(t, d, "latest")) != "[1:a]" {
+		t.Errorf("changing a row read from the view changed the view")
+	}
+	// emptying the source without changes, as a follower does, empties the view
+	source, _ := d.(*db).table("subs")
+	source.(replica).reset(0)
+	if got := viewRows(t, d, "latest"); len(got) != 0 {
+		t.Errorf("rows after reset = %v, want none", got)
+	}
+}
+
+func TestDb_AddView(t *testing.T) {
+	tests := []struct {
+		name    string
+		view    string
+		sources []string
+		wantErr error
+	}{
+		{name: "no name", view: "", sources: []string{"subs"}, wantErr: ErrorNoTableName},
+		{name: "exists", view: "subs", sources: []string{"subs"}, wantErr: ErrTableExists},
+		{name: "missing source", view: "latest", sources: []string{"nope"}, wantErr: ErrorNoTable},
+		{name: "view", view: "latest", sources: []string{"subs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDB()
+			if err := d.AddTable("subs"); err != nil {
+				t.Fatal(err)
+			}
+			def := latestSub
+			def.Sources = tt.sources
+			if err := d.AddView(tt.view, def); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AddView() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
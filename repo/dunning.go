@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"fmt"
+
+	"example/models"
+	"example/pkg"
+)
+
+const dunningTable = "dunning_event"
+
+// Dunning is a repository for the steps of the collection of unpaid subscriptions.
+type Dunning interface {
+	// Create records a dunning event
+	Create(*models.DunningEvent) error
+	// GetBy returns the dunning events matching a filter function in ID order
+	GetBy(filter func(*models.DunningEvent) bool) ([]*models.DunningEvent, error)
+}
+
+type dunning struct {
+	db pkg.DB
+}
+
+func (d *dunning) Create(m *models.DunningEvent) error {
+	table, err := d.db.Table(dunningTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting dunning event: %w", err)
+	}
+	return nil
+}
+
+func (d *dunning) GetBy(filter func(*models.DunningEvent) bool) ([]*models.DunningEvent, error) {
+	table, err := d.db.Table(dunningTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.DunningEvent))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding dunning events: %w", err)
+	}
+	events := make([]*models.DunningEvent, len(ms))
+	for i, m := range ms {
+		events[i] = m.(*models.DunningEvent)
+	}
+	return events, nil
+}
+
+func NewDunning(db pkg.DB) (Dunning, error) {
+	if err := db.AddTable(dunningTable); err != nil {
+		return nil, fmt.Errorf("error adding table: %w", err)
+	}
+	return &dunning{db: db}, nil
+}
+
+var _ Dunning = (*dunning)(nil)
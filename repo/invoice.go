@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"fmt"
+
+	"example/models"
+	"example/pkg"
+)
+
+const invoicesTable = "invoice"
+
+// Invoice is a repository for the invoices of subscriptions.
+type Invoice interface {
+	// Create creates a new invoice
+	Create(*models.Invoice) error
+	// Update updates an existing invoice
+	Update(*models.Invoice) error
+	// GetByID returns an invoice by its ID
+	GetByID(key pkg.PrimaryKey) (*models.Invoice, error)
+	// GetBy returns the invoices matching a filter function in ID order
+	GetBy(filter func(*models.Invoice) bool) ([]*models.Invoice, error)
+}
+
+type invoice struct {
+	db pkg.DB
+}
+
+func (r *invoice) Create(m *models.Invoice) error {
+	table, err := r.db.Table(invoicesTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting invoice: %w", err)
+	}
+	return nil
+}
+
+func (r *invoice) Update(m *models.Invoice) error {
+	table, err := r.db.Table(invoicesTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Update(m); err != nil {
+		return fmt.Errorf("error updating invoice: %w", err)
+	}
+	return nil
+}
+
+func (r *invoice) GetByID(key pkg.PrimaryKey) (*models.Invoice, error) {
+	table, err := r.db.Table(invoicesTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	model, err := table.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting invoice: %w", err)
+	}
+	return model.(*models.Invoice), nil
+}
+
+func (r *invoice) GetBy(filter func(*models.Invoice) bool) ([]*models.Invoice, error) {
+	table, err := r.db.Table(invoicesTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.Invoice))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding invoices: %w", err)
+	}
+	invoices := make([]*models.Invoice, len(ms))
+	for i, m := range ms {
+		invoices[i] = m.(*models.Invoice)
+	}
+	return invoices, nil
+}
+
+func NewInvoice(db pkg.DB) (Invoice, error) {
+	if err := db.AddTable(invoicesTable); err != nil {
+		return nil, fmt.Errorf("error adding table: %w", err)
+	}
+	return &invoice{db: db}, nil
+}
+
+var _ Invoice = (*invoice)(nil)
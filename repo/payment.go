@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"fmt"
+
+	"example/models"
+	"example/pkg"
+)
+
+const paymentsTable = "payment"
+
+// Payment is a repository for payments.
+type Payment interface {
+	// Create records a payment
+	Create(*models.Payment) error
+	// Update updates an existing payment
+	Update(*models.Payment) error
+	// GetBy returns the payments matching a filter function in ID order
+	GetBy(filter func(*models.Payment) bool) ([]*models.Payment, error)
+}
+
+type payment struct {
+	db pkg.DB
+}
+
+func (p *payment) Create(m *models.Payment) error {
+	table, err := p.db.Table(paymentsTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting payment: %w", err)
+	}
+	return nil
+}
+
+func (p *payment) Update(m *models.Payment) error {
+	table, err := p.db.Table(paymentsTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Update(m); err != nil {
+		return fmt.Errorf("error updating payment: %w", err)
+	}
+	return nil
+}
+
+func (p *payment) GetBy(filter func(*models.Payment) bool) ([]*models.Payment, error) {
+	table, err := p.db.Table(paymentsTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.Payment))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding payments: %w", err)
+	}
+	payments := make([]*models.Payment, len(ms))
+	for i, m := range ms {
+		payments[i] = m.(*models.Payment)
+	}
+	return payments, nil
+}
+
+func NewPayment(db pkg.DB) (Payment, error) {
+	if err := db.AddTable(paymentsTable); err != nil {
+		return nil, fmt.Errorf("error adding table: %w", err)
+	}
+	return &payment{db: db}, nil
+}
+
+var _ Payment = (*payment)(nil)
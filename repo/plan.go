@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"fmt"
+
+	"example/models"
+	"example/pkg"
+)
+
+const (
+	plansTable      = "plan"
+	planPricesTable = "plan_price"
+)
+
+// Plan is a repository for the plan catalog and the price versions of its
+// plans.
+type Plan interface {
+	// Create creates a new plan
+	Create(*models.Plan) error
+	// Update updates an existing plan
+	Update(*models.Plan) error
+	// GetBy returns the plans matching a filter function in ID order
+	GetBy(filter func(*models.Plan) bool) ([]*models.Plan, error)
+	// CreatePrice creates a new price version
+	CreatePrice(*models.PlanPrice) error
+	// GetPrice returns a price version by its ID
+	GetPrice(key pkg.PrimaryKey) (*models.PlanPrice, error)
+	// GetPricesBy returns the price versions matching a filter function in ID
+	// order
+	GetPricesBy(filter func(*models.PlanPrice) bool) ([]*models.PlanPrice, error)
+}
+
+type plan struct {
+	db pkg.DB
+}
+
+func (p *plan) Create(m *models.Plan) error {
+	table, err := p.db.Table(plansTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting plan: %w", err)
+	}
+	return nil
+}
+
+func (p *plan) Update(m *models.Plan) error {
+	table, err := p.db.Table(plansTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Update(m); err != nil {
+		return fmt.Errorf("error updating plan: %w", err)
+	}
+	return nil
+}
+
+func (p *plan) GetBy(filter func(*models.Plan) bool) ([]*models.Plan, error) {
+	table, err := p.db.Table(plansTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.Plan))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding plans: %w", err)
+	}
+	plans := make([]*models.Plan, len(ms))
+	for i, m := range ms {
+		plans[i] = m.(*models.Plan)
+	}
+	return plans, nil
+}
+
+func (p *plan) CreatePrice(m *models.PlanPrice) error {
+	table, err := p.db.Table(planPricesTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting plan price: %w", err)
+	}
+	return nil
+}
+
+func (p *plan) GetPrice(key pkg.PrimaryKey) (*models.PlanPrice, error) {
+	table, err := p.db.Table(planPricesTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	model, err := table.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting plan price: %w", err)
+	}
+	return model.(*models.PlanPrice), nil
+}
+
+func (p *plan) GetPricesBy(filter func(*models.PlanPrice) bool) ([]*models.PlanPrice, error) {
+	table, err := p.db.Table(planPricesTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.PlanPrice))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding plan prices: %w", err)
+	}
+	prices := make([]*models.PlanPrice, len(ms))
+	for i, m := range ms {
+		prices[i] = m.(*models.PlanPrice)
+	}
+	return prices, nil
+}
+
+func NewPlan(db pkg.DB) (Plan, error) {
+	for _, name := range []string{plansTable, planPricesTable} {
+		if err := db.AddTable(name); err != nil {
+			return nil, fmt.Errorf("error adding table: %w", err)
+		}
+	}
+	return &plan{db: db}, nil
+}
+
+var _ Plan = (*plan)(nil)
@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"fmt"
+
+	"example/models"
+	"example/pkg"
+)
+
+const planChangesTable = "plan_change"
+
+// PlanChange is a repository for the plan changes of subscriptions.
+type PlanChange interface {
+	// Create records a plan change
+	Create(*models.PlanChange) error
+	// GetBy returns the plan changes matching a filter function in ID order
+	GetBy(filter func(*models.PlanChange) bool) ([]*models.PlanChange, error)
+}
+
+type planChange struct {
+	db pkg.DB
+}
+
+func (r *planChange) Create(m *models.PlanChange) error {
+	table, err := r.db.Table(planChangesTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting plan change: %w", err)
+	}
+	return nil
+}
+
+func (r *planChange) GetBy(filter func(*models.PlanChange) bool) ([]*models.PlanChange, error) {
+	table, err := r.db.Table(planChangesTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.PlanChange))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding plan changes: %w", err)
+	}
+	changes := make([]*models.PlanChange, len(ms))
+	for i, m := range ms {
+		changes[i] = m.(*models.PlanChange)
+	}
+	return changes, nil
+}
+
+func NewPlanChange(db pkg.DB) (PlanChange, error) {
+	if err := db.AddTable(planChangesTable); err != nil {
+		return nil, fmt.Errorf("error adding table: %w", err)
+	}
+	return &planChange{db: db}, nil
+}
+
+var _ PlanChange = (*planChange)(nil)
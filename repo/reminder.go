@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"fmt"
+
+	"example/models"
+	"example/pkg"
+)
+
+const remindersTable = "reminder"
+
+// Reminder is a repository for the reminders sent to users.
+type Reminder interface {
+	// Create records a reminder
+	Create(*models.Reminder) error
+	// Update updates an existing reminder
+	Update(*models.Reminder) error
+	// GetBy returns the reminders matching a filter function in ID order
+	GetBy(filter func(*models.Reminder) bool) ([]*models.Reminder, error)
+}
+
+type reminder struct {
+	db pkg.DB
+}
+
+func (r *reminder) Create(m *models.Reminder) error {
+	table, err := r.db.Table(remindersTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting reminder: %w", err)
+	}
+	return nil
+}
+
+func (r *reminder) Update(m *models.Reminder) error {
+	table, err := r.db.Table(remindersTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Update(m); err != nil {
+		return fmt.Errorf("error updating reminder: %w", err)
+	}
+	return nil
+}
+
+func (r *reminder) GetBy(filter func(*models.Reminder) bool) ([]*models.Reminder, error) {
+	table, err := r.db.Table(remindersTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.Reminder))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding reminders: %w", err)
+	}
+	reminders := make([]*models.Reminder, len(ms))
+	for i, m := range ms {
+		reminders[i] = m.(*models.Reminder)
+	}
+	return reminders, nil
+}
+
+func NewReminder(db pkg.DB) (Reminder, error) {
+	if err := db.AddTable(remindersTable); err != nil {
+		return nil, fmt.Errorf("error adding table: %w", err)
+	}
+	return &reminder{db: db}, nil
+}
+
+var _ Reminder = (*reminder)(nil)
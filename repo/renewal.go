@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"fmt"
+
+	"example/models"
+	"example/pkg"
+)
+
+const renewalsTable = "renewal"
+
+// Renewal is a repository for the attempts to renew subscriptions.
+type Renewal interface {
+	// Create records a renewal attempt
+	Create(*models.Renewal) error
+	// GetBy returns the renewals matching a filter function in ID order
+	GetBy(filter func(*models.Renewal) bool) ([]*models.Renewal, error)
+}
+
+type renewal struct {
+	db pkg.DB
+}
+
+func (r *renewal) Create(m *models.Renewal) error {
+	table, err := r.db.Table(renewalsTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Insert(m); err != nil {
+		return fmt.Errorf("error inserting renewal: %w", err)
+	}
+	return nil
+}
+
+func (r *renewal) GetBy(filter func(*models.Renewal) bool) ([]*models.Renewal, error) {
+	table, err := r.db.Table(renewalsTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	ms, err := table.Find(func(model pkg.Model) bool {
+		return filter(model.(*models.Renewal))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding renewals: %w", err)
+	}
+	renewals := make([]*models.Renewal, len(ms))
+	for i, m := range ms {
+		renewals[i] = m.(*models.Renewal)
+	}
+	return renewals, nil
+}
+
+func NewRenewal(db pkg.DB) (Renewal, error) {
+	if err := db.AddTable(renewalsTable); err != nil {
+		return nil, fmt.Errorf("error adding table: %w", err)
+	}
+	return &renewal{db: db}, nil
+}
+
+var _ Renewal = (*renewal)(nil)
@@ -0,0 +1,138 @@
+package repo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"example/models"
+	"example/pkg"
+	"example/pkg/fault"
+)
+
+func TestUser_Faults(t *testing.T) {
+	tests := []struct {
+		name string
+		rule fault.Rule
+		call func(User) error
+		// want is the message the repository wraps the fault with
+		want string
+	}{
+		{
+			name: "create table",
+			rule: fault.Rule{Op: fault.OpNameTable},
+			call: func(r User) error { return r.Create(&models.User{Username: "alice"}) },
+			want: "error getting table: ",
+		},
+		{
+			name: "create insert",
+			rule: fault.Rule{Op: pkg.OpNameInsert},
+			call: func(r User) error { return r.Create(&models.User{Username: "alice"}) },
+			want: "error inserting user: ",
+		},
+		{
+			name: "create many",
+			rule: fault.Rule{Op: pkg.OpNameInsertMany},
+			call: func(r User) error { return r.CreateMany([]*models.User{{Username: "alice"}}) },
+			want: "error inserting users: ",
+		},
+		{
+			name: "get by id",
+			rule: fault.Rule{Op: pkg.OpNameGet},
+			call: func(r User) error { _, err := r.GetByID(1); return err },
+			want: "error getting user: ",
+		},
+		{
+			name: "get by username",
+			rule: fault.Rule{Op: pkg.OpNameFind},
+			call: func(r User) error { _, err := r.GetByUsername("alice"); return err },
+			want: "error finding users: ",
+		},
+		{
+			name: "each",
+			rule: fault.Rule{Op: pkg.OpNameScan},
+			call: func(r User) error { return r.Each(func(*models.User) bool { return true }) },
+			want: "error scanning users: ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := fault.Wrap(pkg.NewDB())
+			r, err := NewUser(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.rule.Table = usersTable
+			test.rule.Err = fault.ErrInjected
+			db.Inject(test.rule)
+			err = test.call(r)
+			if !errors.Is(err, fault.ErrInjected) {
+				t.Fatalf("%s error = %v, wantErr %v", test.name, err, fault.ErrInjected)
+			}
+			if !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("%s error = %q, want prefix %q", test.name, err, test.want)
+			}
+		})
+	}
+}
+
+func TestSubscription_Faults(t *testing.T) {
+	sub := func() *models.Subscription {
+		return &models.Subscription{UserID: 1, PlanType: models.PlanTypeFree}
+	}
+	tests := []struct {
+		name string
+		rule fault.Rule
+		call func(Subscription) error
+		want string
+	}{
+		{
+			name: "create",
+			rule: fault.Rule{Table: subscriptionsTable, Op: pkg.OpNameInsert},
+			call: func(r Subscription) error { return r.Create(sub()) },
+			want: "error inserting subscription: ",
+		},
+		{
+			name: "update",
+			rule: fault.Rule{Table: subscriptionsTable, Op: pkg.OpNameUpdate},
+			call: func(r Subscription) error {
+				m := sub()
+				m.ID = 1
+				return r.Update(m)
+			},
+			want: "error updating subscription: ",
+		},
+		{
+			name: "get by id",
+			rule: fault.Rule{Table: subscriptionsTable, Op: pkg.OpNameGet},
+			call: func(r Subscription) error { _, err := r.GetByID(1); return err },
+			want: "error getting subscription: ",
+		},
+		{
+			name: "latest table",
+			rule: fault.Rule{Table: latestSubscriptionsView, Op: fault.OpNameTable},
+			call: func(r Subscription) error { _, err := r.GetLatestByUser(1); return err },
+			want: "error getting table: ",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := fault.Wrap(pkg.NewDB())
+			r, err := NewSubscription(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.rule.Err = fault.ErrInjected
+			db.Inject(test.rule)
+			err = test.call(r)
+			if !errors.Is(err, fault.ErrInjected) {
+				t.Fatalf("%s error = %v, wantErr %v", test.name, err, fault.ErrInjected)
+			}
+			if !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("%s error = %q, want prefix %q", test.name, err, test.want)
+			}
+		})
+	}
+}
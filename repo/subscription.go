@@ -11,13 +11,29 @@ import (
 type Subscription interface {
 	// Create creates a new subscription
 	Create(*models.Subscription) error
+	// Update updates an existing subscription
+	Update(*models.Subscription) error
+	// Delete deletes a subscription
+	Delete(key pkg.PrimaryKey) error
 	// GetByID returns a subscription by its ID
 	GetByID(key pkg.PrimaryKey) (*models.Subscription, error)
 	// GetBy returns a subscription by a filter function
 	GetBy(filter func(*models.Subscription) bool) ([]*models.Subscription, error)
+	// GetLatestByUser returns the subscription of a user created last
+	GetLatestByUser(userID pkg.PrimaryKey) (*models.Subscription, error)
+	// FindWithUsers returns all subscriptions with the username of their
+	// user, empty if the user does not exist
+	FindWithUsers() ([]*models.SubscriptionWithUser, error)
+	// Each calls f for every subscription in ID order until f returns false,
+	// reading the subscriptions as they were when it was called
+	Each(f func(*models.Subscription) bool) error
 }
 
-const subscriptionsTable = "subscription"
+const (
+	subscriptionsTable = "subscription"
+	// latestSubscriptionsView holds the latest subscription of each user
+	latestSubscriptionsView = "latest_subscription"
+)
 
 type subscription struct {
 	db pkg.DB
@@ -34,6 +50,28 @@ func (s *subscription) Create(m *models.Subscription) error {
 	return nil
 }
 
+func (s *subscription) Update(m *models.Subscription) error {
+	table, err := s.db.Table(subscriptionsTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Update(m); err != nil {
+		return fmt.Errorf("error updating subscription: %w", err)
+	}
+	return nil
+}
+
+func (s *subscription) Delete(key pkg.PrimaryKey) error {
+	table, err := s.db.Table(subscriptionsTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	if err = table.Delete(key); err != nil {
+		return fmt.Errorf("error deleting subscription: %w", err)
+	}
+	return nil
+}
+
 func (s *subscription) GetByID(key pkg.PrimaryKey) (*models.Subscription, error) {
 	table, err := s.db.Table(subscriptionsTable)
 	if err != nil {
@@ -65,10 +103,81 @@ func (s *subscription) GetBy(filter func(*models.Subscription) bool) ([]*models.
 	return subscriptions, nil
 }
 
+func (s *subscription) GetLatestByUser(userID pkg.PrimaryKey) (*models.Subscription, error) {
+	view, err := s.db.Table(latestSubscriptionsView)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	model, err := view.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting latest subscription: %w", err)
+	}
+	return &model.(*models.LatestSubscription).Subscription, nil
+}
+
+func (s *subscription) FindWithUsers() ([]*models.SubscriptionWithUser, error) {
+	snapshot := s.db.Snapshot()
+	defer snapshot.Release()
+	subscriptions, err := snapshot.Table(subscriptionsTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	users, err := snapshot.Table(usersTable)
+	if err != nil {
+		return nil, fmt.Errorf("error getting table: %w", err)
+	}
+	var result []*models.SubscriptionWithUser
+	err = pkg.Join(subscriptions, users, pkg.LeftJoin, pkg.JoinOn{
+		Left: func(m pkg.Model) interface{} {
+			return m.(*models.Subscription).UserID
+		},
+	}, func(l, r pkg.Model) bool {
+		row := &models.SubscriptionWithUser{Subscription: l.(*models.Subscription)}
+		if r != nil {
+			row.Username = r.(*models.User).Username
+		}
+		result = append(result, row)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error joining subscriptions with users: %w", err)
+	}
+	return result, nil
+}
+
+func (s *subscription) Each(f func(*models.Subscription) bool) error {
+	snapshot := s.db.Snapshot()
+	defer snapshot.Release()
+	table, err := snapshot.Table(subscriptionsTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	err = table.Scan(func(model pkg.Model) bool {
+		return f(model.(*models.Subscription))
+	})
+	if err != nil {
+		return fmt.Errorf("error scanning subscriptions: %w", err)
+	}
+	return nil
+}
+
 func NewSubscription(db pkg.DB) (Subscription, error) {
 	if err := db.AddTable(subscriptionsTable); err != nil {
 		return nil, fmt.Errorf("error adding table: %w", err)
 	}
+	err := db.AddView(latestSubscriptionsView, pkg.View{
+		Sources: []string{subscriptionsTable},
+		Group: func(_ string, m pkg.Model) []pkg.PrimaryKey {
+			return []pkg.PrimaryKey{m.(*models.Subscription).UserID}
+		},
+		Reduce: func(userID pkg.PrimaryKey, rows []pkg.Model) pkg.Model {
+			// rows are in ID order, so the last one was created last
+			return &models.LatestSubscription{Subscription: *rows[len(rows)-1].(*models.Subscription)}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error adding view: %w", err)
+	}
 	return &subscription{db: db}, nil
 }
 
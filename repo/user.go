@@ -13,12 +13,17 @@ const usersTable = "users"
 type User interface {
 	// Create creates a new user
 	Create(*models.User) error
+	// CreateMany creates all the users or none of them
+	CreateMany([]*models.User) error
 	// GetByID returns a user by its ID
 	GetByID(key pkg.PrimaryKey) (*models.User, error)
 	// GetByUsername returns a user by its username
 	GetByUsername(username string) ([]*models.User, error)
 	// FindAll returns all users
 	FindAll() ([]*models.User, error)
+	// Each calls f for every user in ID order until f returns false, reading
+	// the users as they were when it was called
+	Each(f func(*models.User) bool) error
 }
 
 type user struct {
@@ -36,6 +41,21 @@ func (u *user) Create(m *models.User) error {
 	return nil
 }
 
+func (u *user) CreateMany(ms []*models.User) error {
+	table, err := u.db.Table(usersTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	rows := make([]pkg.Model, len(ms))
+	for i, m := range ms {
+		rows[i] = m
+	}
+	if err = table.InsertMany(rows); err != nil {
+		return fmt.Errorf("error inserting users: %w", err)
+	}
+	return nil
+}
+
 func (u *user) GetByID(key pkg.PrimaryKey) (*models.User, error) {
 	table, err := u.db.Table(usersTable)
 	if err != nil {
@@ -85,6 +105,22 @@ func (u *user) FindAll() ([]*models.User, error) {
 	return users, nil
 }
 
+func (u *user) Each(f func(*models.User) bool) error {
+	snapshot := u.db.Snapshot()
+	defer snapshot.Release()
+	table, err := snapshot.Table(usersTable)
+	if err != nil {
+		return fmt.Errorf("error getting table: %w", err)
+	}
+	err = table.Scan(func(model pkg.Model) bool {
+		return f(model.(*models.User))
+	})
+	if err != nil {
+		return fmt.Errorf("error scanning users: %w", err)
+	}
+	return nil
+}
+
 // NewUser returns a new user repository
 func NewUser(db pkg.DB) (User, error) {
 	err := db.AddTable(usersTable)
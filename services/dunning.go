@@ -0,0 +1,174 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/repo"
+)
+
+// Dunning is the interface that all dunning services must implement
+type Dunning interface {
+	// Run moves the subscriptions set to renew whose period ended by now to
+	// past due and retries their renewal when it is due, moving their user
+	// to the free plan after the last failure
+	Run(now time.Time) error
+	// GetBySubscriptionID returns the history of the collection of a
+	// subscription
+	GetBySubscriptionID(key pkg.PrimaryKey) ([]*models.DunningEvent, error)
+}
+
+type dunning struct {
+	r             repo.Dunning
+	subscriptions repo.Subscription
+	renewals      Renewal
+	invoices      Invoice
+	notifier      Notifier
+	// schedule holds when each retry is due, after the end of the period
+	schedule []time.Duration
+}
+
+// NewDunning returns a new Dunning service retrying renewals at the offsets
+// of schedule after the end of the unpaid period, and voiding its invoice
+// when they give up
+func NewDunning(r repo.Dunning, subscriptions repo.Subscription, renewals Renewal, invoices Invoice, notifier Notifier, schedule []time.Duration) Dunning {
+	return &dunning{r: r, subscriptions: subscriptions, renewals: renewals, invoices: invoices, notifier: notifier, schedule: schedule}
+}
+
+func (s *dunning) Run(now time.Time) error {
+	var lapsed []*models.Subscription
+	err := s.subscriptions.Each(func(m *models.Subscription) bool {
+		if m.Status == models.SubscriptionStatusPastDue || (renewing(m) && !now.Before(periodEnd(m))) {
+			lapsed = append(lapsed, m)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, m := range lapsed {
+		if err = s.collect(m, now); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// collect takes the next step of the collection of a lapsed subscription.
+func (s *dunning) collect(m *models.Subscription, now time.Time) error {
+	latest, err := s.subscriptions.GetLatestByUser(m.UserID)
+	if err != nil {
+		return err
+	}
+	if latest.ID != m.ID {
+		// the user moved to another subscription
+		return nil
+	}
+	end := periodEnd(m)
+	if m.Status != models.SubscriptionStatusPastDue {
+		if err = setStatus(m, models.SubscriptionStatusPastDue, now); err != nil {
+			return err
+		}
+		m.PastDueSince = &end
+		if err = s.subscriptions.Update(m); err != nil {
+			return err
+		}
+		if err = s.record(m, &models.DunningEvent{Kind: models.DunningEventPastDue, At: now}); err != nil {
+			return err
+		}
+		if err = s.notify(m, "Your payment is overdue", fmt.Sprintf("Your %s subscription ended on %s and has not been renewed.", m.PlanType, end.Format("2006-01-02"))); err != nil {
+			return err
+		}
+	}
+	events, err := s.GetBySubscriptionID(m.ID)
+	if err != nil {
+		return err
+	}
+	if len(s.schedule) == 0 {
+		return s.downgrade(m, "no retry is scheduled", now)
+	}
+	attempt := 1
+	for _, e := range events {
+		// a subscription renewed by a retry may lapse again later
+		if e.Kind == models.DunningEventRetryFailed && !e.At.Before(*m.PastDueSince) {
+			attempt++
+		}
+	}
+	if attempt > len(s.schedule) || now.Before(end.Add(s.schedule[attempt-1])) {
+		return nil
+	}
+	return s.retry(m, attempt, now)
+}
+
+// retry renews a past due subscription.
+func (s *dunning) retry(m *models.Subscription, attempt int, now time.Time) error {
+	renewal, chargeErr := s.renewals.Renew(m, now)
+	if renewal == nil {
+		return chargeErr
+	}
+	event := &models.DunningEvent{Attempt: attempt, PaymentID: renewal.PaymentID, At: now}
+	if chargeErr == nil {
+		event.Kind = models.DunningEventRetrySucceeded
+		if err := s.record(m, event); err != nil {
+			return err
+		}
+		return s.notify(m, "Your payment went through", fmt.Sprintf("Your %s subscription was renewed until %s.", m.PlanType, renewal.PeriodEnd.Format("2006-01-02")))
+	}
+	event.Kind = models.DunningEventRetryFailed
+	event.Error = chargeErr.Error()
+	if err := s.record(m, event); err != nil {
+		return err
+	}
+	if attempt < len(s.schedule) {
+		return s.notify(m, "Your payment failed", fmt.Sprintf("We could not charge your card (%s) and will try again on %s.",
+			chargeErr, periodEnd(m).Add(s.schedule[attempt]).Format("2006-01-02")))
+	}
+	return s.downgrade(m, chargeErr.Error(), now)
+}
+
+// downgrade moves the user of a past due subscription to the free plan.
+func (s *dunning) downgrade(m *models.Subscription, reason string, now time.Time) error {
+	if err := setStatus(m, models.SubscriptionStatusExpired, now); err != nil {
+		return err
+	}
+	if err := s.subscriptions.Update(m); err != nil {
+		return err
+	}
+	if err := s.invoices.VoidOpen(m.ID, now); err != nil {
+		return err
+	}
+	free := freeAfter(m, now)
+	if err := s.subscriptions.Create(free); err != nil {
+		return err
+	}
+	if err := s.record(m, &models.DunningEvent{Kind: models.DunningEventDowngraded, NewSubscriptionID: free.ID, Error: reason, At: now}); err != nil {
+		return err
+	}
+	return s.notify(m, "Your subscription was downgraded", fmt.Sprintf("We could not charge your card (%s) and moved you to the free plan.", reason))
+}
+
+func (s *dunning) record(m *models.Subscription, e *models.DunningEvent) error {
+	e.SubscriptionID = m.ID
+	e.UserID = m.UserID
+	return s.r.Create(e)
+}
+
+// notify tells the user of a subscription about its collection. It is
+// called once the step it is about is recorded, so a failure is reported
+// without undoing the step.
+func (s *dunning) notify(m *models.Subscription, subject, body string) error {
+	err := s.notifier.Notify(Notification{UserID: m.UserID, Subject: subject, Body: body})
+	if err != nil {
+		return fmt.Errorf("error notifying user %d: %w", m.UserID, err)
+	}
+	return nil
+}
+
+func (s *dunning) GetBySubscriptionID(id pkg.PrimaryKey) ([]*models.DunningEvent, error) {
+	return s.r.GetBy(func(m *models.DunningEvent) bool {
+		return m.SubscriptionID == id
+	})
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"example/models"
+)
+
+func TestDunning_Run(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		// cards are the cards charged by each retry
+		cards []string
+		// runs are when dunning runs, after the end of the period
+		runs       []time.Duration
+		wantEvents string
+		wantStatus models.SubscriptionStatus
+		// wantLatest is the plan the user is on at the end
+		wantLatest models.PlanType
+		wantVoid   bool
+	}{
+		{
+			name:       "downgraded after the last retry",
+			cards:      []string{CardDeclined, CardDeclined, CardDeclined},
+			runs:       []time.Duration{0, day, day, 2 * day, 3 * day, 4 * day},
+			wantEvents: "[past_due:0 retry_failed:1 retry_failed:2 retry_failed:3 downgraded:0]",
+			wantStatus: models.SubscriptionStatusExpired,
+			wantLatest: models.PlanTypeFree,
+			wantVoid:   true,
+		},
+		{
+			name:       "paid by a retry",
+			cards:      []string{CardDeclined, CardSuccess},
+			runs:       []time.Duration{0, day, 2 * day, 3 * day},
+			wantEvents: "[past_due:0 retry_failed:1 retry_succeeded:2]",
+			wantStatus: models.SubscriptionStatusActive,
+			wantLatest: models.PlanTypeBasic,
+		},
+		{
+			name:       "not retried early",
+			cards:      []string{CardSuccess},
+			runs:       []time.Duration{0, day - time.Minute},
+			wantEvents: "[past_due:0]",
+			wantStatus: models.SubscriptionStatusPastDue,
+			wantLatest: models.PlanTypeBasic,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			end := time.Now().Add(-30 * day)
+			m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+			m = s.lapse(t, m, end)
+			for _, run := range test.runs {
+				events, err := s.dunning.GetBySubscriptionID(m.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				attempt := 0
+				for _, e := range events {
+					if e.Attempt > attempt {
+						attempt = e.Attempt
+					}
+				}
+				if attempt < len(test.cards) {
+					s.setCard(t, m, test.cards[attempt])
+				}
+				if err = s.dunning.Run(end.Add(run)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			events, err := s.dunning.GetBySubscriptionID(m.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(events))
+			for i, e := range events {
+				got[i] = fmt.Sprintf("%s:%d", e.Kind, e.Attempt)
+			}
+			if fmt.Sprint(got) != test.wantEvents {
+				t.Errorf("events = %v, want %v", got, test.wantEvents)
+			}
+			if m, err = s.subscriptions.GetByID(m.ID); err != nil {
+				t.Fatal(err)
+			}
+			if m.Status != test.wantStatus {
+				t.Errorf("status = %s, want %s", m.Status, test.wantStatus)
+			}
+			latest, err := s.subscriptions.GetLatestByUser(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if latest.PlanType != test.wantLatest {
+				t.Errorf("latest plan = %s, want %s", latest.PlanType, test.wantLatest)
+			}
+			invoices, err := s.invoices.GetByUserID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := invoices[len(invoices)-1]
+			if void := last.Status == models.InvoiceStatusVoid; void != test.wantVoid {
+				t.Errorf("invoice of the unpaid period is %s", last.Status)
+			}
+		})
+	}
+}
+
+func TestDunning_NotifyError(t *testing.T) {
+	s := newTestServices(t)
+	end := time.Now().Add(-time.Hour)
+	m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+	s.lapse(t, m, end)
+	errNotify := errors.New("mail server down")
+	s.notifier.err = errNotify
+	if err := s.dunning.Run(end); !errors.Is(err, errNotify) {
+		t.Fatalf("Run() error = %v, wantErr %v", err, errNotify)
+	}
+	// the subscription is past due all the same
+	events, err := s.dunning.GetBySubscriptionID(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Kind != models.DunningEventPastDue {
+		t.Errorf("events = %v, want one past due", events)
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"example/models"
+)
+
+var (
+	ErrCardDeclined   = errors.New("card declined")
+	ErrInvalidCard    = errors.New("invalid card")
+	ErrGatewayRetry   = errors.New("payment gateway asked to retry")
+	ErrGatewayTimeout = errors.New("payment gateway timed out")
+	ErrUnknownCharge  = errors.New("unknown charge")
+)
+
+// PaymentGateway charges cards
+type PaymentGateway interface {
+	// Tokenize stores a card at the gateway and returns the token it is
+	// charged with, so the card number is never kept
+	Tokenize(card string) (string, error)
+	// Charge charges amount to the card of a token and returns the ID of the
+	// charge. A charge failing with ErrGatewayRetry can be tried again with
+	// the same reference.
+	Charge(token string, amount models.Money, reference string) (string, error)
+	// Refund refunds a charge in full
+	Refund(chargeID string) error
+}
+
+// Test cards accepted by FakeGateway
+const (
+	// CardSuccess is always charged
+	CardSuccess = "4242424242424242"
+	// CardDeclined is always declined
+	CardDeclined = "4000000000000002"
+	// CardRetry asks for a retry on the first attempt of each charge and is
+	// charged on the next one
+	CardRetry = "4000000000000119"
+	// CardTimeout never gets an answer from the gateway
+	CardTimeout = "4000000000000077"
+)
+
+// FakeGateway is an in-memory PaymentGateway for tests and development that
+// only accepts the test cards.
+type FakeGateway struct {
+	mu sync.Mutex
+	// cards holds the card of each token
+	cards map[string]string
+	// attempts counts the attempts of each charge by reference
+	attempts map[string]int
+	charges  int
+	// refunded holds the charges refunded, and charged the others
+	refunded map[string]bool
+}
+
+// NewFakeGateway returns a FakeGateway with no charges
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{cards: make(map[string]string), attempts: make(map[string]int), refunded: make(map[string]bool)}
+}
+
+func (g *FakeGateway) Tokenize(card string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch card {
+	case CardSuccess, CardDeclined, CardRetry, CardTimeout:
+	default:
+		return "", ErrInvalidCard
+	}
+	token := fmt.Sprintf("tok_%d", len(g.cards)+1)
+	g.cards[token] = card
+	return token, nil
+}
+
+func (g *FakeGateway) Charge(token string, amount models.Money, reference string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.attempts[reference]++
+	switch g.cards[token] {
+	case CardSuccess:
+	case CardDeclined:
+		return "", ErrCardDeclined
+	case CardRetry:
+		if g.attempts[reference] == 1 {
+			return "", ErrGatewayRetry
+		}
+	case CardTimeout:
+		return "", ErrGatewayTimeout
+	default:
+		return "", ErrInvalidCard
+	}
+	delete(g.attempts, reference)
+	g.charges++
+	id := fmt.Sprintf("ch_%d", g.charges)
+	g.refunded[id] = false
+	return id, nil
+}
+
+func (g *FakeGateway) Refund(chargeID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	refunded, ok := g.refunded[chargeID]
+	if !ok || refunded {
+		return fmt.Errorf("%w: %s", ErrUnknownCharge, chargeID)
+	}
+	g.refunded[chargeID] = true
+	return nil
+}
+
+// Refunded returns the number of charges refunded
+func (g *FakeGateway) Refunded() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for _, refunded := range g.refunded {
+		if refunded {
+			n++
+		}
+	}
+	return n
+}
+
+var _ PaymentGateway = (*FakeGateway)(nil)
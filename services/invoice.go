@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/repo"
+)
+
+var ErrInvoiceNotOpen = errors.New("invoice is not open")
+
+// Invoice is the interface that all invoice services must implement
+type Invoice interface {
+	// Bill issues the invoice of a period of a subscription made of lines.
+	// It is paid by payment, or right away if nothing is due, and left open
+	// otherwise.
+	Bill(m *models.Subscription, start, end time.Time, lines []models.InvoiceLine, payment *models.Payment, now time.Time) (*models.Invoice, error)
+	// Pay marks an open invoice paid by payment, nil if nothing was due
+	Pay(inv *models.Invoice, payment *models.Payment, now time.Time) error
+	// GetOpen returns the open invoice of a subscription for the period
+	// starting at start, or pkg.ErrNotFound
+	GetOpen(subscriptionID pkg.PrimaryKey, start time.Time) (*models.Invoice, error)
+	// VoidOpen voids the open invoices of a subscription that ended unpaid
+	VoidOpen(subscriptionID pkg.PrimaryKey, now time.Time) error
+	// GetByID returns an invoice by its ID
+	GetByID(key pkg.PrimaryKey) (*models.Invoice, error)
+	// GetByUserID returns the invoices of a user
+	GetByUserID(key pkg.PrimaryKey) ([]*models.Invoice, error)
+}
+
+type invoice struct {
+	r repo.Invoice
+	// numberMu keeps two invoices from being issued with the same number
+	numberMu sync.Mutex
+	// last is the number of the last invoice issued, -1 until it is read
+	// from the repository
+	last int
+}
+
+// NewInvoice returns a new Invoice service numbering invoices in sequence
+func NewInvoice(r repo.Invoice) Invoice {
+	return &invoice{r: r, last: -1}
+}
+
+func (s *invoice) Bill(m *models.Subscription, start, end time.Time, lines []models.InvoiceLine, payment *models.Payment, now time.Time) (*models.Invoice, error) {
+	total := models.NewMoney(0, m.Currency)
+	for _, l := range lines {
+		var err error
+		if total, err = total.Add(l.Amount); err != nil {
+			return nil, err
+		}
+	}
+	inv := &models.Invoice{
+		UserID:         m.UserID,
+		SubscriptionID: m.ID,
+		Status:         models.InvoiceStatusDraft,
+		Currency:       m.Currency,
+		PeriodStart:    start,
+		PeriodEnd:      end,
+		Lines:          lines,
+		Total:          total,
+		CreatedAt:      now,
+	}
+	if err := s.r.Create(inv); err != nil {
+		return nil, err
+	}
+	if err := s.issue(inv, now); err != nil {
+		return nil, err
+	}
+	if payment == nil && total.IsPositive() {
+		return inv, nil
+	}
+	if err := s.Pay(inv, payment, now); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// issue numbers a draft invoice and opens it for payment.
+func (s *invoice) issue(inv *models.Invoice, now time.Time) error {
+	s.numberMu.Lock()
+	defer s.numberMu.Unlock()
+	if s.last < 0 {
+		issued, err := s.r.GetBy(func(m *models.Invoice) bool {
+			return m.Number > 0
+		})
+		if err != nil {
+			return err
+		}
+		s.last = 0
+		for _, m := range issued {
+			if m.Number > s.last {
+				s.last = m.Number
+			}
+		}
+	}
+	inv.Number = s.last + 1
+	inv.Status = models.InvoiceStatusOpen
+	inv.IssuedAt = &now
+	if err := s.r.Update(inv); err != nil {
+		return err
+	}
+	s.last = inv.Number
+	return nil
+}
+
+func (s *invoice) Pay(inv *models.Invoice, payment *models.Payment, now time.Time) error {
+	if inv.Status != models.InvoiceStatusOpen {
+		return fmt.Errorf("%w: invoice %d is %s", ErrInvoiceNotOpen, inv.ID, inv.Status)
+	}
+	inv.Status = models.InvoiceStatusPaid
+	inv.PaidAt = &now
+	if payment != nil {
+		inv.PaymentID = payment.ID
+	}
+	return s.r.Update(inv)
+}
+
+func (s *invoice) GetOpen(subscriptionID pkg.PrimaryKey, start time.Time) (*models.Invoice, error) {
+	open, err := s.r.GetBy(func(m *models.Invoice) bool {
+		return m.SubscriptionID == subscriptionID && m.Status == models.InvoiceStatusOpen && m.PeriodStart.Equal(start)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(open) == 0 {
+		return nil, pkg.ErrNotFound
+	}
+	return open[len(open)-1], nil
+}
+
+func (s *invoice) VoidOpen(subscriptionID pkg.PrimaryKey, now time.Time) error {
+	open, err := s.r.GetBy(func(m *models.Invoice) bool {
+		return m.SubscriptionID == subscriptionID && m.Status == models.InvoiceStatusOpen
+	})
+	if err != nil {
+		return err
+	}
+	for _, inv := range open {
+		inv.Status = models.InvoiceStatusVoid
+		inv.VoidedAt = &now
+		if err = s.r.Update(inv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *invoice) GetByID(id pkg.PrimaryKey) (*models.Invoice, error) {
+	return s.r.GetByID(id)
+}
+
+func (s *invoice) GetByUserID(id pkg.PrimaryKey) ([]*models.Invoice, error) {
+	return s.r.GetBy(func(m *models.Invoice) bool {
+		return m.UserID == id
+	})
+}
+
+// planName returns the name of a plan of the catalog, or its type if it
+// cannot be read.
+func planName(plans Plan, planType models.PlanType) string {
+	plan, err := plans.Get(planType)
+	if err != nil {
+		return string(planType)
+	}
+	return plan.Name
+}
+
+// planLine returns the invoice line of a plan billed over a period.
+func planLine(name string, start, end time.Time, amount models.Money) models.InvoiceLine {
+	return models.InvoiceLine{
+		Description: fmt.Sprintf("%s plan, %s to %s", name, start.Format("2006-01-02"), end.Format("2006-01-02")),
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Amount:      amount,
+	}
+}
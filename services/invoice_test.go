@@ -0,0 +1,122 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/pkg/fault"
+)
+
+func TestInvoice_Numbers(t *testing.T) {
+	s := newTestServices(t)
+	const users = 20
+	s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+	// issuing the second invoice fails, and its number goes to the next one
+	s.db.Inject(fault.Rule{Table: "invoice", Op: pkg.OpNameUpdate, Nth: 1, Err: fault.ErrInjected})
+	m, err := s.newSubscription(2, models.PlanTypeBasic, CardSuccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.subscription.Create(m); !errors.Is(err, fault.ErrInjected) {
+		t.Fatalf("Create() error = %v, wantErr %v", err, fault.ErrInjected)
+	}
+	var wg sync.WaitGroup
+	for i := 3; i <= users; i++ {
+		m, err := s.newSubscription(pkg.PrimaryKey(i), models.PlanTypeBasic, CardSuccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.subscription.Create(m); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	numbers := func(invoices Invoice) []int {
+		t.Helper()
+		var numbers []int
+		for i := 1; i <= users+1; i++ {
+			issued, err := invoices.GetByUserID(pkg.PrimaryKey(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, inv := range issued {
+				if inv.Status != models.InvoiceStatusDraft {
+					numbers = append(numbers, inv.Number)
+				}
+			}
+		}
+		sort.Ints(numbers)
+		return numbers
+	}
+	got := numbers(s.invoices)
+	for i, n := range got {
+		if n != i+1 {
+			t.Fatalf("numbers = %v, want 1 to %d in sequence", got, users-1)
+		}
+	}
+	if len(got) != users-1 {
+		t.Fatalf("issued %d invoices, want %d", len(got), users-1)
+	}
+
+	// numbering goes on from the last invoice after a restart
+	restarted := NewInvoice(s.invoiceRepo)
+	m = &models.Subscription{ID: 1, UserID: users + 1, Currency: models.CurrencyUSD}
+	inv, err := restarted.Bill(m, time.Now(), time.Now(), nil, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Number != users {
+		t.Errorf("number after restart = %d, want %d", inv.Number, users)
+	}
+}
+
+func TestInvoice_RenewalRetry(t *testing.T) {
+	s := newTestServices(t)
+	end := time.Now().Add(-time.Hour)
+	m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+	m = s.lapse(t, m, end)
+	m = s.setCard(t, m, CardDeclined)
+	if _, err := s.renewals.Renew(m, end); err == nil {
+		t.Fatal("renewal charged a declined card")
+	}
+	open, err := s.invoices.GetOpen(m.ID, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open.Total.Minor != 999 {
+		t.Errorf("open invoice for %v, want 9.99 USD", open.Total)
+	}
+	// the retry pays the invoice issued by the first attempt
+	m = s.setCard(t, m, CardSuccess)
+	r, err := s.renewals.Renew(m, end.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.InvoiceID != open.ID {
+		t.Errorf("retry billed invoice %d, want %d", r.InvoiceID, open.ID)
+	}
+	inv, err := s.invoices.GetByID(open.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != models.InvoiceStatusPaid || inv.PaymentID != r.PaymentID {
+		t.Errorf("invoice %s by payment %d, want paid by %d", inv.Status, inv.PaymentID, r.PaymentID)
+	}
+	invoices, err := s.invoices.GetByUserID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 2 {
+		t.Errorf("issued %d invoices, want 2", len(invoices))
+	}
+}
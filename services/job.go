@@ -0,0 +1,20 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// RunJob calls f with the current time every interval until ctx is done
+func RunJob(ctx context.Context, interval time.Duration, f func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			f(now)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"log"
+
+	"example/pkg"
+)
+
+// Notification is a message for a user
+type Notification struct {
+	UserID  pkg.PrimaryKey
+	Subject string
+	Body    string
+}
+
+// Notifier delivers notifications to users, by email or otherwise
+type Notifier interface {
+	Notify(Notification) error
+}
+
+type logNotifier struct {
+	l *log.Logger
+}
+
+// NewLogNotifier returns a Notifier that writes notifications to l instead of
+// delivering them
+func NewLogNotifier(l *log.Logger) Notifier {
+	return &logNotifier{l}
+}
+
+func (n *logNotifier) Notify(m Notification) error {
+	n.l.Printf("to user %d: %s: %s", m.UserID, m.Subject, m.Body)
+	return nil
+}
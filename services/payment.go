@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/repo"
+)
+
+var ErrPaymentRequired = errors.New("payment card is required")
+
+// chargeAttempts is how many times a charge is tried when the gateway asks
+// for a retry
+const chargeAttempts = 3
+
+// Payment is the interface that all payment services must implement
+type Payment interface {
+	// Tokenize exchanges a card number for the gateway token it is charged
+	// with, and returns the token with the last four digits of the card
+	Tokenize(card string) (token, last4 string, err error)
+	// Charge charges amount to the card of a token for a user, retrying when
+	// the gateway asks to. The payment is returned, failed or not, but not
+	// recorded.
+	Charge(userID pkg.PrimaryKey, token string, amount models.Money) (*models.Payment, error)
+	// Refund refunds a successful payment at the gateway and marks it
+	// refunded, without recording it
+	Refund(*models.Payment) error
+	// Record records a payment, or the new status of a payment recorded
+	// already
+	Record(*models.Payment) error
+	// GetBySubscriptionID returns the payments for a subscription
+	GetBySubscriptionID(key pkg.PrimaryKey) ([]*models.Payment, error)
+}
+
+type payment struct {
+	r       repo.Payment
+	gateway PaymentGateway
+}
+
+// NewPayment returns a new Payment service charging through gateway
+func NewPayment(r repo.Payment, gateway PaymentGateway) Payment {
+	return &payment{r: r, gateway: gateway}
+}
+
+func (p *payment) Tokenize(card string) (string, string, error) {
+	if card == "" {
+		return "", "", ErrPaymentRequired
+	}
+	token, err := p.gateway.Tokenize(card)
+	if err != nil {
+		return "", "", err
+	}
+	last4 := card
+	if len(card) > 4 {
+		last4 = card[len(card)-4:]
+	}
+	return token, last4, nil
+}
+
+func (p *payment) Charge(userID pkg.PrimaryKey, token string, amount models.Money) (*models.Payment, error) {
+	if token == "" {
+		return nil, ErrPaymentRequired
+	}
+	now := time.Now()
+	m := &models.Payment{UserID: userID, Amount: amount, CreatedAt: now}
+	reference := fmt.Sprintf("user-%d-%d", userID, now.UnixNano())
+	var err error
+	for m.Attempts < chargeAttempts {
+		m.Attempts++
+		m.ChargeID, err = p.gateway.Charge(token, amount, reference)
+		if !errors.Is(err, ErrGatewayRetry) {
+			break
+		}
+	}
+	if err != nil {
+		m.Status = models.PaymentStatusFailed
+		m.Error = err.Error()
+		return m, fmt.Errorf("payment failed: %w", err)
+	}
+	m.Status = models.PaymentStatusSucceeded
+	return m, nil
+}
+
+func (p *payment) Refund(m *models.Payment) error {
+	if err := p.gateway.Refund(m.ChargeID); err != nil {
+		return fmt.Errorf("refund failed: %w", err)
+	}
+	now := time.Now()
+	m.Status = models.PaymentStatusRefunded
+	m.RefundedAt = &now
+	return nil
+}
+
+func (p *payment) Record(m *models.Payment) error {
+	if m.ID != 0 {
+		return p.r.Update(m)
+	}
+	return p.r.Create(m)
+}
+
+func (p *payment) GetBySubscriptionID(id pkg.PrimaryKey) ([]*models.Payment, error) {
+	return p.r.GetBy(func(m *models.Payment) bool {
+		return m.SubscriptionID == id
+	})
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/repo"
+)
+
+var (
+	ErrPlanExists     = errors.New("plan already exists")
+	ErrPlanArchived   = errors.New("plan is archived")
+	ErrInvalidPrice   = errors.New("paid plans need a period and one positive amount per currency")
+	ErrFreePlanFixed  = errors.New("the free plan cannot be priced or archived")
+	ErrPlanNotChanged = errors.New("plan update changes nothing")
+)
+
+// defaultPlans is the catalog Seed creates.
+var defaultPlans = []struct {
+	planType   models.PlanType
+	name       string
+	amounts    []models.Money
+	periodDays int
+}{
+	{models.PlanTypeFree, "Free", nil, 0},
+	{models.PlanTypeBasic, "Basic", []models.Money{
+		models.NewMoney(999, models.CurrencyUSD),
+		models.NewMoney(949, models.CurrencyEUR),
+	}, 30},
+	{models.PlanTypePremium, "Premium", []models.Money{
+		models.NewMoney(1999, models.CurrencyUSD),
+		models.NewMoney(1899, models.CurrencyEUR),
+	}, 30},
+}
+
+// PlanUpdate is a change to a plan. Nil fields are kept, and new amounts or
+// a new period start a new price version.
+type PlanUpdate struct {
+	Name *string
+	// Amounts replaces the amounts in every currency
+	Amounts    []models.Money
+	PeriodDays *int
+}
+
+// Plan is the interface that all plan services must implement
+type Plan interface {
+	// Create adds a paid plan to the catalog at its first price version,
+	// sold in the currencies of amounts
+	Create(planType models.PlanType, name string, amounts []models.Money, periodDays int) (*models.PlanWithPrice, error)
+	// Update renames a plan or prices it at a new version. Subscribers
+	// keep the version they subscribed at.
+	Update(planType models.PlanType, u PlanUpdate) (*models.PlanWithPrice, error)
+	// Archive closes a plan to new subscribers
+	Archive(planType models.PlanType) (*models.PlanWithPrice, error)
+	// Get returns a plan, archived or not
+	Get(planType models.PlanType) (*models.PlanWithPrice, error)
+	// GetAvailable returns a plan open to new subscribers
+	GetAvailable(planType models.PlanType) (*models.PlanWithPrice, error)
+	// Find returns the plans, along with the archived ones if archived is
+	// true
+	Find(archived bool) ([]*models.PlanWithPrice, error)
+	// GetPrice returns a price version by its ID
+	GetPrice(key pkg.PrimaryKey) (*models.PlanPrice, error)
+	// GetPrices returns the price versions of a plan
+	GetPrices(planType models.PlanType) ([]*models.PlanPrice, error)
+	// Seed adds the free, basic and premium plans to the catalog if they
+	// are not in it
+	Seed() error
+}
+
+type plan struct {
+	r repo.Plan
+	// mu keeps plan types unique and price versions sequential
+	mu sync.Mutex
+}
+
+// NewPlan returns a new Plan service
+func NewPlan(r repo.Plan) Plan {
+	return &plan{r: r}
+}
+
+func (s *plan) Create(planType models.PlanType, name string, amounts []models.Money, periodDays int) (*models.PlanWithPrice, error) {
+	if planType == models.PlanTypeFree {
+		return nil, ErrPlanExists
+	}
+	if err := checkPrice(amounts, periodDays); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(planType, name, amounts, periodDays)
+}
+
+// checkPrice checks the price of a paid plan.
+func checkPrice(amounts []models.Money, periodDays int) error {
+	if len(amounts) == 0 || periodDays <= 0 {
+		return ErrInvalidPrice
+	}
+	seen := make(map[models.Currency]bool)
+	for _, amount := range amounts {
+		if !amount.Currency.Valid() {
+			return fmt.Errorf("%w: %q", models.ErrUnknownCurrency, amount.Currency)
+		}
+		if !amount.IsPositive() || seen[amount.Currency] {
+			return ErrInvalidPrice
+		}
+		seen[amount.Currency] = true
+	}
+	return nil
+}
+
+// sameAmounts reports whether two prices have the same amount in every
+// currency.
+func sameAmounts(a, b []models.Money) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, amount := range a {
+		found := false
+		for _, other := range b {
+			if other == amount {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// create adds a plan priced at its first version. Callers hold mu.
+func (s *plan) create(planType models.PlanType, name string, amounts []models.Money, periodDays int) (*models.PlanWithPrice, error) {
+	if _, err := s.get(planType); err == nil {
+		return nil, ErrPlanExists
+	} else if !errors.Is(err, pkg.ErrNotFound) {
+		return nil, err
+	}
+	now := time.Now()
+	p := &models.Plan{Type: planType, Name: name, CreatedAt: now}
+	// the plan is validated before its price is stored
+	if err := pkg.Validate(p); err != nil {
+		return nil, err
+	}
+	price := &models.PlanPrice{PlanType: planType, Version: 1, Amounts: amounts, PeriodDays: periodDays, CreatedAt: now}
+	if err := s.r.CreatePrice(price); err != nil {
+		return nil, err
+	}
+	p.PriceID = price.ID
+	if err := s.r.Create(p); err != nil {
+		return nil, err
+	}
+	return &models.PlanWithPrice{Plan: p, Price: price}, nil
+}
+
+func (s *plan) Update(planType models.PlanType, u PlanUpdate) (*models.PlanWithPrice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.get(planType)
+	if err != nil {
+		return nil, err
+	}
+	price, err := s.r.GetPrice(p.PriceID)
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	if u.Name != nil && *u.Name != p.Name {
+		p.Name = *u.Name
+		changed = true
+	}
+	amounts, periodDays := price.Amounts, price.PeriodDays
+	if u.Amounts != nil {
+		amounts = u.Amounts
+	}
+	if u.PeriodDays != nil {
+		periodDays = *u.PeriodDays
+	}
+	if !sameAmounts(amounts, price.Amounts) || periodDays != price.PeriodDays {
+		if planType == models.PlanTypeFree {
+			return nil, ErrFreePlanFixed
+		}
+		if err = checkPrice(amounts, periodDays); err != nil {
+			return nil, err
+		}
+		if err = pkg.Validate(p); err != nil {
+			return nil, err
+		}
+		price = &models.PlanPrice{
+			PlanType:   planType,
+			Version:    price.Version + 1,
+			Amounts:    amounts,
+			PeriodDays: periodDays,
+			CreatedAt:  time.Now(),
+		}
+		if err = s.r.CreatePrice(price); err != nil {
+			return nil, err
+		}
+		p.PriceID = price.ID
+		changed = true
+	}
+	if !changed {
+		return nil, ErrPlanNotChanged
+	}
+	if err = s.r.Update(p); err != nil {
+		return nil, err
+	}
+	return &models.PlanWithPrice{Plan: p, Price: price}, nil
+}
+
+func (s *plan) Archive(planType models.PlanType) (*models.PlanWithPrice, error) {
+	if planType == models.PlanTypeFree {
+		return nil, ErrFreePlanFixed
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.get(planType)
+	if err != nil {
+		return nil, err
+	}
+	if p.ArchivedAt != nil {
+		return nil, ErrPlanArchived
+	}
+	now := time.Now()
+	p.ArchivedAt = &now
+	if err = s.r.Update(p); err != nil {
+		return nil, err
+	}
+	return s.withPrice(p)
+}
+
+func (s *plan) Get(planType models.PlanType) (*models.PlanWithPrice, error) {
+	p, err := s.get(planType)
+	if err != nil {
+		return nil, err
+	}
+	return s.withPrice(p)
+}
+
+func (s *plan) GetAvailable(planType models.PlanType) (*models.PlanWithPrice, error) {
+	p, err := s.Get(planType)
+	if errors.Is(err, pkg.ErrNotFound) {
+		return nil, ErrInvalidPlanType
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.ArchivedAt != nil {
+		return nil, ErrPlanArchived
+	}
+	return p, nil
+}
+
+func (s *plan) Find(archived bool) ([]*models.PlanWithPrice, error) {
+	ps, err := s.r.GetBy(func(m *models.Plan) bool {
+		return archived || m.ArchivedAt == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]*models.PlanWithPrice, len(ps))
+	for i, p := range ps {
+		if plans[i], err = s.withPrice(p); err != nil {
+			return nil, err
+		}
+	}
+	return plans, nil
+}
+
+func (s *plan) GetPrice(id pkg.PrimaryKey) (*models.PlanPrice, error) {
+	return s.r.GetPrice(id)
+}
+
+func (s *plan) GetPrices(planType models.PlanType) ([]*models.PlanPrice, error) {
+	if _, err := s.get(planType); err != nil {
+		return nil, err
+	}
+	return s.r.GetPricesBy(func(m *models.PlanPrice) bool {
+		return m.PlanType == planType
+	})
+}
+
+func (s *plan) Seed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range defaultPlans {
+		if _, err := s.create(d.planType, d.name, d.amounts, d.periodDays); err != nil && !errors.Is(err, ErrPlanExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns a plan by its type.
+func (s *plan) get(planType models.PlanType) (*models.Plan, error) {
+	ps, err := s.r.GetBy(func(m *models.Plan) bool {
+		return m.Type == planType
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ps) == 0 {
+		return nil, fmt.Errorf("error getting plan %s: %w", planType, pkg.ErrNotFound)
+	}
+	return ps[0], nil
+}
+
+func (s *plan) withPrice(p *models.Plan) (*models.PlanWithPrice, error) {
+	price, err := s.r.GetPrice(p.PriceID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PlanWithPrice{Plan: p, Price: price}, nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example/models"
+)
+
+func TestPlan_Update(t *testing.T) {
+	name := "Basic plus"
+	tests := []struct {
+		name        string
+		update      PlanUpdate
+		wantErr     error
+		wantVersion int
+		// wantRenewal is what a subscriber of the first version renews at
+		wantRenewal int64
+	}{
+		{
+			name:        "rename",
+			update:      PlanUpdate{Name: &name},
+			wantVersion: 1,
+			wantRenewal: 999,
+		},
+		{
+			name:        "reprice",
+			update:      PlanUpdate{Amounts: []models.Money{models.NewMoney(1299, models.CurrencyUSD)}},
+			wantVersion: 2,
+			wantRenewal: 999,
+		},
+		{
+			name:    "no change",
+			wantErr: ErrPlanNotChanged,
+		},
+		{
+			name:    "negative amount",
+			update:  PlanUpdate{Amounts: []models.Money{models.NewMoney(-1, models.CurrencyUSD)}},
+			wantErr: ErrInvalidPrice,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+			got, err := s.plans.Update(models.PlanTypeBasic, test.update)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Update() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Price.Version != test.wantVersion {
+				t.Errorf("version = %d, want %d", got.Price.Version, test.wantVersion)
+			}
+			// subscribers keep the price they subscribed at
+			m = s.lapse(t, m, time.Now())
+			r, err := s.renewals.Renew(m, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			payments, err := s.payments.GetBySubscriptionID(m.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := payments[len(payments)-1]
+			if last.ID != r.PaymentID || last.Amount.Minor != test.wantRenewal {
+				t.Errorf("renewed for %v, want %d", last.Amount, test.wantRenewal)
+			}
+		})
+	}
+}
+
+func TestPlan_Archive(t *testing.T) {
+	s := newTestServices(t)
+	if _, err := s.plans.Archive(models.PlanTypeFree); !errors.Is(err, ErrFreePlanFixed) {
+		t.Errorf("Archive(free) error = %v, wantErr %v", err, ErrFreePlanFixed)
+	}
+	if _, err := s.plans.Archive(models.PlanTypePremium); err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.newSubscription(1, models.PlanTypePremium, CardSuccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.subscription.Create(m); !errors.Is(err, ErrPlanArchived) {
+		t.Errorf("Create() error = %v, wantErr %v", err, ErrPlanArchived)
+	}
+	plans, err := s.plans.Find(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range plans {
+		if p.Type == models.PlanTypePremium {
+			t.Errorf("archived plan listed")
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"example/pkg"
+	"example/pkg/query"
+)
+
+// Query is the interface that all query services must implement
+type Query interface {
+	// Run runs a SELECT statement against the database
+	Run(statement string) (*query.Result, error)
+}
+
+type queryService struct {
+	db pkg.DB
+}
+
+func (q *queryService) Run(statement string) (*query.Result, error) {
+	return query.Run(q.db, statement)
+}
+
+// NewQuery returns a new query service
+func NewQuery(db pkg.DB) Query {
+	return &queryService{db}
+}
+
+var _ Query = &queryService{}
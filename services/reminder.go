@@ -0,0 +1,195 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/repo"
+)
+
+// Reminder is the interface that all reminder services must implement
+type Reminder interface {
+	// SendExpiryReminders notifies the users whose paid period ends within
+	// one of the reminder windows of now, once per window and period, and
+	// returns the number of reminders sent
+	SendExpiryReminders(now time.Time) (int, error)
+	// GetBySubscriptionID returns the reminders about a subscription, sent
+	// or not
+	GetBySubscriptionID(key pkg.PrimaryKey) ([]*models.Reminder, error)
+}
+
+type reminder struct {
+	r             repo.Reminder
+	subscriptions repo.Subscription
+	notifier      Notifier
+	// windows are sorted from the narrowest
+	windows []time.Duration
+}
+
+// NewReminder returns a new Reminder service sending reminders through
+// notifier when a paid period ends within one of windows
+func NewReminder(r repo.Reminder, subscriptions repo.Subscription, notifier Notifier, windows []time.Duration) Reminder {
+	sorted := make([]time.Duration, len(windows))
+	copy(sorted, windows)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &reminder{r: r, subscriptions: subscriptions, notifier: notifier, windows: sorted}
+}
+
+func (s *reminder) SendExpiryReminders(now time.Time) (int, error) {
+	// a subscription is only reminded of the narrowest window it is in, so a
+	// reminder is not followed by one for a wider window it was sent late for
+	type due struct {
+		subscription *models.Subscription
+		window       string
+		end          time.Time
+	}
+	var dues []due
+	err := s.subscriptions.Each(func(m *models.Subscription) bool {
+		if m.PlanType == models.PlanTypeFree || !live(m) || m.CanceledAt != nil {
+			return true
+		}
+		end := periodEnd(m)
+		if !now.Before(end) {
+			return true
+		}
+		for _, w := range s.windows {
+			if end.Sub(now) <= w {
+				dues = append(dues, due{m, FormatWindow(w), end})
+				break
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	var firstErr error
+	for _, d := range dues {
+		ok, err := s.remind(d.subscription, d.window, d.end)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, firstErr
+}
+
+// remind sends the reminder of a window for a subscription unless it was
+// sent already, and reports whether it sent it.
+func (s *reminder) remind(m *models.Subscription, window string, end time.Time) (bool, error) {
+	latest, err := s.subscriptions.GetLatestByUser(m.UserID)
+	if err != nil {
+		return false, err
+	}
+	if latest.ID != m.ID {
+		// the user moved to another subscription
+		return false, nil
+	}
+	sent, err := s.r.GetBy(func(r *models.Reminder) bool {
+		return r.SubscriptionID == m.ID && r.Kind == models.ReminderKindExpiry && r.Window == window && r.PeriodEnd.Equal(end) &&
+			r.Status != models.ReminderStatusFailed
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(sent) > 0 {
+		return false, nil
+	}
+	n := Notification{
+		UserID:  m.UserID,
+		Subject: "Your subscription is expiring",
+		Body:    fmt.Sprintf("Your %s subscription ends on %s.", m.PlanType, end.Format("2006-01-02 15:04 MST")),
+	}
+	switch {
+	case renewing(m) && m.Status == models.SubscriptionStatusTrialing:
+		n.Subject = "Your trial is ending"
+		n.Body = fmt.Sprintf("Your %s trial ends on %s, when your card is charged.", m.PlanType, end.Format("2006-01-02 15:04 MST"))
+	case renewing(m):
+		n.Subject = "Your subscription is renewing"
+		n.Body = fmt.Sprintf("Your %s subscription renews on %s.", m.PlanType, end.Format("2006-01-02 15:04 MST"))
+	}
+	// the reminder is recorded first, so it is not sent twice if recording
+	// it fails
+	r := &models.Reminder{
+		SubscriptionID: m.ID,
+		UserID:         m.UserID,
+		Kind:           models.ReminderKindExpiry,
+		Window:         window,
+		PeriodEnd:      end,
+		Status:         models.ReminderStatusPending,
+		CreatedAt:      time.Now(),
+	}
+	if err = s.r.Create(r); err != nil {
+		return false, err
+	}
+	if notifyErr := s.notifier.Notify(n); notifyErr != nil {
+		r.Status = models.ReminderStatusFailed
+		r.Error = notifyErr.Error()
+		if err = s.r.Update(r); err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("error notifying user %d: %w", m.UserID, notifyErr)
+	}
+	now := time.Now()
+	r.Status = models.ReminderStatusSent
+	r.SentAt = &now
+	return true, s.r.Update(r)
+}
+
+func (s *reminder) GetBySubscriptionID(id pkg.PrimaryKey) ([]*models.Reminder, error) {
+	return s.r.GetBy(func(m *models.Reminder) bool {
+		return m.SubscriptionID == id
+	})
+}
+
+// ParseWindows parses a comma separated list of durations such as "7d,1d".
+// Durations are in the format of time.ParseDuration, with d for days.
+func ParseWindows(s string) ([]time.Duration, error) {
+	var windows []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		w, err := parseWindow(field)
+		if err != nil {
+			return nil, err
+		}
+		if w <= 0 {
+			return nil, fmt.Errorf("invalid window %q: must be positive", field)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseWindow(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q: %w", s, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	w, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	return w, nil
+}
+
+// FormatWindow formats a window in days when it is a whole number of them
+func FormatWindow(w time.Duration) string {
+	if w%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", w/(24*time.Hour))
+	}
+	return w.String()
+}
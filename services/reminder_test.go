@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/pkg/fault"
+)
+
+func TestReminder_SendExpiryReminders(t *testing.T) {
+	errNotify := errors.New("mail server down")
+	tests := []struct {
+		name string
+		// rule makes a write of the first run fail
+		rule      *fault.Rule
+		notifyErr error
+		wantErr   error
+		// wantSent is the number of notifications sent by the first run
+		wantSent   int
+		wantStatus []models.ReminderStatus
+	}{
+		{
+			name:       "sent",
+			wantSent:   1,
+			wantStatus: []models.ReminderStatus{models.ReminderStatusSent},
+		},
+		{
+			name:       "notifier fails",
+			notifyErr:  errNotify,
+			wantErr:    errNotify,
+			wantStatus: []models.ReminderStatus{models.ReminderStatusFailed, models.ReminderStatusSent},
+		},
+		{
+			name:       "not recorded",
+			rule:       &fault.Rule{Table: "reminder", Op: pkg.OpNameInsert, Nth: 1},
+			wantErr:    fault.ErrInjected,
+			wantStatus: []models.ReminderStatus{models.ReminderStatusSent},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			now := time.Now()
+			m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+			s.lapse(t, m, now.Add(time.Hour))
+			if test.rule != nil {
+				test.rule.Err = fault.ErrInjected
+				s.db.Inject(*test.rule)
+			}
+			s.notifier.err = test.notifyErr
+			sent, err := s.reminders.SendExpiryReminders(now)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("SendExpiryReminders() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if sent != test.wantSent || len(s.notifier.subjects()) != test.wantSent {
+				t.Errorf("sent %d reminders, %d notifications, want %d", sent, len(s.notifier.subjects()), test.wantSent)
+			}
+			// the next runs send the reminder once, if the first did not
+			s.notifier.err = nil
+			for i := 0; i < 2; i++ {
+				if _, err = s.reminders.SendExpiryReminders(now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := len(s.notifier.subjects()); got != 1 {
+				t.Errorf("sent %d notifications, want 1", got)
+			}
+			reminders, err := s.reminders.GetBySubscriptionID(m.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reminders) != len(test.wantStatus) {
+				t.Fatalf("recorded %d reminders, want %d", len(reminders), len(test.wantStatus))
+			}
+			for i, r := range reminders {
+				if r.Status != test.wantStatus[i] {
+					t.Errorf("reminder %d status = %s, want %s", i, r.Status, test.wantStatus[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/repo"
+)
+
+// Renewal is the interface that all renewal services must implement
+type Renewal interface {
+	// RenewDue renews the subscriptions set to renew whose period ended by
+	// now, and returns the number renewed. Subscriptions failing to renew
+	// are left to dunning.
+	RenewDue(now time.Time) (int, error)
+	// Renew charges a subscription for the period following its current one
+	// and extends it if the charge succeeds. The period is invoiced on the
+	// first attempt, and the invoice paid by the one that succeeds. The
+	// attempt is logged either way and returned along with the charge
+	// error.
+	Renew(m *models.Subscription, now time.Time) (*models.Renewal, error)
+	// GetByUserID returns the renewal log of a user
+	GetByUserID(key pkg.PrimaryKey) ([]*models.Renewal, error)
+}
+
+type renewal struct {
+	r             repo.Renewal
+	subscriptions repo.Subscription
+	plans         Plan
+	payments      Payment
+	invoices      Invoice
+	notifier      Notifier
+}
+
+// NewRenewal returns a new Renewal service charging subscriptions through
+// payments, at the price version they subscribed at, and invoicing each
+// period
+func NewRenewal(r repo.Renewal, subscriptions repo.Subscription, plans Plan, payments Payment, invoices Invoice, notifier Notifier) Renewal {
+	return &renewal{r: r, subscriptions: subscriptions, plans: plans, payments: payments, invoices: invoices, notifier: notifier}
+}
+
+func (s *renewal) RenewDue(now time.Time) (int, error) {
+	var due []*models.Subscription
+	err := s.subscriptions.Each(func(m *models.Subscription) bool {
+		if renewing(m) && !now.Before(periodEnd(m)) {
+			due = append(due, m)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	renewed := 0
+	var firstErr error
+	for _, m := range due {
+		ok, err := s.renewDue(m, now)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if ok {
+			renewed++
+		}
+	}
+	return renewed, firstErr
+}
+
+// renewDue renews a subscription whose period ended unless it was tried
+// already, and reports whether it renewed it.
+func (s *renewal) renewDue(m *models.Subscription, now time.Time) (bool, error) {
+	latest, err := s.subscriptions.GetLatestByUser(m.UserID)
+	if err != nil {
+		return false, err
+	}
+	if latest.ID != m.ID {
+		// the user moved to another subscription
+		return false, nil
+	}
+	start := periodEnd(m)
+	tried, err := s.r.GetBy(func(r *models.Renewal) bool {
+		return r.SubscriptionID == m.ID && r.PeriodStart.Equal(start)
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(tried) > 0 {
+		return false, nil
+	}
+	r, err := s.Renew(m, now)
+	if r == nil {
+		return false, err
+	}
+	if err != nil {
+		// the charge failed, dunning retries it
+		return false, nil
+	}
+	err = s.notifier.Notify(Notification{
+		UserID:  m.UserID,
+		Subject: "Your subscription was renewed",
+		Body:    fmt.Sprintf("Your %s subscription was renewed until %s.", m.PlanType, r.PeriodEnd.Format("2006-01-02")),
+	})
+	if err != nil {
+		return true, fmt.Errorf("error notifying user %d: %w", m.UserID, err)
+	}
+	return true, nil
+}
+
+func (s *renewal) Renew(m *models.Subscription, now time.Time) (*models.Renewal, error) {
+	if !canTransition(m.Status, models.SubscriptionStatusActive) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, m.Status, models.SubscriptionStatusActive)
+	}
+	price, err := s.plans.GetPrice(m.PriceID)
+	if err != nil {
+		return nil, err
+	}
+	start := periodEnd(m)
+	r := &models.Renewal{
+		SubscriptionID: m.ID,
+		UserID:         m.UserID,
+		PlanType:       m.PlanType,
+		PeriodStart:    start,
+		PeriodEnd:      start.Add(price.Period()),
+		At:             now,
+	}
+	amount, ok := price.In(m.Currency)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCurrencyNotOffered, m.Currency)
+	}
+	// the credit of the subscription is taken off first
+	credit := m.Credit
+	if credit.Minor > amount.Minor {
+		credit = amount
+	}
+	due, err := amount.Sub(credit)
+	if err != nil {
+		return nil, err
+	}
+	// an earlier attempt which failed left the invoice of the period open
+	inv, err := s.invoices.GetOpen(m.ID, start)
+	if err != nil && !errors.Is(err, pkg.ErrNotFound) {
+		return nil, err
+	}
+	var payment *models.Payment
+	var chargeErr error
+	if due.IsPositive() {
+		payment, chargeErr = s.payments.Charge(m.UserID, m.PaymentSource, due)
+	}
+	if payment != nil {
+		payment.SubscriptionID = m.ID
+		if err := s.payments.Record(payment); err != nil {
+			return nil, err
+		}
+		r.PaymentID = payment.ID
+	}
+	if inv == nil {
+		lines := []models.InvoiceLine{planLine(planName(s.plans, m.PlanType), r.PeriodStart, r.PeriodEnd, amount)}
+		if credit.IsPositive() {
+			lines = append(lines, models.InvoiceLine{Description: "Credit applied", PeriodStart: r.PeriodStart, PeriodEnd: r.PeriodEnd, Amount: credit.Neg()})
+		}
+		paid := payment
+		if chargeErr != nil {
+			paid = nil
+		}
+		if inv, err = s.invoices.Bill(m, r.PeriodStart, r.PeriodEnd, lines, paid, now); err != nil {
+			return nil, err
+		}
+	} else if chargeErr == nil {
+		if err = s.invoices.Pay(inv, payment, now); err != nil {
+			return nil, err
+		}
+	}
+	r.InvoiceID = inv.ID
+	if chargeErr != nil {
+		r.Status = models.RenewalStatusFailed
+		r.Error = chargeErr.Error()
+		if err := s.r.Create(r); err != nil {
+			return nil, err
+		}
+		return r, chargeErr
+	}
+	if err := setStatus(m, models.SubscriptionStatusActive, now); err != nil {
+		return nil, err
+	}
+	m.CurrentPeriodStart = r.PeriodStart
+	m.CurrentPeriodEnd = r.PeriodEnd
+	m.PastDueSince = nil
+	if m.Credit, err = m.Credit.Sub(credit); err != nil {
+		return nil, err
+	}
+	r.CreditApplied = credit
+	if err := s.subscriptions.Update(m); err != nil {
+		return nil, err
+	}
+	r.Status = models.RenewalStatusSucceeded
+	if err := s.r.Create(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *renewal) GetByUserID(id pkg.PrimaryKey) ([]*models.Renewal, error) {
+	return s.r.GetBy(func(m *models.Renewal) bool {
+		return m.UserID == id
+	})
+}
+
+// renewing reports whether a subscription is charged for a new period when
+// its period ends.
+func renewing(m *models.Subscription) bool {
+	return m.PlanType != models.PlanTypeFree && live(m) && m.AutoRenew && m.CanceledAt == nil
+}
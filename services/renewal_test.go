@@ -0,0 +1,143 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"example/models"
+)
+
+func TestRenewal_RenewDue(t *testing.T) {
+	tests := []struct {
+		name      string
+		card      string
+		autoRenew bool
+		// wantRenewed is the number renewed by the first run
+		wantRenewed  int
+		wantLog      []models.RenewalStatus
+		wantInvoices int
+	}{
+		{
+			name:         "renewed",
+			card:         CardSuccess,
+			autoRenew:    true,
+			wantRenewed:  1,
+			wantLog:      []models.RenewalStatus{models.RenewalStatusSucceeded},
+			wantInvoices: 2,
+		},
+		{
+			name:      "declined",
+			card:      CardDeclined,
+			autoRenew: true,
+			wantLog:   []models.RenewalStatus{models.RenewalStatusFailed},
+			// the invoice of the new period is left open
+			wantInvoices: 2,
+		},
+		{
+			name:         "not renewing",
+			card:         CardSuccess,
+			wantInvoices: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			end := time.Now().Add(-time.Hour)
+			m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+			if _, err := s.subscription.SetAutoRenew(m.ID, test.autoRenew); err != nil {
+				t.Fatal(err)
+			}
+			s.lapse(t, m, end)
+			s.setCard(t, m, test.card)
+			renewed, err := s.renewals.RenewDue(end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if renewed != test.wantRenewed {
+				t.Errorf("renewed %d, want %d", renewed, test.wantRenewed)
+			}
+			// a period is renewed, or tried, once
+			for i := 0; i < 2; i++ {
+				if renewed, err = s.renewals.RenewDue(end.Add(time.Minute)); err != nil || renewed != 0 {
+					t.Fatalf("renewing again renewed %d, error %v", renewed, err)
+				}
+			}
+			log, err := s.renewals.GetByUserID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(log) != len(test.wantLog) {
+				t.Fatalf("logged %d renewals, want %d", len(log), len(test.wantLog))
+			}
+			for i, r := range log {
+				if r.Status != test.wantLog[i] {
+					t.Errorf("renewal %d status = %s, want %s", i, r.Status, test.wantLog[i])
+				}
+			}
+			invoices, err := s.invoices.GetByUserID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(invoices) != test.wantInvoices {
+				t.Errorf("issued %d invoices, want %d", len(invoices), test.wantInvoices)
+			}
+			if m, err = s.subscriptions.GetByID(m.ID); err != nil {
+				t.Fatal(err)
+			}
+			if test.wantRenewed > 0 && !m.CurrentPeriodStart.Equal(end) {
+				t.Errorf("period starts at %v, want %v", m.CurrentPeriodStart, end)
+			}
+		})
+	}
+}
+
+func TestRenewal_Renew_Credit(t *testing.T) {
+	tests := []struct {
+		name       string
+		credit     int64
+		wantCharge int64
+		wantCredit int64
+	}{
+		{name: "no credit", wantCharge: 999},
+		{name: "part of the price", credit: 300, wantCharge: 699},
+		{name: "more than the price", credit: 1500, wantCredit: 501},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			end := time.Now().Add(-time.Hour)
+			m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+			m = s.lapse(t, m, end)
+			m.Credit = models.NewMoney(test.credit, models.CurrencyUSD)
+			if err := s.subscriptions.Update(m); err != nil {
+				t.Fatal(err)
+			}
+			r, err := s.renewals.Renew(m, end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.CreditApplied.Minor != test.credit-test.wantCredit {
+				t.Errorf("credit applied = %v, want %d", r.CreditApplied, test.credit-test.wantCredit)
+			}
+			payments, err := s.payments.GetBySubscriptionID(m.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			charged := int64(0)
+			if r.PaymentID != 0 {
+				charged = payments[len(payments)-1].Amount.Minor
+			}
+			if charged != test.wantCharge {
+				t.Errorf("charged %d, want %d", charged, test.wantCharge)
+			}
+			if m, err = s.subscriptions.GetByID(m.ID); err != nil {
+				t.Fatal(err)
+			}
+			if m.Credit.Minor != test.wantCredit {
+				t.Errorf("credit left = %v, want %d", m.Credit, test.wantCredit)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/pkg/fault"
+	"example/repo"
+)
+
+// testNotifier records the notifications it is given, failing with err.
+type testNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+	err  error
+}
+
+func (n *testNotifier) Notify(m Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, m)
+	return nil
+}
+
+func (n *testNotifier) subjects() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	subjects := make([]string, len(n.sent))
+	for i, m := range n.sent {
+		subjects[i] = m.Subject
+	}
+	return subjects
+}
+
+// testServices are the services wired as main does, over an in-memory
+// database faults can be injected into.
+type testServices struct {
+	db            *fault.DB
+	subscriptions repo.Subscription
+	reminderRepo  repo.Reminder
+	invoiceRepo   repo.Invoice
+	gateway       *FakeGateway
+	notifier      *testNotifier
+	plans         Plan
+	payments      Payment
+	invoices      Invoice
+	subscription  Subscription
+	reminders     Reminder
+	renewals      Renewal
+	dunning       Dunning
+}
+
+// dunningSchedule retries a failed renewal one, two and three days after
+// the end of the period.
+var dunningSchedule = []time.Duration{24 * time.Hour, 48 * time.Hour, 72 * time.Hour}
+
+func newTestServices(t *testing.T) *testServices {
+	t.Helper()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &testServices{
+		db:       fault.Wrap(pkg.NewDB()),
+		gateway:  NewFakeGateway(),
+		notifier: &testNotifier{},
+	}
+	var err error
+	s.subscriptions, err = repo.NewSubscription(s.db)
+	must(err)
+	planRepo, err := repo.NewPlan(s.db)
+	must(err)
+	paymentRepo, err := repo.NewPayment(s.db)
+	must(err)
+	planChangeRepo, err := repo.NewPlanChange(s.db)
+	must(err)
+	s.invoiceRepo, err = repo.NewInvoice(s.db)
+	must(err)
+	s.reminderRepo, err = repo.NewReminder(s.db)
+	must(err)
+	renewalRepo, err := repo.NewRenewal(s.db)
+	must(err)
+	dunningRepo, err := repo.NewDunning(s.db)
+	must(err)
+
+	s.plans = NewPlan(planRepo)
+	must(s.plans.Seed())
+	s.payments = NewPayment(paymentRepo, s.gateway)
+	s.invoices = NewInvoice(s.invoiceRepo)
+	s.subscription = NewSubscription(s.subscriptions, planChangeRepo, s.plans, s.payments, s.invoices)
+	s.reminders = NewReminder(s.reminderRepo, s.subscriptions, s.notifier, []time.Duration{24 * time.Hour})
+	s.renewals = NewRenewal(renewalRepo, s.subscriptions, s.plans, s.payments, s.invoices, s.notifier)
+	s.dunning = NewDunning(dunningRepo, s.subscriptions, s.renewals, s.invoices, s.notifier, dunningSchedule)
+	return s
+}
+
+// newSubscription returns a subscription of user to a plan, paid in USD
+// with card, as the endpoint makes it.
+func (s *testServices) newSubscription(user pkg.PrimaryKey, plan models.PlanType, card string) (*models.Subscription, error) {
+	m := &models.Subscription{UserID: user, PlanType: plan, Currency: models.CurrencyUSD, AutoRenew: true}
+	if card == "" {
+		return m, nil
+	}
+	var err error
+	m.PaymentSource, m.CardLast4, err = s.payments.Tokenize(card)
+	return m, err
+}
+
+// subscribe subscribes user to a plan, paid in USD with card.
+func (s *testServices) subscribe(t *testing.T, user pkg.PrimaryKey, plan models.PlanType, card string) *models.Subscription {
+	t.Helper()
+	m, err := s.newSubscription(user, plan, card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.subscription.Create(m); err != nil {
+		t.Fatalf("subscribing user %d to %s: %v", user, plan, err)
+	}
+	return m
+}
+
+// lapse moves the period of a subscription back so it ended at end.
+func (s *testServices) lapse(t *testing.T, m *models.Subscription, end time.Time) *models.Subscription {
+	t.Helper()
+	m, err := s.subscriptions.GetByID(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.CurrentPeriodStart = end.Add(m.CurrentPeriodStart.Sub(m.CurrentPeriodEnd))
+	m.CurrentPeriodEnd = end
+	if err = s.subscriptions.Update(m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// setCard changes the card a subscription is charged to.
+func (s *testServices) setCard(t *testing.T, m *models.Subscription, card string) *models.Subscription {
+	t.Helper()
+	m, err := s.subscriptions.GetByID(m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.PaymentSource, m.CardLast4, err = s.payments.Tokenize(card); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.subscriptions.Update(m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"example/models"
+)
+
+var ErrInvalidTransition = errors.New("invalid subscription status transition")
+
+// transitions lists the statuses a subscription may move to from each
+// status. Canceled and expired subscriptions have ended and move no further.
+var transitions = map[models.SubscriptionStatus][]models.SubscriptionStatus{
+	models.SubscriptionStatusTrialing: {
+		models.SubscriptionStatusActive,
+		models.SubscriptionStatusPastDue,
+		models.SubscriptionStatusCanceled,
+		models.SubscriptionStatusExpired,
+	},
+	// active subscriptions stay active when they are renewed
+	models.SubscriptionStatusActive: {
+		models.SubscriptionStatusActive,
+		models.SubscriptionStatusPastDue,
+		models.SubscriptionStatusCanceled,
+		models.SubscriptionStatusExpired,
+	},
+	models.SubscriptionStatusPastDue: {
+		models.SubscriptionStatusActive,
+		models.SubscriptionStatusCanceled,
+		models.SubscriptionStatusExpired,
+	},
+}
+
+// canTransition reports whether a subscription may move from one status to
+// another.
+func canTransition(from, to models.SubscriptionStatus) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setStatus moves a subscription to a status, recording at as its end if
+// the status ends it.
+func setStatus(m *models.Subscription, to models.SubscriptionStatus, at time.Time) error {
+	if !canTransition(m.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, m.Status, to)
+	}
+	m.Status = to
+	if ended(m) {
+		m.EndedAt = &at
+	}
+	return nil
+}
+
+// live reports whether a subscription gives access to its plan, as long as
+// its period lasts.
+func live(m *models.Subscription) bool {
+	return m.Status == models.SubscriptionStatusTrialing || m.Status == models.SubscriptionStatusActive
+}
+
+// ended reports whether a subscription was canceled or expired.
+func ended(m *models.Subscription) bool {
+	return m.Status == models.SubscriptionStatusCanceled || m.Status == models.SubscriptionStatusExpired
+}
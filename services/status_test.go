@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example/models"
+)
+
+var statuses = []models.SubscriptionStatus{
+	models.SubscriptionStatusTrialing,
+	models.SubscriptionStatusActive,
+	models.SubscriptionStatusPastDue,
+	models.SubscriptionStatusCanceled,
+	models.SubscriptionStatusExpired,
+}
+
+func TestCanTransition(t *testing.T) {
+	// allowed lists every transition allowed; all others are rejected
+	allowed := map[[2]models.SubscriptionStatus]bool{
+		{models.SubscriptionStatusTrialing, models.SubscriptionStatusActive}:   true,
+		{models.SubscriptionStatusTrialing, models.SubscriptionStatusPastDue}:  true,
+		{models.SubscriptionStatusTrialing, models.SubscriptionStatusCanceled}: true,
+		{models.SubscriptionStatusTrialing, models.SubscriptionStatusExpired}:  true,
+		{models.SubscriptionStatusActive, models.SubscriptionStatusActive}:     true,
+		{models.SubscriptionStatusActive, models.SubscriptionStatusPastDue}:    true,
+		{models.SubscriptionStatusActive, models.SubscriptionStatusCanceled}:   true,
+		{models.SubscriptionStatusActive, models.SubscriptionStatusExpired}:    true,
+		{models.SubscriptionStatusPastDue, models.SubscriptionStatusActive}:    true,
+		{models.SubscriptionStatusPastDue, models.SubscriptionStatusCanceled}:  true,
+		{models.SubscriptionStatusPastDue, models.SubscriptionStatusExpired}:   true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]models.SubscriptionStatus{from, to}]
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if canTransition("", models.SubscriptionStatusActive) {
+		t.Errorf("canTransition from no status = true, want false")
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	at := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		from      models.SubscriptionStatus
+		to        models.SubscriptionStatus
+		wantErr   error
+		wantEnded bool
+	}{
+		{name: "trial paid", from: models.SubscriptionStatusTrialing, to: models.SubscriptionStatusActive},
+		{name: "renewed", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusActive},
+		{name: "renewal failed", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusPastDue},
+		{name: "dunning paid", from: models.SubscriptionStatusPastDue, to: models.SubscriptionStatusActive},
+		{name: "canceled", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusCanceled, wantEnded: true},
+		{name: "trial canceled", from: models.SubscriptionStatusTrialing, to: models.SubscriptionStatusCanceled, wantEnded: true},
+		{name: "expired", from: models.SubscriptionStatusPastDue, to: models.SubscriptionStatusExpired, wantEnded: true},
+		{name: "past due trial", from: models.SubscriptionStatusTrialing, to: models.SubscriptionStatusPastDue},
+		{name: "back to trial", from: models.SubscriptionStatusActive, to: models.SubscriptionStatusTrialing, wantErr: ErrInvalidTransition},
+		{name: "past due again", from: models.SubscriptionStatusPastDue, to: models.SubscriptionStatusPastDue, wantErr: ErrInvalidTransition},
+		{name: "canceled reactivated", from: models.SubscriptionStatusCanceled, to: models.SubscriptionStatusActive, wantErr: ErrInvalidTransition},
+		{name: "canceled again", from: models.SubscriptionStatusCanceled, to: models.SubscriptionStatusCanceled, wantErr: ErrInvalidTransition},
+		{name: "expired renewed", from: models.SubscriptionStatusExpired, to: models.SubscriptionStatusActive, wantErr: ErrInvalidTransition},
+		{name: "expired canceled", from: models.SubscriptionStatusExpired, to: models.SubscriptionStatusCanceled, wantErr: ErrInvalidTransition},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &models.Subscription{Status: test.from}
+			err := setStatus(m, test.to, at)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("setStatus(%s, %s) error = %v, wantErr %v", test.from, test.to, err, test.wantErr)
+			}
+			wantStatus := test.to
+			if err != nil {
+				wantStatus = test.from
+			}
+			if m.Status != wantStatus {
+				t.Errorf("status = %s, want %s", m.Status, wantStatus)
+			}
+			switch {
+			case test.wantEnded && (m.EndedAt == nil || !m.EndedAt.Equal(at)):
+				t.Errorf("ended at %v, want %v", m.EndedAt, at)
+			case !test.wantEnded && m.EndedAt != nil:
+				t.Errorf("ended at %v, want not ended", m.EndedAt)
+			}
+		})
+	}
+}
+
+func TestLiveAndEnded(t *testing.T) {
+	tests := []struct {
+		status    models.SubscriptionStatus
+		wantLive  bool
+		wantEnded bool
+	}{
+		{status: models.SubscriptionStatusTrialing, wantLive: true},
+		{status: models.SubscriptionStatusActive, wantLive: true},
+		{status: models.SubscriptionStatusPastDue},
+		{status: models.SubscriptionStatusCanceled, wantEnded: true},
+		{status: models.SubscriptionStatusExpired, wantEnded: true},
+	}
+
+	for _, test := range tests {
+		m := &models.Subscription{Status: test.status}
+		if got := live(m); got != test.wantLive {
+			t.Errorf("live(%s) = %v, want %v", test.status, got, test.wantLive)
+		}
+		if got := ended(m); got != test.wantEnded {
+			t.Errorf("ended(%s) = %v, want %v", test.status, got, test.wantEnded)
+		}
+	}
+}
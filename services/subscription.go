@@ -2,6 +2,8 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"example/models"
@@ -12,59 +14,220 @@ import (
 var (
 	ErrNoActiveSubscription = errors.New("no active subscription")
 	ErrInvalidPlanType      = errors.New("invalid plan type")
+	ErrAlreadyCanceled      = errors.New("subscription already canceled")
+	ErrCancelFree           = errors.New("free subscriptions cannot be canceled")
+	ErrSubscriptionEnded    = errors.New("subscription has ended")
+	ErrRenewFree            = errors.New("free subscriptions are not renewed")
+	ErrTrialFree            = errors.New("free plans have no trial")
+	ErrSamePlan             = errors.New("subscription is already on this plan")
+	ErrChangeFree           = errors.New("free subscriptions change plan by subscribing to a paid one")
+	ErrChangeToFree         = errors.New("subscriptions move to the free plan by being canceled")
+	ErrCurrencyNotOffered   = errors.New("plan is not sold in this currency")
 )
 
-const planDuration = 30 * 24 * time.Hour
-
-var plans = map[models.PlanType]models.Plan{
-	models.PlanTypeFree: {
-		Type:     models.PlanTypeFree,
-		Price:    0,
-		Duration: 0,
-	},
-	models.PlanTypeBasic: {
-		Type:     models.PlanTypeBasic,
-		Price:    9.99,
-		Duration: planDuration,
-	},
-	models.PlanTypePremium: {
-		Type:     models.PlanTypePremium,
-		Price:    19.99,
-		Duration: planDuration,
-	},
-}
-
 type subscription struct {
-	r repo.Subscription
+	r        repo.Subscription
+	changes  repo.PlanChange
+	plans    Plan
+	payments Payment
+	invoices Invoice
+	// endMu keeps concurrent cancellations and EndDue runs from ending a
+	// subscription, and moving its user to the free plan, twice
+	endMu sync.Mutex
 }
 
 // Subscription is the interface that all subscription services must implement
 type Subscription interface {
-	// Create creates a new subscription
+	// Create creates a new subscription, charging its payment source first
+	// for paid plans and invoicing the period paid, and ends the
+	// subscription it replaces
 	Create(*models.Subscription) error
+	// StartTrial creates a paid subscription charged to its payment source
+	// only when the trial ends
+	StartTrial(m *models.Subscription, trial time.Duration) error
 	// GetByID returns a subscription by its ID
 	GetByID(key pkg.PrimaryKey) (*models.Subscription, error)
 	// GetByUserID returns a subscription by its user ID
 	GetByUserID(key pkg.PrimaryKey) ([]*models.Subscription, error)
 	// GetByPlanType returns a subscription by its plan type
 	GetByPlanType(planType models.PlanType) ([]*models.Subscription, error)
-	// GetActiveForUser returns the active subscription for a user
+	// GetByStatus returns the subscriptions in a status
+	GetByStatus(status models.SubscriptionStatus) ([]*models.Subscription, error)
+	// GetActiveForUser returns the active subscription for a user. It writes
+	// nothing: a subscription canceled at the end of a period that is over
+	// gives the free plan, which EndDue later records
 	GetActiveForUser(key pkg.PrimaryKey) (*models.Subscription, error)
+	// Cancel cancels a subscription, immediately or at the end of its paid
+	// period, after which the user is on the free plan
+	Cancel(key pkg.PrimaryKey, atPeriodEnd bool, reason string) (*models.Subscription, error)
+	// ChangePlan moves a paid subscription to another paid plan for the rest
+	// of its period, charging the difference in price for that time or
+	// crediting it to the subscription of the new plan
+	ChangePlan(key pkg.PrimaryKey, planType models.PlanType) (*models.Subscription, *models.PlanChange, error)
+	// GetPlanChangesByUserID returns the plan changes of a user
+	GetPlanChangesByUserID(key pkg.PrimaryKey) ([]*models.PlanChange, error)
+	// SetAutoRenew sets whether a paid subscription is renewed when its
+	// period ends
+	SetAutoRenew(key pkg.PrimaryKey, autoRenew bool) (*models.Subscription, error)
 	// Find returns all subscriptions
 	Find() ([]*models.Subscription, error)
+	// FindWithUsers returns all subscriptions with the username of their user
+	FindWithUsers() ([]*models.SubscriptionWithUser, error)
+	// Each streams all subscriptions to f in ID order until f returns false
+	Each(f func(*models.Subscription) bool) error
+	// EndDue ends the subscriptions that are not renewed and whose period
+	// ended by now, and returns the number ended
+	EndDue(now time.Time) (int, error)
 }
 
-// NewSubscription returns a new Subscription service
-func NewSubscription(r repo.Subscription) Subscription {
-	return &subscription{r}
+// NewSubscription returns a new Subscription service selling the plans of
+// the catalog, charging them through payments and billing them on invoices
+func NewSubscription(r repo.Subscription, changes repo.PlanChange, plans Plan, payments Payment, invoices Invoice) Subscription {
+	return &subscription{r: r, changes: changes, plans: plans, payments: payments, invoices: invoices}
 }
 
 func (s *subscription) Create(m *models.Subscription) error {
-	if _, ok := plans[m.PlanType]; !ok {
-		return ErrInvalidPlanType
+	if !m.Currency.Valid() {
+		return fmt.Errorf("%w: %q", models.ErrUnknownCurrency, m.Currency)
+	}
+	plan, err := s.plans.GetAvailable(m.PlanType)
+	if err != nil {
+		return err
+	}
+	m.Status = models.SubscriptionStatusActive
+	m.Credit = models.NewMoney(0, m.Currency)
+	if m.PlanType == models.PlanTypeFree {
+		m.PriceID = 0
+		m.PaymentSource = ""
+		m.CardLast4 = ""
+		m.AutoRenew = false
+		m.CreatedAt = time.Now()
+		m.CurrentPeriodStart = m.CreatedAt
+		m.CurrentPeriodEnd = time.Time{}
+		return s.replace(m, nil)
+	}
+	amount, ok := plan.Price.In(m.Currency)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrCurrencyNotOffered, m.Currency)
+	}
+	payment, err := s.payments.Charge(m.UserID, m.PaymentSource, amount)
+	if errors.Is(err, ErrPaymentRequired) {
+		return err
+	}
+	if err != nil {
+		if recordErr := s.payments.Record(payment); recordErr != nil {
+			return recordErr
+		}
+		return err
+	}
+	m.PriceID = plan.Price.ID
+	m.CreatedAt = payment.CreatedAt
+	m.CurrentPeriodStart = m.CreatedAt
+	m.CurrentPeriodEnd = m.CreatedAt.Add(plan.Price.Period())
+	err = s.replace(m, func() error {
+		payment.SubscriptionID = m.ID
+		if err := s.payments.Record(payment); err != nil {
+			return err
+		}
+		lines := []models.InvoiceLine{planLine(plan.Name, m.CurrentPeriodStart, m.CurrentPeriodEnd, amount)}
+		_, err := s.invoices.Bill(m, m.CurrentPeriodStart, m.CurrentPeriodEnd, lines, payment, m.CreatedAt)
+		return err
+	})
+	if err != nil {
+		return s.refund(payment, 0, err)
+	}
+	return nil
+}
+
+// refund gives back a payment taken for a subscription that failed to be
+// stored with err, so the user is not charged for a plan they do not get,
+// and records it against the subscription owner, 0 for none. It returns
+// err.
+func (s *subscription) refund(payment *models.Payment, owner pkg.PrimaryKey, err error) error {
+	if refundErr := s.payments.Refund(payment); refundErr != nil {
+		return fmt.Errorf("%w; %v", err, refundErr)
+	}
+	payment.SubscriptionID = owner
+	// the store failed already, so recording the refund is best effort
+	_ = s.payments.Record(payment)
+	return err
+}
+
+func (s *subscription) StartTrial(m *models.Subscription, trial time.Duration) error {
+	if m.PlanType == models.PlanTypeFree {
+		return ErrTrialFree
 	}
+	plan, err := s.plans.GetAvailable(m.PlanType)
+	if err != nil {
+		return err
+	}
+	if _, ok := plan.Price.In(m.Currency); !ok {
+		return fmt.Errorf("%w: %s", ErrCurrencyNotOffered, m.Currency)
+	}
+	if m.PaymentSource == "" {
+		return ErrPaymentRequired
+	}
+	m.Status = models.SubscriptionStatusTrialing
+	m.PriceID = plan.Price.ID
+	m.Credit = models.NewMoney(0, m.Currency)
 	m.CreatedAt = time.Now()
-	return s.r.Create(m)
+	m.CurrentPeriodStart = m.CreatedAt
+	m.CurrentPeriodEnd = m.CreatedAt.Add(trial)
+	return s.replace(m, nil)
+}
+
+// replace creates a subscription, calls store, if not nil, to store what
+// goes with it, and then cancels the subscription of its user it replaces,
+// if it still runs. If any of it fails the new subscription is deleted
+// again, so the user keeps the one they had.
+func (s *subscription) replace(m *models.Subscription, store func() error) error {
+	prev, err := s.r.GetLatestByUser(m.UserID)
+	if err != nil && !errors.Is(err, pkg.ErrNotFound) {
+		return err
+	}
+	if err = s.r.Create(m); err != nil {
+		return err
+	}
+	if store != nil {
+		err = store()
+	}
+	if err == nil {
+		err = s.cancelReplaced(prev, m)
+	}
+	if err != nil {
+		if deleteErr := s.r.Delete(m.ID); deleteErr != nil {
+			return fmt.Errorf("%w; %v", err, deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// cancelReplaced cancels prev, replaced by m, and voids its open invoices.
+// prev is left as it was if that fails.
+func (s *subscription) cancelReplaced(prev, m *models.Subscription) error {
+	if prev == nil || ended(prev) {
+		return nil
+	}
+	was := *prev
+	if prev.CanceledAt == nil {
+		prev.CanceledAt = &m.CreatedAt
+		prev.CancelReason = fmt.Sprintf("replaced by subscription %d", m.ID)
+	}
+	if err := setStatus(prev, models.SubscriptionStatusCanceled, m.CreatedAt); err != nil {
+		return err
+	}
+	if err := s.r.Update(prev); err != nil {
+		return err
+	}
+	// a past due subscription will not be paid any more
+	if err := s.invoices.VoidOpen(prev.ID, m.CreatedAt); err != nil {
+		if restoreErr := s.r.Update(&was); restoreErr != nil {
+			return fmt.Errorf("%w; %v", err, restoreErr)
+		}
+		return err
+	}
+	return nil
 }
 
 func (s *subscription) GetByID(id pkg.PrimaryKey) (*models.Subscription, error) {
@@ -83,25 +246,363 @@ func (s *subscription) GetByPlanType(planType models.PlanType) ([]*models.Subscr
 	})
 }
 
+func (s *subscription) GetByStatus(status models.SubscriptionStatus) ([]*models.Subscription, error) {
+	return s.r.GetBy(func(m *models.Subscription) bool {
+		return m.Status == status
+	})
+}
+
 func (s *subscription) GetActiveForUser(id pkg.PrimaryKey) (*models.Subscription, error) {
-	subs, err := s.r.GetBy(func(m *models.Subscription) bool {
+	lastSub, err := s.r.GetLatestByUser(id)
+	if errors.Is(err, pkg.ErrNotFound) {
+		return nil, ErrNoActiveSubscription
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if endsAtPeriodEnd(lastSub) && !now.Before(periodEnd(lastSub)) && lastSub.CanceledAt != nil {
+		return freeAfter(lastSub, periodEnd(lastSub)), nil
+	}
+	if !live(lastSub) || (lastSub.PlanType != models.PlanTypeFree && !now.Before(periodEnd(lastSub))) {
+		return nil, ErrNoActiveSubscription
+	}
+	return lastSub, nil
+}
+
+func (s *subscription) Cancel(id pkg.PrimaryKey, atPeriodEnd bool, reason string) (*models.Subscription, error) {
+	sub, err := s.r.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if sub.CanceledAt != nil {
+		return nil, ErrAlreadyCanceled
+	}
+	if sub.PlanType == models.PlanTypeFree {
+		return nil, ErrCancelFree
+	}
+	now := time.Now()
+	latest, err := s.r.GetLatestByUser(sub.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if latest.ID != sub.ID || ended(sub) {
+		return nil, ErrSubscriptionEnded
+	}
+	if atPeriodEnd && (!live(sub) || !now.Before(periodEnd(sub))) {
+		return nil, ErrSubscriptionEnded
+	}
+	sub.CanceledAt = &now
+	sub.CancelReason = reason
+	sub.CancelAtPeriodEnd = atPeriodEnd
+	if !atPeriodEnd {
+		if err = setStatus(sub, models.SubscriptionStatusCanceled, now); err != nil {
+			return nil, err
+		}
+	}
+	if err = s.r.Update(sub); err != nil {
+		return nil, err
+	}
+	if !atPeriodEnd {
+		if err = s.invoices.VoidOpen(sub.ID, now); err != nil {
+			return nil, err
+		}
+		s.endMu.Lock()
+		defer s.endMu.Unlock()
+		if _, err = s.fallBackToFree(sub, now); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+func (s *subscription) ChangePlan(id pkg.PrimaryKey, planType models.PlanType) (*models.Subscription, *models.PlanChange, error) {
+	sub, err := s.r.GetByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case sub.PlanType == models.PlanTypeFree:
+		return nil, nil, ErrChangeFree
+	case planType == models.PlanTypeFree:
+		return nil, nil, ErrChangeToFree
+	case sub.PlanType == planType:
+		return nil, nil, ErrSamePlan
+	case sub.CanceledAt != nil:
+		return nil, nil, ErrAlreadyCanceled
+	}
+	now := time.Now()
+	latest, err := s.r.GetLatestByUser(sub.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if latest.ID != sub.ID || !live(sub) || !now.Before(periodEnd(sub)) {
+		return nil, nil, ErrSubscriptionEnded
+	}
+	plan, err := s.plans.GetAvailable(planType)
+	if err != nil {
+		return nil, nil, err
+	}
+	price, err := s.plans.GetPrice(sub.PriceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	oldAmount, ok := price.In(sub.Currency)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrCurrencyNotOffered, sub.Currency)
+	}
+	newAmount, ok := plan.Price.In(sub.Currency)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrCurrencyNotOffered, sub.Currency)
+	}
+	zero := models.NewMoney(0, sub.Currency)
+	next := &models.Subscription{
+		UserID:             sub.UserID,
+		PlanType:           planType,
+		Status:             sub.Status,
+		CreatedAt:          now,
+		CurrentPeriodStart: now,
+		CurrentPeriodEnd:   sub.CurrentPeriodEnd,
+		PaymentSource:      sub.PaymentSource,
+		CardLast4:          sub.CardLast4,
+		AutoRenew:          sub.AutoRenew,
+		PriceID:            plan.Price.ID,
+		PreviousID:         sub.ID,
+		Currency:           sub.Currency,
+		Credit:             sub.Credit,
+	}
+	change := &models.PlanChange{
+		UserID:             sub.UserID,
+		FromSubscriptionID: sub.ID,
+		FromPlan:           sub.PlanType,
+		ToPlan:             planType,
+		UnusedCredit:       zero,
+		RemainingCost:      zero,
+		Amount:             zero,
+		Charged:            zero,
+		At:                 now,
+	}
+	// trials have not been paid for, so there is nothing to prorate
+	if sub.Status == models.SubscriptionStatusActive {
+		// the period may have started on a previous plan change, so the
+		// unused time is a share of a whole billing period of the old price,
+		// and the new plan is priced per day
+		unused := sub.CurrentPeriodEnd.Sub(now)
+		if change.UnusedCredit, err = oldAmount.Prorate(unused, price.Period()); err != nil {
+			return nil, nil, err
+		}
+		if change.RemainingCost, err = newAmount.Prorate(unused, plan.Price.Period()); err != nil {
+			return nil, nil, err
+		}
+		if change.Amount, err = change.RemainingCost.Sub(change.UnusedCredit); err != nil {
+			return nil, nil, err
+		}
+		if next.Credit, err = next.Credit.Sub(change.Amount); err != nil {
+			return nil, nil, err
+		}
+		if next.Credit.IsNegative() {
+			change.Charged = next.Credit.Neg()
+			next.Credit = zero
+		}
+	}
+	var payment *models.Payment
+	if change.Charged.IsPositive() {
+		payment, err = s.payments.Charge(sub.UserID, sub.PaymentSource, change.Charged)
+		if err != nil {
+			if payment != nil {
+				payment.SubscriptionID = sub.ID
+				if recordErr := s.payments.Record(payment); recordErr != nil {
+					return nil, nil, recordErr
+				}
+			}
+			return nil, nil, err
+		}
+	}
+	err = s.replace(next, func() error {
+		return s.storeChange(sub, next, change, payment, plan.Name, now)
+	})
+	if err != nil {
+		if payment != nil {
+			return nil, nil, s.refund(payment, sub.ID, err)
+		}
+		return nil, nil, err
+	}
+	return next, change, nil
+}
+
+// storeChange stores the payment for a plan change to next, stored
+// already, its invoice and the change itself.
+func (s *subscription) storeChange(from, next *models.Subscription, change *models.PlanChange, payment *models.Payment, toName string, now time.Time) error {
+	if payment != nil {
+		payment.SubscriptionID = next.ID
+		if err := s.payments.Record(payment); err != nil {
+			return err
+		}
+		change.PaymentID = payment.ID
+	}
+	change.ToSubscriptionID = next.ID
+	if from.Status == models.SubscriptionStatusActive {
+		lines, err := s.changeLines(from, next, change, toName)
+		if err != nil {
+			return err
+		}
+		inv, err := s.invoices.Bill(next, now, next.CurrentPeriodEnd, lines, payment, now)
+		if err != nil {
+			return err
+		}
+		change.InvoiceID = inv.ID
+	}
+	return s.changes.Create(change)
+}
+
+// changeLines returns the invoice lines of a prorated plan change: the rest
+// of the period on the new plan, the unused time on the old one, and the
+// credit taken off or added to the subscription.
+func (s *subscription) changeLines(from, to *models.Subscription, change *models.PlanChange, toName string) ([]models.InvoiceLine, error) {
+	fromName := planName(s.plans, from.PlanType)
+	start, end := to.CurrentPeriodStart, to.CurrentPeriodEnd
+	lines := []models.InvoiceLine{
+		planLine(toName, start, end, change.RemainingCost),
+		{
+			Description: fmt.Sprintf("Unused time on %s plan, %s to %s", fromName, start.Format("2006-01-02"), end.Format("2006-01-02")),
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Amount:      change.UnusedCredit.Neg(),
+		},
+	}
+	adjustment, err := change.Charged.Sub(change.Amount)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case adjustment.IsNegative():
+		lines = append(lines, models.InvoiceLine{Description: "Credit applied", PeriodStart: start, PeriodEnd: end, Amount: adjustment})
+	case adjustment.IsPositive():
+		lines = append(lines, models.InvoiceLine{Description: "Credited to later renewals", PeriodStart: start, PeriodEnd: end, Amount: adjustment})
+	}
+	return lines, nil
+}
+
+func (s *subscription) GetPlanChangesByUserID(id pkg.PrimaryKey) ([]*models.PlanChange, error) {
+	return s.changes.GetBy(func(m *models.PlanChange) bool {
 		return m.UserID == id
 	})
+}
+
+func (s *subscription) SetAutoRenew(id pkg.PrimaryKey, autoRenew bool) (*models.Subscription, error) {
+	sub, err := s.r.GetByID(id)
 	if err != nil {
 		return nil, err
 	}
-	if len(subs) == 0 {
-		return nil, ErrNoActiveSubscription
+	if sub.PlanType == models.PlanTypeFree {
+		return nil, ErrRenewFree
 	}
-	lastSub := subs[len(subs)-1]
-	active := true
-	if lastSub.PlanType != models.PlanTypeFree {
-		active = lastSub.CreatedAt.Add(plans[lastSub.PlanType].Duration).After(time.Now())
+	if sub.CanceledAt != nil {
+		return nil, ErrAlreadyCanceled
 	}
-	if !active {
-		return nil, ErrNoActiveSubscription
+	latest, err := s.r.GetLatestByUser(sub.UserID)
+	if err != nil {
+		return nil, err
 	}
-	return lastSub, nil
+	if latest.ID != sub.ID || !live(sub) || !time.Now().Before(periodEnd(sub)) {
+		return nil, ErrSubscriptionEnded
+	}
+	sub.AutoRenew = autoRenew
+	if err = s.r.Update(sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *subscription) EndDue(now time.Time) (int, error) {
+	var due []*models.Subscription
+	err := s.r.Each(func(m *models.Subscription) bool {
+		if endsAtPeriodEnd(m) && !now.Before(periodEnd(m)) {
+			due = append(due, m)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range due {
+		if _, err = s.end(m); err != nil {
+			return 0, err
+		}
+	}
+	return len(due), nil
+}
+
+// end ends a subscription at the end of its period, canceling it if its
+// user canceled it and letting it expire otherwise. It returns the latest
+// subscription of the user, on the free plan after a cancellation.
+func (s *subscription) end(m *models.Subscription) (*models.Subscription, error) {
+	s.endMu.Lock()
+	defer s.endMu.Unlock()
+	m, err := s.r.GetByID(m.ID)
+	if err != nil {
+		return nil, err
+	}
+	if endsAtPeriodEnd(m) {
+		end := periodEnd(m)
+		to := models.SubscriptionStatusExpired
+		if m.CanceledAt != nil {
+			to = models.SubscriptionStatusCanceled
+		}
+		if err = setStatus(m, to, end); err != nil {
+			return nil, err
+		}
+		if err = s.r.Update(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.Status != models.SubscriptionStatusCanceled {
+		return s.r.GetLatestByUser(m.UserID)
+	}
+	return s.fallBackToFree(m, *m.EndedAt)
+}
+
+// fallBackToFree puts the user of a canceled subscription on the free plan
+// from end, unless it already moved to another plan. Callers hold endMu.
+func (s *subscription) fallBackToFree(canceled *models.Subscription, end time.Time) (*models.Subscription, error) {
+	latest, err := s.r.GetLatestByUser(canceled.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if latest.ID != canceled.ID {
+		return latest, nil
+	}
+	free := freeAfter(canceled, end)
+	if err = s.r.Create(free); err != nil {
+		return nil, err
+	}
+	return free, nil
+}
+
+// freeAfter returns the free subscription the user of a subscription that
+// ended is on from end.
+func freeAfter(prev *models.Subscription, end time.Time) *models.Subscription {
+	return &models.Subscription{
+		UserID:             prev.UserID,
+		PlanType:           models.PlanTypeFree,
+		Currency:           prev.Currency,
+		Credit:             models.NewMoney(0, prev.Currency),
+		Status:             models.SubscriptionStatusActive,
+		CreatedAt:          end,
+		CurrentPeriodStart: end,
+	}
+}
+
+// periodEnd returns when the current period of a subscription ends. It is
+// meaningless for free subscriptions, which do not end.
+func periodEnd(m *models.Subscription) time.Time {
+	return m.CurrentPeriodEnd
+}
+
+// endsAtPeriodEnd reports whether a running paid subscription ends with its
+// period rather than being renewed.
+func endsAtPeriodEnd(m *models.Subscription) bool {
+	return m.PlanType != models.PlanTypeFree && live(m) && !renewing(m)
 }
 
 func (s *subscription) Find() ([]*models.Subscription, error) {
@@ -109,3 +610,11 @@ func (s *subscription) Find() ([]*models.Subscription, error) {
 		return true
 	})
 }
+
+func (s *subscription) FindWithUsers() ([]*models.SubscriptionWithUser, error) {
+	return s.r.FindWithUsers()
+}
+
+func (s *subscription) Each(f func(*models.Subscription) bool) error {
+	return s.r.Each(f)
+}
@@ -0,0 +1,434 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example/models"
+	"example/pkg"
+	"example/pkg/fault"
+)
+
+func TestSubscription_GetActiveForUser(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup changes the subscription of user 1 before it is read
+		setup    func(*testing.T, *testServices, *models.Subscription)
+		wantPlan models.PlanType
+		wantErr  error
+	}{
+		{
+			name:     "running",
+			setup:    func(*testing.T, *testServices, *models.Subscription) {},
+			wantPlan: models.PlanTypeBasic,
+		},
+		{
+			name: "canceled and running",
+			setup: func(t *testing.T, s *testServices, m *models.Subscription) {
+				if _, err := s.subscription.Cancel(m.ID, true, "too expensive"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantPlan: models.PlanTypeBasic,
+		},
+		{
+			name: "canceled and over",
+			setup: func(t *testing.T, s *testServices, m *models.Subscription) {
+				if _, err := s.subscription.Cancel(m.ID, true, "too expensive"); err != nil {
+					t.Fatal(err)
+				}
+				s.lapse(t, m, time.Now().Add(-time.Hour))
+			},
+			wantPlan: models.PlanTypeFree,
+		},
+		{
+			name: "over",
+			setup: func(t *testing.T, s *testServices, m *models.Subscription) {
+				s.lapse(t, m, time.Now().Add(-time.Hour))
+			},
+			wantErr: ErrNoActiveSubscription,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			m := s.subscribe(t, 1, models.PlanTypeBasic, CardSuccess)
+			test.setup(t, s, m)
+			// reads must work on a follower, where every write fails
+			s.db.Inject(fault.Rule{Op: pkg.OpNameInsert, Err: pkg.ErrReadOnly})
+			s.db.Inject(fault.Rule{Op: pkg.OpNameUpdate, Err: pkg.ErrReadOnly})
+			before, err := s.subscription.GetByUserID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				got, err := s.subscription.GetActiveForUser(1)
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("GetActiveForUser() error = %v, wantErr %v", err, test.wantErr)
+				}
+				if err == nil && got.PlanType != test.wantPlan {
+					t.Errorf("GetActiveForUser() plan = %s, want %s", got.PlanType, test.wantPlan)
+				}
+			}
+			after, err := s.subscription.GetByUserID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != len(before) {
+				t.Errorf("reading created %d subscriptions", len(after)-len(before))
+			}
+		})
+	}
+}
+
+func TestSubscription_Cancel(t *testing.T) {
+	tests := []struct {
+		name        string
+		plan        models.PlanType
+		atPeriodEnd bool
+		// setup changes the subscription of user 1 before it is canceled
+		setup   func(*testing.T, *testServices, *models.Subscription)
+		wantErr error
+		// wantPlan is the plan the user is on after the cancellation
+		wantPlan models.PlanType
+	}{
+		{
+			name:     "immediately",
+			plan:     models.PlanTypeBasic,
+			wantPlan: models.PlanTypeFree,
+		},
+		{
+			name:        "at period end",
+			plan:        models.PlanTypeBasic,
+			atPeriodEnd: true,
+			wantPlan:    models.PlanTypeBasic,
+		},
+		{
+			name: "twice",
+			plan: models.PlanTypeBasic,
+			setup: func(t *testing.T, s *testServices, m *models.Subscription) {
+				if _, err := s.subscription.Cancel(m.ID, true, ""); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrAlreadyCanceled,
+		},
+		{
+			name:    "free",
+			plan:    models.PlanTypeFree,
+			wantErr: ErrCancelFree,
+		},
+		{
+			name: "expired",
+			plan: models.PlanTypeBasic,
+			setup: func(t *testing.T, s *testServices, m *models.Subscription) {
+				if _, err := s.subscription.SetAutoRenew(m.ID, false); err != nil {
+					t.Fatal(err)
+				}
+				s.lapse(t, m, time.Now().Add(-time.Hour))
+				if _, err := s.subscription.EndDue(time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrSubscriptionEnded,
+		},
+		{
+			// subscribing to another plan cancels the one it replaces
+			name: "replaced",
+			plan: models.PlanTypeBasic,
+			setup: func(t *testing.T, s *testServices, m *models.Subscription) {
+				s.subscribe(t, 1, models.PlanTypePremium, CardSuccess)
+			},
+			wantErr: ErrAlreadyCanceled,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			m := s.subscribe(t, 1, test.plan, CardSuccess)
+			if test.setup != nil {
+				test.setup(t, s, m)
+			}
+			got, err := s.subscription.Cancel(m.ID, test.atPeriodEnd, "too expensive")
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Cancel() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.CanceledAt == nil || got.CancelReason != "too expensive" {
+				t.Errorf("canceled at %v for %q", got.CanceledAt, got.CancelReason)
+			}
+			active, err := s.subscription.GetActiveForUser(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active.PlanType != test.wantPlan {
+				t.Errorf("active plan = %s, want %s", active.PlanType, test.wantPlan)
+			}
+		})
+	}
+}
+
+func TestSubscription_Create(t *testing.T) {
+	tests := []struct {
+		name string
+		card string
+		// rule makes a write after the charge fail
+		rule    *fault.Rule
+		wantErr error
+		// wantPayment is the status of the payment recorded, if any
+		wantPayment models.PaymentStatus
+		wantRefunds int
+		// wantStored is whether the subscription replaced the free one
+		wantStored bool
+	}{
+		{
+			name:        "paid",
+			card:        CardSuccess,
+			wantPayment: models.PaymentStatusSucceeded,
+			wantStored:  true,
+		},
+		{
+			name:        "retried",
+			card:        CardRetry,
+			wantPayment: models.PaymentStatusSucceeded,
+			wantStored:  true,
+		},
+		{
+			name:        "declined",
+			card:        CardDeclined,
+			wantErr:     ErrCardDeclined,
+			wantPayment: models.PaymentStatusFailed,
+		},
+		{
+			name:        "timed out",
+			card:        CardTimeout,
+			wantErr:     ErrGatewayTimeout,
+			wantPayment: models.PaymentStatusFailed,
+		},
+		{
+			name:    "no card",
+			wantErr: ErrPaymentRequired,
+		},
+		{
+			name:        "subscription not stored",
+			card:        CardSuccess,
+			rule:        &fault.Rule{Table: "subscription", Op: pkg.OpNameInsert},
+			wantErr:     fault.ErrInjected,
+			wantPayment: models.PaymentStatusRefunded,
+			wantRefunds: 1,
+		},
+		{
+			name:        "payment not recorded",
+			card:        CardSuccess,
+			rule:        &fault.Rule{Table: "payment", Op: pkg.OpNameInsert, Nth: 1},
+			wantErr:     fault.ErrInjected,
+			wantPayment: models.PaymentStatusRefunded,
+			wantRefunds: 1,
+		},
+		{
+			name:        "invoice not stored",
+			card:        CardSuccess,
+			rule:        &fault.Rule{Table: "invoice", Op: pkg.OpNameInsert},
+			wantErr:     fault.ErrInjected,
+			wantPayment: models.PaymentStatusRefunded,
+			wantRefunds: 1,
+		},
+		{
+			name:        "free subscription not canceled",
+			card:        CardSuccess,
+			rule:        &fault.Rule{Table: "subscription", Op: pkg.OpNameUpdate},
+			wantErr:     fault.ErrInjected,
+			wantPayment: models.PaymentStatusRefunded,
+			wantRefunds: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			free := s.subscribe(t, 1, models.PlanTypeFree, "")
+			m, err := s.newSubscription(1, models.PlanTypeBasic, test.card)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.rule != nil {
+				test.rule.Err = fault.ErrInjected
+				s.db.Inject(*test.rule)
+			}
+			err = s.subscription.Create(m)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Create() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got := s.gateway.Refunded(); got != test.wantRefunds {
+				t.Errorf("refunded %d charges, want %d", got, test.wantRefunds)
+			}
+			// payments for a subscription that was not stored belong to none
+			var paidFor pkg.PrimaryKey
+			if test.wantStored {
+				paidFor = m.ID
+			}
+			payments, err := s.payments.GetBySubscriptionID(paidFor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case test.wantPayment == "" && len(payments) > 0:
+				t.Errorf("recorded %d payments, want none", len(payments))
+			case test.wantPayment != "" && len(payments) != 1:
+				t.Errorf("recorded %d payments, want 1", len(payments))
+			case test.wantPayment != "" && payments[0].Status != test.wantPayment:
+				t.Errorf("payment status = %s, want %s", payments[0].Status, test.wantPayment)
+			}
+			subs, err := s.subscription.GetByUserID(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			active, err := s.subscription.GetActiveForUser(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.wantStored {
+				// the user keeps the free plan they were on
+				if len(subs) != 1 || subs[0].Status != models.SubscriptionStatusActive {
+					t.Errorf("stored %d subscriptions, want the free one still active", len(subs))
+				}
+				if active.ID != free.ID {
+					t.Errorf("active subscription = %d, want %d", active.ID, free.ID)
+				}
+				return
+			}
+			if len(subs) != 2 || subs[0].Status != models.SubscriptionStatusCanceled {
+				t.Fatalf("stored %d subscriptions, want the free one canceled and a new one", len(subs))
+			}
+			if active.ID != m.ID || active.Status != models.SubscriptionStatusActive {
+				t.Errorf("active subscription = %d %s, want %d active", active.ID, active.Status, m.ID)
+			}
+			// only the gateway knows the card number
+			if active.PaymentSource == test.card || active.CardLast4 != test.card[len(test.card)-4:] {
+				t.Errorf("stored payment source %q, last 4 %q", active.PaymentSource, active.CardLast4)
+			}
+		})
+	}
+}
+
+func TestSubscription_ChangePlan(t *testing.T) {
+	tests := []struct {
+		name string
+		from models.PlanType
+		to   models.PlanType
+		card string
+		// rule makes a write after the charge fail
+		rule        *fault.Rule
+		wantErr     error
+		wantCharged int64
+		wantCredit  int64
+		wantRefunds int
+	}{
+		{
+			// a third of the period is left: 666 of premium less 333 of
+			// basic unused
+			name:        "upgrade",
+			from:        models.PlanTypeBasic,
+			to:          models.PlanTypePremium,
+			card:        CardSuccess,
+			wantCharged: 333,
+		},
+		{
+			name:       "downgrade",
+			from:       models.PlanTypePremium,
+			to:         models.PlanTypeBasic,
+			card:       CardSuccess,
+			wantCredit: 333,
+		},
+		{
+			name:    "upgrade declined",
+			from:    models.PlanTypeBasic,
+			to:      models.PlanTypePremium,
+			card:    CardDeclined,
+			wantErr: ErrCardDeclined,
+		},
+		{
+			name:        "upgrade not stored",
+			from:        models.PlanTypeBasic,
+			to:          models.PlanTypePremium,
+			card:        CardSuccess,
+			rule:        &fault.Rule{Table: "plan_change", Op: pkg.OpNameInsert},
+			wantErr:     fault.ErrInjected,
+			wantRefunds: 1,
+		},
+		{
+			name:        "upgrade not invoiced",
+			from:        models.PlanTypeBasic,
+			to:          models.PlanTypePremium,
+			card:        CardSuccess,
+			rule:        &fault.Rule{Table: "invoice", Op: pkg.OpNameInsert},
+			wantErr:     fault.ErrInjected,
+			wantRefunds: 1,
+		},
+		{
+			name:        "basic subscription not canceled",
+			from:        models.PlanTypeBasic,
+			to:          models.PlanTypePremium,
+			card:        CardSuccess,
+			rule:        &fault.Rule{Table: "subscription", Op: pkg.OpNameUpdate},
+			wantErr:     fault.ErrInjected,
+			wantRefunds: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServices(t)
+			m := s.subscribe(t, 1, test.from, CardSuccess)
+			s.lapse(t, m, time.Now().Add(10*24*time.Hour))
+			s.setCard(t, m, test.card)
+			if test.rule != nil {
+				test.rule.Err = fault.ErrInjected
+				s.db.Inject(*test.rule)
+			}
+			next, change, err := s.subscription.ChangePlan(m.ID, test.to)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("ChangePlan() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got := s.gateway.Refunded(); got != test.wantRefunds {
+				t.Errorf("refunded %d charges, want %d", got, test.wantRefunds)
+			}
+			if err != nil {
+				// the user keeps the plan they paid for
+				active, err := s.subscription.GetActiveForUser(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if active.ID != m.ID || active.Status != models.SubscriptionStatusActive {
+					t.Errorf("active subscription = %d %s, want %d active", active.ID, active.Status, m.ID)
+				}
+				return
+			}
+			if change.Charged.Minor != test.wantCharged {
+				t.Errorf("charged %v, want %d", change.Charged, test.wantCharged)
+			}
+			if next.Credit.Minor != test.wantCredit {
+				t.Errorf("credit = %v, want %d", next.Credit, test.wantCredit)
+			}
+			inv, err := s.invoices.GetByID(change.InvoiceID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inv.Total.Minor != test.wantCharged || inv.Status != models.InvoiceStatusPaid {
+				t.Errorf("invoice %s for %v, want paid for %d", inv.Status, inv.Total, test.wantCharged)
+			}
+			active, err := s.subscription.GetActiveForUser(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active.ID != next.ID || active.PlanType != test.to {
+				t.Errorf("active subscription = %d on %s, want %d on %s", active.ID, active.PlanType, next.ID, test.to)
+			}
+		})
+	}
+}
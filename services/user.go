@@ -10,12 +10,16 @@ import (
 type User interface {
 	// Create creates a new user
 	Create(*models.User) error
+	// CreateMany creates all the users or none of them
+	CreateMany([]*models.User) error
 	// GetByID returns a user by its ID
 	GetByID(key pkg.PrimaryKey) (*models.User, error)
 	// GetByUsername returns a user by its username
 	GetByUsername(username string) ([]*models.User, error)
 	// FindAll returns all users
 	FindAll() ([]*models.User, error)
+	// Each streams all users to f in ID order until f returns false
+	Each(f func(*models.User) bool) error
 }
 
 type user struct {
@@ -26,6 +30,10 @@ func (u *user) Create(m *models.User) error {
 	return u.r.Create(m)
 }
 
+func (u *user) CreateMany(ms []*models.User) error {
+	return u.r.CreateMany(ms)
+}
+
 func (u *user) GetByID(key pkg.PrimaryKey) (*models.User, error) {
 	return u.r.GetByID(key)
 }
@@ -38,6 +46,10 @@ func (u *user) FindAll() ([]*models.User, error) {
 	return u.r.FindAll()
 }
 
+func (u *user) Each(f func(*models.User) bool) error {
+	return u.r.Each(f)
+}
+
 // NewUser returns a new user service
 func NewUser(r repo.User) User {
 	return &user{r}